@@ -11,6 +11,7 @@ import (
 	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -18,7 +19,9 @@ import (
 
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/internal/identitybinding"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/fallback"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/server"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/utils"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
@@ -54,6 +57,23 @@ var (
 	writeCertAndKeyInSeparateFiles = flag.Bool("write-cert-and-key-in-separate-files", false,
 		"Write cert and key in separate files. The individual files will be named as <secret-name>.crt and <secret-name>.key. These files will be created in addition to the single file.")
 
+	objectFetchConcurrency = flag.Int("object-fetch-concurrency", 4, "default number of objects fetched from Key Vault in parallel for a single mount. "+
+		"Can be overridden per SecretProviderClass with the objectFetchConcurrency parameter")
+
+	clientCacheTTL  = flag.Duration("client-cache-ttl", time.Hour, "how long credentials and Key Vault clients are cached and shared across mounts. Set to 0 to disable the cache")
+	clientCacheSize = flag.Int("client-cache-size", 1000, "maximum number of credentials and Key Vault clients to cache. The least recently used entries are evicted once the cache is full")
+
+	fallbackCacheDir          = flag.String("fallback-cache-dir", "", "directory to store the last fetched objects for SecretProviderClasses with useFallbackCache enabled. The fallback cache is disabled if not set")
+	fallbackCacheKeyFile      = flag.String("fallback-cache-key-file", "", "file containing the 32 byte node key used to encrypt the fallback cache. A new key is generated if the file doesn't exist")
+	fallbackCacheMaxStaleness = flag.Duration("fallback-cache-max-staleness", 24*time.Hour, "maximum age of the objects served from the fallback cache")
+
+	allowedVaultHosts = flag.String("allowed-vault-hosts", "", "comma separated hosts outside of the vault domains of the cloud that can be used in the vaultURI of SecretProviderClasses, "+
+		"for example private endpoints with a custom DNS name. A host starting with *. allows all its subdomains. Tokens are never sent to other hosts")
+
+	keyReleaseAttestationTokenFile = flag.String("key-release-attestation-token-file", "", "file with the attestation token the keys of objects with releaseKey are released with. Mutually exclusive with key-release-attestation-endpoint")
+	keyReleaseAttestationEndpoint  = flag.String("key-release-attestation-endpoint", "", "http or https URL that returns the attestation token the keys of objects with releaseKey are released with")
+	keyReleaseWrappingKeyFile      = flag.String("key-release-wrapping-key-file", "", "PEM file with the RSA private key of the attestation token that released keys are unwrapped with. Key release is disabled if not set")
+
 	cloudName = flag.String("cloud-name", "AzurePublicCloud", "default cloud environment to use for Azure SDK if not provided in the SecretProviderClass. "+
 		"Allowed values: AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud, AzureGermanCloud or AzureStackCloud")
 
@@ -121,6 +141,47 @@ func main() {
 		klog.Infof("write cert and key in separate files feature enabled")
 	}
 
+	if *objectFetchConcurrency < 1 {
+		klog.ErrorS(fmt.Errorf("must be greater than 0"), "invalid object fetch concurrency", "objectFetchConcurrency", *objectFetchConcurrency)
+		os.Exit(1)
+	}
+
+	var fallbackStore *fallback.Store
+	if *fallbackCacheDir != "" {
+		if *fallbackCacheKeyFile == "" {
+			klog.ErrorS(fmt.Errorf("fallback-cache-key-file is required"), "failed to initialize fallback cache")
+			os.Exit(1)
+		}
+		if fallbackStore, err = fallback.New(*fallbackCacheDir, *fallbackCacheKeyFile, *fallbackCacheMaxStaleness); err != nil {
+			klog.ErrorS(err, "failed to initialize fallback cache", "dir", *fallbackCacheDir)
+			os.Exit(1)
+		}
+		klog.InfoS("fallback cache feature enabled", "dir", *fallbackCacheDir, "maxStaleness", *fallbackCacheMaxStaleness)
+	}
+
+	var vaultHosts []string
+	for _, host := range strings.Split(*allowedVaultHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			vaultHosts = append(vaultHosts, host)
+		}
+	}
+	if len(vaultHosts) > 0 {
+		klog.InfoS("vault hosts outside of the vault domains of the cloud allowed", "hosts", vaultHosts)
+	}
+
+	keyRelease := provider.KeyReleaseConfig{
+		AttestationTokenFile: *keyReleaseAttestationTokenFile,
+		AttestationEndpoint:  *keyReleaseAttestationEndpoint,
+		WrappingKeyFile:      *keyReleaseWrappingKeyFile,
+	}
+	if err = keyRelease.Validate(); err != nil {
+		klog.ErrorS(err, "invalid key release configuration")
+		os.Exit(1)
+	}
+	if keyRelease.Enabled() {
+		klog.InfoS("key release feature enabled", "attestationTokenFile", keyRelease.AttestationTokenFile, "attestationEndpoint", keyRelease.AttestationEndpoint)
+	}
+
 	// Initialize and run the gRPC server
 	proto, addr, err := utils.ParseEndpoint(*endpoint)
 	if err != nil {
@@ -152,7 +213,7 @@ func main() {
 		grpc.UnaryInterceptor(utils.LogInterceptor()),
 	}
 	s := grpc.NewServer(opts...)
-	csiDriverProviderServer := server.New(*constructPEMChain, *writeCertAndKeyInSeparateFiles, cloudEnv)
+	csiDriverProviderServer := server.New(*constructPEMChain, *writeCertAndKeyInSeparateFiles, *objectFetchConcurrency, *clientCacheTTL, *clientCacheSize, fallbackStore, vaultHosts, keyRelease, cloudEnv)
 	k8spb.RegisterCSIDriverProviderServer(s, csiDriverProviderServer)
 	// Register the health service.
 	grpc_health_v1.RegisterHealthServer(s, csiDriverProviderServer)
@@ -2,11 +2,17 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest/date"
@@ -125,10 +131,21 @@ type Config struct {
 	IdentityMode IdentityMode
 	// UserAssignedIdentityID is the user-assigned managed identity clientID
 	UserAssignedIdentityID string
+	// UserAssignedIdentityResourceID is the user-assigned managed identity ARM resource ID
+	UserAssignedIdentityResourceID string
+	// UserAssignedIdentityObjectID is the user-assigned managed identity object (principal) ID
+	UserAssignedIdentityObjectID string
 	// AADClientSecret is the client secret for SP access mode
 	AADClientSecret string
 	// AADClientID is the clientID for SP access mode
 	AADClientID string
+	// AADClientCertificate is the PEM or PKCS#12 client certificate and private key for SP access mode
+	AADClientCertificate string
+	// AADClientCertificatePassword is the password of the PKCS#12 client certificate
+	AADClientCertificatePassword string
+	// AADSendCertificateChain sends the certificate chain in the x5c header to support
+	// subject name and issuer (SN+I) authentication
+	AADSendCertificateChain bool
 	// WorkloadIdentityClientID is the clientID used for both workload identity and identity binding.
 	// This clientID can be an Azure AD Application or a Managed identity.
 	WorkloadIdentityClientID string
@@ -137,6 +154,24 @@ type Config struct {
 	// For identity binding, this is the token with audience "api://AKSIdentityBinding".
 	// The token will be exchanged for an Azure AD Token.
 	ServiceAccountToken string
+	// WorkloadIdentityTenantID is the home tenant of the workload identity app used to access a vault
+	// in another tenant. The token of the app is the client assertion of CrossTenantClientID.
+	WorkloadIdentityTenantID string
+	// CrossTenantClientID is the clientID of the app in the tenant of the vault that trusts the tokens
+	// of the workload identity app with a federated identity credential
+	CrossTenantClientID string
+	// CrossTenantAudience is the audience of the token of the workload identity app exchanged for
+	// a token of CrossTenantClientID
+	CrossTenantAudience string
+}
+
+// servicePrincipalCredential is the service principal credential in the nodePublishSecretRef secret
+type servicePrincipalCredential struct {
+	clientID                  string
+	clientSecret              string
+	clientCertificate         string
+	clientCertificatePassword string
+	sendCertificateChain      bool
 }
 
 // saToken represents a single service account token entry in the CSI token request.
@@ -146,15 +181,44 @@ type saToken struct {
 }
 
 type workloadIdentityCredential struct {
+	mu        sync.RWMutex
 	assertion string
 	cred      *azidentity.ClientAssertionCredential
 }
 
+// ServiceAccountTokenSetter is implemented by the credentials that exchange a service account token
+// for an Azure AD token. Kubelet rotates the token so the token of a cached credential is replaced
+// with the token of the latest mount.
+type ServiceAccountTokenSetter interface {
+	SetServiceAccountToken(token string)
+}
+
 type workloadIdentityCredentialOptions struct {
 	azcore.ClientOptions
 	DisableInstanceDiscovery bool
 }
 
+// managedIdentityCredential adds the identity that was requested to the errors of the
+// managed identity credential, the IMDS errors don't say which identity wasn't found
+type managedIdentityCredential struct {
+	identity string
+	cred     *azidentity.ManagedIdentityCredential
+}
+
+// crossTenantCredential gets a token of an app in the tenant of the vault with the token of the
+// workload identity app in its home tenant as the client assertion
+type crossTenantCredential struct {
+	// home is the workload identity credential of the app in the home tenant (hop 1)
+	home         azcore.TokenCredential
+	homeClientID string
+	homeTenantID string
+	homeScope    string
+	// cred is the client assertion credential of the app in the tenant of the vault (hop 2)
+	cred     *azidentity.ClientAssertionCredential
+	clientID string
+	tenantID string
+}
+
 type podIdentityCredential struct {
 	podName      string
 	podNamespace string
@@ -180,10 +244,15 @@ func NewConfig(
 	useWorkloadIdentity := len(workloadIdentityClientID) > 0 && len(serviceAccountToken) > 0
 
 	if mode == IdentityModeNone && !useWorkloadIdentity {
-		var err error
-		if config.AADClientID, config.AADClientSecret, err = getCredential(secrets); err != nil {
+		cred, err := getCredential(secrets)
+		if err != nil {
 			return config, err
 		}
+		config.AADClientID = cred.clientID
+		config.AADClientSecret = cred.clientSecret
+		config.AADClientCertificate = cred.clientCertificate
+		config.AADClientCertificatePassword = cred.clientCertificatePassword
+		config.AADSendCertificateChain = cred.sendCertificateChain
 	}
 
 	return config, nil
@@ -195,7 +264,7 @@ func (c Config) GetCredential(podName, podNamespace, resource, aadEndpoint, tena
 	case IdentityModePodIdentity:
 		return getPodIdentityTokenCredential(podName, podNamespace, resource, tenantID, nmiPort)
 	case IdentityModeVMManagedIdentity:
-		return getManagedIdentityTokenCredential(c.UserAssignedIdentityID)
+		return getManagedIdentityTokenCredential(c.UserAssignedIdentityID, c.UserAssignedIdentityResourceID, c.UserAssignedIdentityObjectID)
 	case IdentityModeAzureTokenProxy:
 		if len(c.WorkloadIdentityClientID) == 0 || len(c.ServiceAccountToken) == 0 {
 			return nil, fmt.Errorf("workload identity client ID and service account token are required for identity binding")
@@ -203,9 +272,15 @@ func (c Config) GetCredential(podName, podNamespace, resource, aadEndpoint, tena
 		return getIdentityBindingTokenCredential(c.WorkloadIdentityClientID, c.ServiceAccountToken, aadEndpoint, tenantID)
 	case IdentityModeNone:
 		// Try workload identity, then service principal
+		if len(c.WorkloadIdentityClientID) > 0 && len(c.ServiceAccountToken) > 0 && len(c.CrossTenantClientID) > 0 {
+			return getCrossTenantTokenCredential(c.WorkloadIdentityClientID, c.ServiceAccountToken, c.WorkloadIdentityTenantID, c.CrossTenantClientID, c.CrossTenantAudience, aadEndpoint, tenantID)
+		}
 		if len(c.WorkloadIdentityClientID) > 0 && len(c.ServiceAccountToken) > 0 {
 			return getWorkloadIdentityTokenCredential(c.WorkloadIdentityClientID, c.ServiceAccountToken, aadEndpoint, tenantID)
 		}
+		if len(c.AADClientCertificate) > 0 && len(c.AADClientID) > 0 {
+			return getServicePrincipalCertificateTokenCredential(c.AADClientID, c.AADClientCertificate, c.AADClientCertificatePassword, c.AADSendCertificateChain, aadEndpoint, tenantID)
+		}
 		if len(c.AADClientSecret) > 0 && len(c.AADClientID) > 0 {
 			return getServicePrincipalTokenCredential(c.AADClientID, c.AADClientSecret, aadEndpoint, tenantID)
 		}
@@ -215,7 +290,52 @@ func (c Config) GetCredential(podName, podNamespace, resource, aadEndpoint, tena
 	}
 }
 
-func newWorkloadIdentityCredential(tenantID, clientID, assertion string, options *workloadIdentityCredentialOptions) (azcore.TokenCredential, error) {
+// CacheKey returns a key that uniquely identifies the credential returned by GetCredential
+// for the same arguments. Secret material (client secret, client certificate) is hashed
+// into the key so a cached credential is never shared with a caller that presents
+// different credentials for the same client ID. Service account tokens are rotated by
+// kubelet, so the key has the service account and the audience of the token instead of
+// the token, and the token of the cached credential is replaced with SetServiceAccountToken.
+func (c Config) CacheKey(podName, podNamespace, aadEndpoint, tenantID string) string {
+	parts := []string{c.IdentityMode.String(), tenantID, aadEndpoint}
+	switch c.IdentityMode {
+	case IdentityModePodIdentity:
+		// the identity is assigned to the pod by NMI
+		parts = append(parts, podNamespace, podName)
+	case IdentityModeVMManagedIdentity:
+		parts = append(parts, c.UserAssignedIdentityID, c.UserAssignedIdentityResourceID, c.UserAssignedIdentityObjectID)
+	case IdentityModeAzureTokenProxy:
+		parts = append(parts, c.WorkloadIdentityClientID, serviceAccountTokenKey(c.ServiceAccountToken))
+	case IdentityModeNone:
+		if len(c.WorkloadIdentityClientID) > 0 && len(c.ServiceAccountToken) > 0 {
+			parts = append(parts, "workloadIdentity", c.WorkloadIdentityClientID, serviceAccountTokenKey(c.ServiceAccountToken),
+				c.WorkloadIdentityTenantID, c.CrossTenantClientID, c.CrossTenantAudience)
+		} else {
+			parts = append(parts, "servicePrincipal", c.AADClientID, hashSecret(c.AADClientSecret),
+				hashSecret(c.AADClientCertificate), hashSecret(c.AADClientCertificatePassword), strconv.FormatBool(c.AADSendCertificateChain))
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// serviceAccountTokenKey returns the service account and the audiences of the service account token.
+// The token is hashed if its claims can't be decoded.
+func serviceAccountTokenKey(token string) string {
+	claims, err := decodeServiceAccountToken(token)
+	if err != nil || claims.Subject == "" {
+		return hashSecret(token)
+	}
+	audiences := append([]string(nil), claims.Audience...)
+	sort.Strings(audiences)
+	return claims.Subject + "|" + strings.Join(audiences, ",")
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newWorkloadIdentityCredential(tenantID, clientID, assertion string, options *workloadIdentityCredentialOptions) (*workloadIdentityCredential, error) {
 	w := &workloadIdentityCredential{assertion: assertion}
 	cred, err := azidentity.NewClientAssertionCredential(tenantID, clientID, w.getAssertion, &azidentity.ClientAssertionCredentialOptions{
 		ClientOptions:            options.ClientOptions,
@@ -233,9 +353,18 @@ func (w *workloadIdentityCredential) GetToken(ctx context.Context, opts policy.T
 }
 
 func (w *workloadIdentityCredential) getAssertion(context.Context) (string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	return w.assertion, nil
 }
 
+// SetServiceAccountToken replaces the service account token used as the client assertion
+func (w *workloadIdentityCredential) SetServiceAccountToken(token string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.assertion = token
+}
+
 func getWorkloadIdentityTokenCredential(clientID, signedAssertion, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
 	opts := &workloadIdentityCredentialOptions{
 		ClientOptions: azcore.ClientOptions{
@@ -244,7 +373,81 @@ func getWorkloadIdentityTokenCredential(clientID, signedAssertion, aadEndpoint,
 			},
 		},
 	}
-	return newWorkloadIdentityCredential(tenantID, clientID, signedAssertion, opts)
+	cred, err := newWorkloadIdentityCredential(tenantID, clientID, signedAssertion, opts)
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func getCrossTenantTokenCredential(homeClientID, signedAssertion, homeTenantID, clientID, audience, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
+	klog.V(5).InfoS("using cross tenant workload identity to retrieve token", "homeClientID", homeClientID, "homeTenantID", homeTenantID, "clientID", clientID, "tenantID", tenantID)
+
+	if len(homeTenantID) == 0 {
+		return nil, fmt.Errorf("home tenant ID of the workload identity app is required for cross tenant access")
+	}
+	if len(audience) == 0 {
+		audience = DefaultTokenAudience
+	}
+	clientOptions := azcore.ClientOptions{
+		Cloud: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: aadEndpoint,
+		},
+	}
+	home, err := newWorkloadIdentityCredential(homeTenantID, homeClientID, signedAssertion, &workloadIdentityCredentialOptions{ClientOptions: clientOptions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential for workload identity app %s in home tenant %s (hop 1 of 2), error: %w", homeClientID, homeTenantID, err)
+	}
+	c := &crossTenantCredential{
+		home:         home,
+		homeClientID: homeClientID,
+		homeTenantID: homeTenantID,
+		homeScope:    getScope(audience),
+		clientID:     clientID,
+		tenantID:     tenantID,
+	}
+	if c.cred, err = azidentity.NewClientAssertionCredential(tenantID, clientID, c.getAssertion, &azidentity.ClientAssertionCredentialOptions{ClientOptions: clientOptions}); err != nil {
+		return nil, fmt.Errorf("failed to create credential for app %s in tenant %s (hop 2 of 2), error: %w", clientID, tenantID, err)
+	}
+	return c, nil
+}
+
+// homeTokenErrorKey is the context key of the error of getting the token of the app in the home tenant,
+// the first hop, in a GetToken call. The client assertion credential doesn't wrap the error of the
+// assertion callback, so it's passed back to GetToken in the context.
+type homeTokenErrorKey struct{}
+
+// GetToken gets the token of the app in the tenant of the vault with the token of the app in the home
+// tenant as the client assertion. A failure is reported for the hop it happened in.
+func (c *crossTenantCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	var homeErr error
+	token, err := c.cred.GetToken(context.WithValue(ctx, homeTokenErrorKey{}, &homeErr), opts)
+	if homeErr != nil {
+		return azcore.AccessToken{}, homeErr
+	}
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to exchange the token of workload identity app %s for a token of app %s in tenant %s (hop 2 of 2), ensure the app has a federated identity credential for the app, error: %w", c.homeClientID, c.clientID, c.tenantID, err)
+	}
+	return token, nil
+}
+
+// SetServiceAccountToken replaces the service account token of the workload identity app in the home tenant
+func (c *crossTenantCredential) SetServiceAccountToken(token string) {
+	if home, ok := c.home.(ServiceAccountTokenSetter); ok {
+		home.SetServiceAccountToken(token)
+	}
+}
+
+func (c *crossTenantCredential) getAssertion(ctx context.Context) (string, error) {
+	token, err := c.home.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{c.homeScope}})
+	if err != nil {
+		err = fmt.Errorf("failed to get token for workload identity app %s in home tenant %s (hop 1 of 2), error: %w", c.homeClientID, c.homeTenantID, err)
+		if homeErr, ok := ctx.Value(homeTokenErrorKey{}).(*error); ok {
+			*homeErr = err
+		}
+		return "", err
+	}
+	return token.Token, nil
 }
 
 func getIdentityBindingTokenCredential(clientID, signedAssertion, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
@@ -272,7 +475,11 @@ func getIdentityBindingTokenCredential(clientID, signedAssertion, aadEndpoint, t
 	// Use the proxy transport extracted from the SDK via reflect
 	opts.ClientOptions.Transport = proxyTransport
 
-	return newWorkloadIdentityCredential(tenantID, clientID, signedAssertion, opts)
+	cred, err := newWorkloadIdentityCredential(tenantID, clientID, signedAssertion, opts)
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
 }
 
 func getServicePrincipalTokenCredential(clientID, secret, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
@@ -286,12 +493,71 @@ func getServicePrincipalTokenCredential(clientID, secret, aadEndpoint, tenantID
 	return azidentity.NewClientSecretCredential(tenantID, clientID, secret, opts)
 }
 
-func getManagedIdentityTokenCredential(identityClientID string) (azcore.TokenCredential, error) {
+// decodeClientCertificate returns the PEM client certificate as is, or the base64 encoded PFX decoded.
+// The nodePublishSecretRef secrets are sent to the provider as JSON strings, so a binary PFX must be
+// base64 encoded to not be mangled.
+func decodeClientCertificate(certificate string) ([]byte, error) {
+	if strings.Contains(certificate, "-----BEGIN") {
+		return []byte(certificate), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certificate), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse clientcertificate, must be PEM or a base64 encoded PFX, error: %w", err)
+	}
+	return data, nil
+}
+
+func getServicePrincipalCertificateTokenCredential(clientID, certificate, password string, sendCertificateChain bool, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
+	var pw []byte
+	if len(password) > 0 {
+		pw = []byte(password)
+	}
+	data, err := decodeClientCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	certs, key, err := azidentity.ParseCertificates(data, pw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse clientcertificate, error: %w", err)
+	}
+	opts := &azidentity.ClientCertificateCredentialOptions{
+		ClientOptions: azcore.ClientOptions{
+			Cloud: cloud.Configuration{
+				ActiveDirectoryAuthorityHost: aadEndpoint,
+			},
+		},
+		SendCertificateChain: sendCertificateChain,
+	}
+	return azidentity.NewClientCertificateCredential(tenantID, clientID, certs, key, opts)
+}
+
+func getManagedIdentityTokenCredential(identityClientID, identityResourceID, identityObjectID string) (azcore.TokenCredential, error) {
 	opts := &azidentity.ManagedIdentityCredentialOptions{}
-	if len(identityClientID) > 0 {
+	identity := "system-assigned identity"
+	switch {
+	case len(identityClientID) > 0:
 		opts.ID = azidentity.ClientID(identityClientID)
+		identity = fmt.Sprintf("user-assigned identity with client ID %s", identityClientID)
+	case len(identityResourceID) > 0:
+		opts.ID = azidentity.ResourceID(identityResourceID)
+		identity = fmt.Sprintf("user-assigned identity with resource ID %s", identityResourceID)
+	case len(identityObjectID) > 0:
+		opts.ID = azidentity.ObjectID(identityObjectID)
+		identity = fmt.Sprintf("user-assigned identity with object ID %s", identityObjectID)
+	}
+	cred, err := azidentity.NewManagedIdentityCredential(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed identity credential for the %s, error: %w", identity, err)
 	}
-	return azidentity.NewManagedIdentityCredential(opts)
+	return &managedIdentityCredential{identity: identity, cred: cred}, nil
+}
+
+func (c *managedIdentityCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := c.cred.GetToken(ctx, opts)
+	if err != nil {
+		return token, fmt.Errorf("failed to get token from IMDS for the %s, ensure the identity is assigned to the node, error: %w", c.identity, err)
+	}
+	return token, nil
 }
 
 func (c *podIdentityCredential) GetToken(ctx context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
@@ -355,29 +621,48 @@ func getPodIdentityTokenCredential(podName, podNamespace, resource, tenantID, nm
 	}, nil
 }
 
-// getCredential gets clientid and clientsecret from the secrets
-func getCredential(secrets map[string]string) (string, string, error) {
+// getCredential gets clientid and either clientsecret or clientcertificate from the secrets
+func getCredential(secrets map[string]string) (servicePrincipalCredential, error) {
+	var cred servicePrincipalCredential
 	if secrets == nil {
-		return "", "", fmt.Errorf("failed to get credentials, nodePublishSecretRef secret is not set")
+		return cred, fmt.Errorf("failed to get credentials, nodePublishSecretRef secret is not set")
 	}
 
-	var clientID, clientSecret string
+	var sendCertificateChain string
 	for k, v := range secrets {
 		switch strings.ToLower(k) {
 		case "clientid":
-			clientID = v
+			cred.clientID = v
 		case "clientsecret":
-			clientSecret = v
+			cred.clientSecret = v
+		case "clientcertificate":
+			cred.clientCertificate = v
+		case "clientcertificatepassword":
+			cred.clientCertificatePassword = v
+		case "sendcertificatechain":
+			sendCertificateChain = v
 		}
 	}
 
-	if clientID == "" {
-		return "", "", fmt.Errorf("could not find clientid in secrets")
+	if cred.clientID == "" {
+		return servicePrincipalCredential{}, fmt.Errorf("could not find clientid in secrets")
+	}
+	if cred.clientSecret != "" && cred.clientCertificate != "" {
+		return servicePrincipalCredential{}, fmt.Errorf("clientsecret and clientcertificate are mutually exclusive in secrets")
 	}
-	if clientSecret == "" {
-		return "", "", fmt.Errorf("could not find clientsecret in secrets")
+	if cred.clientSecret == "" && cred.clientCertificate == "" {
+		return servicePrincipalCredential{}, fmt.Errorf("could not find clientsecret or clientcertificate in secrets")
 	}
-	return clientID, clientSecret, nil
+	if cred.clientCertificate == "" && (cred.clientCertificatePassword != "" || sendCertificateChain != "") {
+		return servicePrincipalCredential{}, fmt.Errorf("clientcertificatepassword and sendcertificatechain are only supported with clientcertificate")
+	}
+	if sendCertificateChain != "" {
+		var err error
+		if cred.sendCertificateChain, err = strconv.ParseBool(sendCertificateChain); err != nil {
+			return servicePrincipalCredential{}, fmt.Errorf("failed to parse sendcertificatechain in secrets, error: %w", err)
+		}
+	}
+	return cred, nil
 }
 
 // ParseServiceAccountToken parses the bound service account token for the
@@ -387,6 +672,23 @@ func ParseServiceAccountToken(saTokens string) (string, error) {
 	return parseTokenForAudience(saTokens, DefaultTokenAudience)
 }
 
+// ParseServiceAccountTokenForAudience parses the bound service account token for the audience
+// from the tokens passed from driver as part of MountRequest. The token is validated to be
+// issued for the audience and to not be expired before it's exchanged for an Azure AD token.
+func ParseServiceAccountTokenForAudience(saTokens, audience string) (string, error) {
+	token, err := parseTokenForAudience(saTokens, audience)
+	if errors.Is(err, ErrServiceAccountTokensNotFound) {
+		return "", fmt.Errorf("%w, add the audience %s to the tokenRequests of the secrets-store.csi.k8s.io CSIDriver", err, audience)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = validateServiceAccountToken(token, audience, time.Now()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
 // ParseIdentityBindingToken parses the service account token for the
 // identity binding audience from the tokens passed from driver as part of MountRequest.
 func ParseIdentityBindingToken(saTokens string) (string, error) {
@@ -408,11 +710,84 @@ func parseTokenForAudience(saTokens, audience string) (string, error) {
 
 	entry, ok := tokens[audience]
 	if !ok || entry.Token == "" {
-		return "", fmt.Errorf("token for audience %s not found", audience)
+		audiences := make([]string, 0, len(tokens))
+		for aud := range tokens {
+			audiences = append(audiences, aud)
+		}
+		sort.Strings(audiences)
+		return "", fmt.Errorf("token for audience %s not found, the tokenRequests of the secrets-store.csi.k8s.io CSIDriver have the audiences [%s]", audience, strings.Join(audiences, ", "))
 	}
 	return entry.Token, nil
 }
 
+// tokenClaims are the claims of the service account token that are validated before the exchange
+type tokenClaims struct {
+	Subject   string        `json:"sub"`
+	Audience  tokenAudience `json:"aud"`
+	ExpiresAt int64         `json:"exp"`
+}
+
+// tokenAudience is the aud claim of a JWT, a string or an array of strings
+type tokenAudience []string
+
+func (a *tokenAudience) UnmarshalJSON(data []byte) error {
+	var aud string
+	if err := json.Unmarshal(data, &aud); err == nil {
+		*a = tokenAudience{aud}
+		return nil
+	}
+	var auds []string
+	if err := json.Unmarshal(data, &auds); err != nil {
+		return err
+	}
+	*a = auds
+	return nil
+}
+
+// decodeServiceAccountToken decodes the claims of the service account token without verifying its signature
+func decodeServiceAccountToken(token string) (tokenClaims, error) {
+	var claims tokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("has claims that can't be decoded, error: %w", err)
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("has claims that can't be unmarshaled, error: %w", err)
+	}
+	return claims, nil
+}
+
+// serviceAccountTokenLeeway is the clock skew allowed when the expiry of the service account token is validated
+const serviceAccountTokenLeeway = 10 * time.Second
+
+// validateServiceAccountToken validates the aud claim of the service account token contains
+// the audience and the token isn't expired, with a leeway for clock skew. The signature is verified by Azure AD.
+func validateServiceAccountToken(token, audience string, now time.Time) error {
+	claims, err := decodeServiceAccountToken(token)
+	if err != nil {
+		return fmt.Errorf("service account token for audience %s %w", audience, err)
+	}
+
+	found := false
+	for _, aud := range claims.Audience {
+		found = found || aud == audience
+	}
+	if !found {
+		return fmt.Errorf("service account token for audience %s was issued for the audiences [%s]", audience, strings.Join(claims.Audience, ", "))
+	}
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("service account token for audience %s doesn't have an exp claim", audience)
+	}
+	if expires := time.Unix(claims.ExpiresAt, 0); !now.Before(expires.Add(serviceAccountTokenLeeway)) {
+		return fmt.Errorf("service account token for audience %s expired at %s", audience, expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
 func getScope(resource string) string {
 	scope := resource
 	if !strings.HasSuffix(resource, "/.default") {
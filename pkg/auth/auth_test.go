@@ -1,10 +1,29 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 )
 
 // mockTransporter is a simple mock that satisfies policy.Transporter for tests.
@@ -82,11 +101,10 @@ func TestNewConfig(t *testing.T) {
 
 func TestGetCredential(t *testing.T) {
 	cases := []struct {
-		desc                 string
-		secrets              map[string]string
-		expectedClientID     string
-		expectedClientSecret string
-		expectedErr          bool
+		desc         string
+		secrets      map[string]string
+		expectedCred servicePrincipalCredential
+		expectedErr  bool
 	}{
 		{
 			desc:        "client secret missing for service principal mode",
@@ -99,30 +117,140 @@ func TestGetCredential(t *testing.T) {
 			expectedErr: true,
 		},
 		{
-			desc:                 "returns correct client id and client secret",
-			secrets:              map[string]string{"clientid": "testclientid", "clientsecret": "testclientsecret"},
-			expectedClientID:     "testclientid",
-			expectedClientSecret: "testclientsecret",
-			expectedErr:          false,
+			desc:        "client secret and client certificate both set",
+			secrets:     map[string]string{"clientid": "testclientid", "clientsecret": "testclientsecret", "clientcertificate": "testcert"},
+			expectedErr: true,
+		},
+		{
+			desc:        "client certificate password without client certificate",
+			secrets:     map[string]string{"clientid": "testclientid", "clientsecret": "testclientsecret", "clientcertificatepassword": "testpassword"},
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid sendcertificatechain",
+			secrets:     map[string]string{"clientid": "testclientid", "clientcertificate": "testcert", "sendcertificatechain": "yes"},
+			expectedErr: true,
+		},
+		{
+			desc:         "returns correct client id and client secret",
+			secrets:      map[string]string{"clientid": "testclientid", "clientsecret": "testclientsecret"},
+			expectedCred: servicePrincipalCredential{clientID: "testclientid", clientSecret: "testclientsecret"},
+			expectedErr:  false,
+		},
+		{
+			desc:    "returns correct client id and client certificate",
+			secrets: map[string]string{"clientId": "testclientid", "clientCertificate": "testcert", "clientCertificatePassword": "testpassword", "sendCertificateChain": "true"},
+			expectedCred: servicePrincipalCredential{
+				clientID:                  "testclientid",
+				clientCertificate:         "testcert",
+				clientCertificatePassword: "testpassword",
+				sendCertificateChain:      true,
+			},
+			expectedErr: false,
 		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			clientID, clientSecret, err := getCredential(tc.secrets)
+			cred, err := getCredential(tc.secrets)
 			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
 				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
 			}
-			if clientID != tc.expectedClientID {
-				t.Fatalf("expected clientID: %v, got: %v", tc.expectedClientID, clientID)
-			}
-			if clientSecret != tc.expectedClientSecret {
-				t.Fatalf("expected client secret: %v, got: %v", tc.expectedClientSecret, clientSecret)
+			if cred != tc.expectedCred {
+				t.Fatalf("expected credential: %+v, got: %+v", tc.expectedCred, cred)
 			}
 		})
 	}
 }
 
+func TestGetCredential_ClientCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certificate := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	config, err := NewConfig(IdentityModeNone, "", "", "", map[string]string{
+		"clientid":             "testclientid",
+		"clientcertificate":    certificate,
+		"sendcertificatechain": "true",
+	})
+	if err != nil {
+		t.Fatalf("NewConfig() unexpected error: %v", err)
+	}
+	cred, err := config.GetCredential("test-pod", "default", "https://vault.azure.net", "https://login.microsoftonline.com/", "test-tenant-id", "2579")
+	if err != nil {
+		t.Fatalf("GetCredential() unexpected error: %v", err)
+	}
+	if _, ok := cred.(*azidentity.ClientCertificateCredential); !ok {
+		t.Fatalf("GetCredential() = %T, want *azidentity.ClientCertificateCredential", cred)
+	}
+
+	config.AADClientCertificate = "invalid"
+	if _, err = config.GetCredential("test-pod", "default", "https://vault.azure.net", "https://login.microsoftonline.com/", "test-tenant-id", "2579"); err == nil || !strings.Contains(err.Error(), "failed to parse clientcertificate") {
+		t.Fatalf("GetCredential() = %v, want failed to parse clientcertificate error", err)
+	}
+}
+
+func TestGetCredential_ClientCertificatePFX(t *testing.T) {
+	pfx, err := os.ReadFile(filepath.Join("testdata", "clientcertificate.pfx"))
+	if err != nil {
+		t.Fatalf("failed to read PFX: %v", err)
+	}
+
+	// the secrets are sent to the provider as a JSON object of strings
+	sendSecrets := func(secrets map[string]string) map[string]string {
+		data, err := json.Marshal(secrets)
+		if err != nil {
+			t.Fatalf("failed to marshal secrets: %v", err)
+		}
+		var received map[string]string
+		if err = json.Unmarshal(data, &received); err != nil {
+			t.Fatalf("failed to unmarshal secrets: %v", err)
+		}
+		return received
+	}
+	getCredential := func(certificate string) (azcore.TokenCredential, error) {
+		config, err := NewConfig(IdentityModeNone, "", "", "", sendSecrets(map[string]string{
+			"clientid":                  "testclientid",
+			"clientcertificate":         certificate,
+			"clientcertificatepassword": "password",
+		}))
+		if err != nil {
+			t.Fatalf("NewConfig() unexpected error: %v", err)
+		}
+		return config.GetCredential("test-pod", "default", "https://vault.azure.net", "https://login.microsoftonline.com/", "test-tenant-id", "2579")
+	}
+
+	cred, err := getCredential(base64.StdEncoding.EncodeToString(pfx))
+	if err != nil {
+		t.Fatalf("GetCredential() unexpected error: %v", err)
+	}
+	if _, ok := cred.(*azidentity.ClientCertificateCredential); !ok {
+		t.Fatalf("GetCredential() = %T, want *azidentity.ClientCertificateCredential", cred)
+	}
+
+	// the binary PFX isn't valid UTF-8 and is mangled in the JSON secrets
+	if _, err = getCredential(string(pfx)); err == nil || !strings.Contains(err.Error(), "must be PEM or a base64 encoded PFX") {
+		t.Fatalf("GetCredential() = %v, want base64 encoded PFX error", err)
+	}
+}
+
 func TestParseServiceAccountTokenError(t *testing.T) {
 	cases := []struct {
 		desc     string
@@ -168,6 +296,103 @@ func TestParseServiceAccountToken(t *testing.T) {
 	}
 }
 
+// newTestJWT returns an unsigned JWT with the claims
+func newTestJWT(t *testing.T, claims string) string {
+	t.Helper()
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"RS256"}`)) + "." + encode([]byte(claims)) + ".signature"
+}
+
+func TestParseServiceAccountTokenForAudience(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	validToken := newTestJWT(t, fmt.Sprintf(`{"aud":["api://AzureADTokenExchangeUSGov","api://other"],"exp":%d}`, exp))
+	stringAudToken := newTestJWT(t, fmt.Sprintf(`{"aud":"api://AzureADTokenExchangeUSGov","exp":%d}`, exp))
+	tokens := func(token string) string {
+		return fmt.Sprintf(`{"api://AzureADTokenExchangeUSGov":{"token":%q,"expirationTimestamp":"2099-01-01T00:00:00Z"},"gcp":{"token":"gcp-token"}}`, token)
+	}
+
+	cases := []struct {
+		desc        string
+		saTokens    string
+		audience    string
+		expectedErr string
+	}{
+		{
+			desc:     "aud claim array",
+			saTokens: tokens(validToken),
+			audience: "api://AzureADTokenExchangeUSGov",
+		},
+		{
+			desc:     "aud claim string",
+			saTokens: tokens(stringAudToken),
+			audience: "api://AzureADTokenExchangeUSGov",
+		},
+		{
+			desc:        "tokens not passed by the driver",
+			saTokens:    "",
+			audience:    "api://AzureADTokenExchangeUSGov",
+			expectedErr: "service account tokens not found, add the audience api://AzureADTokenExchangeUSGov to the tokenRequests of the secrets-store.csi.k8s.io CSIDriver",
+		},
+		{
+			desc:        "audience not in the tokenRequests",
+			saTokens:    tokens(validToken),
+			audience:    DefaultTokenAudience,
+			expectedErr: "token for audience api://AzureADTokenExchange not found, the tokenRequests of the secrets-store.csi.k8s.io CSIDriver have the audiences [api://AzureADTokenExchangeUSGov, gcp]",
+		},
+		{
+			desc:        "aud claim doesn't match",
+			saTokens:    tokens(newTestJWT(t, fmt.Sprintf(`{"aud":["api://other"],"exp":%d}`, exp))),
+			audience:    "api://AzureADTokenExchangeUSGov",
+			expectedErr: "service account token for audience api://AzureADTokenExchangeUSGov was issued for the audiences [api://other]",
+		},
+		{
+			desc:        "expired",
+			saTokens:    tokens(newTestJWT(t, `{"aud":["api://AzureADTokenExchangeUSGov"],"exp":1643234647}`)),
+			audience:    "api://AzureADTokenExchangeUSGov",
+			expectedErr: "service account token for audience api://AzureADTokenExchangeUSGov expired at 2022-01-26T22:04:07Z",
+		},
+		{
+			desc:     "expired within the leeway",
+			saTokens: tokens(newTestJWT(t, fmt.Sprintf(`{"aud":["api://AzureADTokenExchangeUSGov"],"exp":%d}`, time.Now().Add(-2*time.Second).Unix()))),
+			audience: "api://AzureADTokenExchangeUSGov",
+		},
+		{
+			desc:        "exp claim missing",
+			saTokens:    tokens(newTestJWT(t, `{"aud":["api://AzureADTokenExchangeUSGov"]}`)),
+			audience:    "api://AzureADTokenExchangeUSGov",
+			expectedErr: "service account token for audience api://AzureADTokenExchangeUSGov doesn't have an exp claim",
+		},
+		{
+			desc:        "not a JWT",
+			saTokens:    tokens("token"),
+			audience:    "api://AzureADTokenExchangeUSGov",
+			expectedErr: "service account token for audience api://AzureADTokenExchangeUSGov is not a JWT",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			token, err := ParseServiceAccountTokenForAudience(tc.saTokens, tc.audience)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("ParseServiceAccountTokenForAudience() = %v, want %s", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseServiceAccountTokenForAudience() = %v, want nil", err)
+			}
+			if !strings.Contains(tc.saTokens, token) {
+				t.Errorf("ParseServiceAccountTokenForAudience() = %s, want the token for audience %s", token, tc.audience)
+			}
+		})
+	}
+
+	if _, err := ParseServiceAccountTokenForAudience("", DefaultTokenAudience); !errors.Is(err, ErrServiceAccountTokensNotFound) {
+		t.Errorf("ParseServiceAccountTokenForAudience() = %v, want ErrServiceAccountTokensNotFound", err)
+	}
+}
+
 func TestGetScope(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -337,30 +562,222 @@ func TestGetCredential_IdentityBinding_MissingFields(t *testing.T) {
 	}
 }
 
+// fakeTokenCredential returns the token or the error and records the requested scopes
+type fakeTokenCredential struct {
+	scopes []string
+	err    error
+}
+
+func (f *fakeTokenCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.scopes = append(f.scopes, opts.Scopes...)
+	if f.err != nil {
+		return azcore.AccessToken{}, f.err
+	}
+	return azcore.AccessToken{Token: "home-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// failingTransporter fails all the requests to Azure AD
+type failingTransporter struct {
+	requests int
+}
+
+func (f *failingTransporter) Do(req *http.Request) (*http.Response, error) {
+	f.requests++
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error":"invalid_client","error_description":"AADSTS700211: No matching federated identity record found"}`)),
+		Request:    req,
+	}, nil
+}
+
+// discoveryTransporter serves the OpenID configuration of the tenants and fails the token requests
+type discoveryTransporter struct {
+	failingTransporter
+}
+
+func (d *discoveryTransporter) Do(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/.well-known/openid-configuration") {
+		return d.failingTransporter.Do(req)
+	}
+	tenant := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	authority := "https://" + req.URL.Host + "/" + tenant
+	body := fmt.Sprintf(`{"token_endpoint":"%[1]s/oauth2/v2.0/token","authorization_endpoint":"%[1]s/oauth2/v2.0/authorize","issuer":"%[1]s/v2.0"}`, authority)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestGetCredential_CrossTenant(t *testing.T) {
+	config := Config{
+		WorkloadIdentityClientID: "home-client-id",
+		ServiceAccountToken:      "token",
+		WorkloadIdentityTenantID: "home-tenant-id",
+		CrossTenantClientID:      "client-id",
+	}
+	cred, err := config.GetCredential("test-pod", "default", "https://vault.azure.net", "https://login.microsoftonline.com/", "tenant-id", "2579")
+	if err != nil {
+		t.Fatalf("GetCredential() unexpected error: %v", err)
+	}
+	crossTenant, ok := cred.(*crossTenantCredential)
+	if !ok {
+		t.Fatalf("GetCredential() = %T, want *crossTenantCredential", cred)
+	}
+	if crossTenant.homeScope != "api://AzureADTokenExchange/.default" || crossTenant.homeTenantID != "home-tenant-id" || crossTenant.tenantID != "tenant-id" {
+		t.Errorf("GetCredential() = %+v, want the home scope and tenants of the config", crossTenant)
+	}
+
+	config.WorkloadIdentityTenantID = ""
+	if _, err = config.GetCredential("test-pod", "default", "https://vault.azure.net", "https://login.microsoftonline.com/", "tenant-id", "2579"); err == nil {
+		t.Errorf("GetCredential() = nil, want error for a missing home tenant")
+	}
+}
+
+func TestCrossTenantCredentialHopErrors(t *testing.T) {
+	newCredential := func(t *testing.T, home azcore.TokenCredential, transport policy.Transporter) *crossTenantCredential {
+		c := &crossTenantCredential{
+			home:         home,
+			homeClientID: "home-client-id",
+			homeTenantID: "home-tenant-id",
+			homeScope:    "api://AzureADTokenExchangeChina/.default",
+			clientID:     "client-id",
+			tenantID:     "tenant-id",
+		}
+		var err error
+		c.cred, err = azidentity.NewClientAssertionCredential(c.tenantID, c.clientID, c.getAssertion, &azidentity.ClientAssertionCredentialOptions{
+			ClientOptions:            azcore.ClientOptions{Transport: transport},
+			DisableInstanceDiscovery: true,
+		})
+		if err != nil {
+			t.Fatalf("NewClientAssertionCredential() unexpected error: %v", err)
+		}
+		return c
+	}
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}
+
+	// hop 1 fails, no token is requested from the tenant of the vault
+	home := &fakeTokenCredential{err: errors.New("AADSTS70021: No matching federated identity record found")}
+	transport := &discoveryTransporter{}
+	_, err := newCredential(t, home, transport).GetToken(testContext(t), opts)
+	expectedErr := "failed to get token for workload identity app home-client-id in home tenant home-tenant-id (hop 1 of 2), error: AADSTS70021: No matching federated identity record found"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("GetToken() = %v, want %s", err, expectedErr)
+	}
+	if transport.requests != 0 {
+		t.Errorf("expected no requests for hop 2, got %d", transport.requests)
+	}
+	if !reflect.DeepEqual(home.scopes, []string{"api://AzureADTokenExchangeChina/.default"}) {
+		t.Errorf("expected home token to be requested for the cross tenant audience, got %v", home.scopes)
+	}
+
+	// hop 2 fails
+	_, err = newCredential(t, &fakeTokenCredential{}, transport).GetToken(testContext(t), opts)
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to exchange the token of workload identity app home-client-id for a token of app client-id in tenant tenant-id (hop 2 of 2)") {
+		t.Fatalf("GetToken() = %v, want hop 2 error", err)
+	}
+}
+
 func TestGetManagedIdentityTokenCredential(t *testing.T) {
 	tests := []struct {
-		name             string
-		identityClientID string
+		name               string
+		identityClientID   string
+		identityResourceID string
+		identityObjectID   string
+		expectedIdentity   string
 	}{
 		{
 			name:             "system-assigned identity with empty client ID",
 			identityClientID: "",
+			expectedIdentity: "system-assigned identity",
 		},
 		{
 			name:             "user-assigned identity with client ID",
 			identityClientID: "user-assigned-client-id",
+			expectedIdentity: "user-assigned identity with client ID user-assigned-client-id",
+		},
+		{
+			name:               "user-assigned identity with resource ID",
+			identityResourceID: "/subscriptions/sid/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+			expectedIdentity:   "user-assigned identity with resource ID /subscriptions/sid/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+		},
+		{
+			name:             "user-assigned identity with object ID",
+			identityObjectID: "user-assigned-object-id",
+			expectedIdentity: "user-assigned identity with object ID user-assigned-object-id",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cred, err := getManagedIdentityTokenCredential(tt.identityClientID)
+			cred, err := getManagedIdentityTokenCredential(tt.identityClientID, tt.identityResourceID, tt.identityObjectID)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
 			if cred == nil {
 				t.Fatal("expected non-nil credential")
 			}
+			if identity := cred.(*managedIdentityCredential).identity; identity != tt.expectedIdentity {
+				t.Errorf("expected identity %q, got %q", tt.expectedIdentity, identity)
+			}
 		})
 	}
 }
+
+func TestConfigCacheKey(t *testing.T) {
+	base := Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: "token1"}
+	key := base.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid")
+
+	if key != base.CacheKey("pod2", "ns2", "https://login.microsoftonline.com/", "tid") {
+		t.Errorf("expected workload identity key to not depend on the pod")
+	}
+	if strings.Contains(key, "token1") {
+		t.Errorf("expected service account token to not be part of the key in plain text")
+	}
+
+	otherToken := base
+	otherToken.ServiceAccountToken = "token2"
+	differentKeys := []string{
+		otherToken.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid"),
+		base.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid2"),
+		base.CacheKey("pod1", "ns1", "https://login.chinacloudapi.cn/", "tid"),
+		Config{AADClientID: "clientid", AADClientSecret: "token1"}.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid"),
+		Config{AADClientID: "clientid", AADClientCertificate: "token1"}.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid"),
+		Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: "token1", WorkloadIdentityTenantID: "htid", CrossTenantClientID: "clientid2"}.CacheKey("pod1", "ns1", "https://login.microsoftonline.com/", "tid"),
+	}
+	for _, k := range differentKeys {
+		if k == key {
+			t.Errorf("expected key %q to differ from %q", k, key)
+		}
+	}
+
+	// rotated service account tokens of the same service account share the key
+	rotated := func(sub string, exp int) Config {
+		return Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: newTestJWT(t, fmt.Sprintf(`{"sub":%q,"aud":["api://AzureADTokenExchange"],"exp":%d}`, sub, exp))}
+	}
+	if rotated("system:serviceaccount:ns1:sa1", 1).CacheKey("pod1", "ns1", "", "tid") != rotated("system:serviceaccount:ns1:sa1", 2).CacheKey("pod1", "ns1", "", "tid") {
+		t.Errorf("expected workload identity key to not depend on the rotated service account token")
+	}
+	if rotated("system:serviceaccount:ns1:sa1", 1).CacheKey("pod1", "ns1", "", "tid") == rotated("system:serviceaccount:ns1:sa2", 1).CacheKey("pod1", "ns1", "", "tid") {
+		t.Errorf("expected workload identity key to depend on the service account")
+	}
+
+	resourceID := Config{IdentityMode: IdentityModeVMManagedIdentity, UserAssignedIdentityResourceID: "id"}
+	objectID := Config{IdentityMode: IdentityModeVMManagedIdentity, UserAssignedIdentityObjectID: "id"}
+	if resourceID.CacheKey("pod1", "ns1", "", "tid") == objectID.CacheKey("pod1", "ns1", "", "tid") {
+		t.Errorf("expected managed identity key to depend on how the identity is selected")
+	}
+
+	podIdentity := Config{IdentityMode: IdentityModePodIdentity}
+	if podIdentity.CacheKey("pod1", "ns1", "", "tid") == podIdentity.CacheKey("pod2", "ns1", "", "tid") {
+		t.Errorf("expected pod identity key to depend on the pod")
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,216 @@
+// Package fallback implements a node-local store that keeps the last successfully
+// fetched objects for a mount, encrypted at rest with a node key. The provider serves
+// from it when Key Vault or AAD are temporarily unreachable.
+package fallback
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// keySize is the size of the AES-256 node key
+	keySize = 32
+	// fileExtension is the extension of the encrypted entries in the store directory
+	fileExtension = ".bin"
+)
+
+var (
+	// ErrNotFound is returned when there is no entry for the key
+	ErrNotFound = errors.New("fallback entry not found")
+	// ErrStale is returned when the entry is older than the max staleness
+	ErrStale = errors.New("fallback entry is stale")
+)
+
+// Store is a node-local store of the last successfully fetched files for a mount
+type Store struct {
+	dir          string
+	aead         cipher.AEAD
+	maxStaleness time.Duration
+	now          func() time.Time
+
+	mu sync.Mutex
+	// lastPrune is when the stale entries were last removed
+	lastPrune time.Time
+}
+
+// entry is the content of a stored file before encryption
+type entry struct {
+	Stored time.Time          `json:"stored"`
+	Files  []types.SecretFile `json:"files"`
+}
+
+// New creates a new Store that writes the entries to dir. The node key is read
+// from keyFile and generated if the file doesn't exist. Entries older than
+// maxStaleness are never returned.
+func New(dir, keyFile string, maxStaleness time.Duration) (*Store, error) {
+	if maxStaleness <= 0 {
+		return nil, fmt.Errorf("max staleness must be greater than 0")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create fallback cache dir %s, error: %w", dir, err)
+	}
+	key, err := loadOrCreateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:          dir,
+		aead:         aead,
+		maxStaleness: maxStaleness,
+		now:          time.Now,
+	}, nil
+}
+
+// Save encrypts and stores the files for the key, replacing any existing entry.
+// The entries older than the max staleness are removed at most once per max staleness.
+func (s *Store) Save(key string, files []types.SecretFile) error {
+	if s.shouldPrune() {
+		s.prune()
+	}
+
+	plaintext, err := json.Marshal(entry{Stored: s.now(), Files: files})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// the key is used as additional data so an entry can't be swapped for another
+	ciphertext := s.aead.Seal(nonce, nonce, plaintext, []byte(key))
+
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// Load returns the stored files for the key and when they were stored.
+// ErrNotFound is returned if there is no entry and ErrStale if the entry
+// is older than the max staleness.
+func (s *Store) Load(key string) ([]types.SecretFile, time.Time, error) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, err
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, time.Time{}, fmt.Errorf("fallback entry is corrupted")
+	}
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(key))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decrypt fallback entry, error: %w", err)
+	}
+	var e entry
+	if err = json.Unmarshal(plaintext, &e); err != nil {
+		return nil, time.Time{}, err
+	}
+	if s.now().Sub(e.Stored) > s.maxStaleness {
+		_ = os.Remove(s.path(key))
+		return nil, e.Stored, ErrStale
+	}
+	return e.Files, e.Stored, nil
+}
+
+// shouldPrune returns true if the stale entries weren't removed within the max staleness.
+// An entry only becomes stale after the max staleness so pruning more often finds nothing
+// new to remove, and Load removes the stale entries it reads.
+func (s *Store) shouldPrune() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if !s.lastPrune.IsZero() && now.Sub(s.lastPrune) < s.maxStaleness {
+		return false
+	}
+	s.lastPrune = now
+	return true
+}
+
+// prune removes the entries that were written before the max staleness, they are never
+// returned and the mounts they were saved for may not exist anymore
+func (s *Store) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != fileExtension {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if s.now().Sub(info.ModTime()) > s.maxStaleness {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+// path returns the file the entry for the key is stored in. The key is hashed
+// so it doesn't leak any information about the mount.
+func (s *Store) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileExtension)
+}
+
+// loadOrCreateKey reads the node key from the file or generates a new key
+// and writes it to the file if it doesn't exist
+func loadOrCreateKey(keyFile string) ([]byte, error) {
+	key, err := os.ReadFile(keyFile)
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("fallback cache key in %s must be %d bytes, got %d", keyFile, keySize, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read fallback cache key %s, error: %w", keyFile, err)
+	}
+
+	key = make([]byte, keySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyFile, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write fallback cache key %s, error: %w", keyFile, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,164 @@
+package fallback
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func newTestStore(t *testing.T, maxStaleness time.Duration) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := New(filepath.Join(dir, "cache"), filepath.Join(dir, "key"), maxStaleness)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	return s
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	files := []types.SecretFile{
+		{Path: "secret1", Content: []byte("value1"), FileMode: 0644, UID: "secret/secret1", Version: "v1"},
+		{Path: "secret2", Content: []byte("value2"), FileMode: 0600, UID: "secret/secret2", Version: "v2"},
+	}
+	if err := s.Save("key1", files); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+
+	got, _, err := s.Load("key1")
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("Load() = %v, want %v", got, files)
+	}
+
+	if _, _, err = s.Load("key2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	if err := s.Save("key1", []types.SecretFile{{Path: "secret1", Content: []byte("supersecretvalue")}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+	data, err := os.ReadFile(s.path("key1"))
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if strings.Contains(string(data), "supersecretvalue") || strings.Contains(string(data), "secret1") {
+		t.Errorf("expected entry to be encrypted")
+	}
+
+	// an entry moved to the path of another key must fail to decrypt
+	if err = os.Rename(s.path("key1"), s.path("key2")); err != nil {
+		t.Fatalf("failed to rename entry: %v", err)
+	}
+	if _, _, err = s.Load("key2"); err == nil {
+		t.Errorf("Load() = nil, want error for swapped entry")
+	}
+}
+
+func TestLoadStale(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	if err := s.Save("key1", []types.SecretFile{{Path: "secret1"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, _, err := s.Load("key1"); !errors.Is(err, ErrStale) {
+		t.Errorf("Load() = %v, want %v", err, ErrStale)
+	}
+	if _, err := os.Stat(s.path("key1")); !os.IsNotExist(err) {
+		t.Errorf("expected stale entry to be removed, got %v", err)
+	}
+}
+
+func TestSavePrunesStaleEntries(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	if err := s.Save("key1", []types.SecretFile{{Path: "secret1"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := s.Save("key2", []types.SecretFile{{Path: "secret2"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+	if _, err := os.Stat(s.path("key1")); !os.IsNotExist(err) {
+		t.Errorf("expected stale entry to be removed, got %v", err)
+	}
+	if _, err := os.Stat(s.path("key2")); err != nil {
+		t.Errorf("expected new entry to be kept, got %v", err)
+	}
+}
+
+func TestSavePrunesOncePerMaxStaleness(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	if err := s.Save("key1", []types.SecretFile{{Path: "secret1"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+	stale := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(s.path("key1"), stale, stale); err != nil {
+		t.Fatalf("Chtimes() = %v, want nil", err)
+	}
+
+	// the directory was pruned by the first save
+	now = now.Add(time.Minute)
+	if err := s.Save("key2", []types.SecretFile{{Path: "secret2"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+	if _, err := os.Stat(s.path("key1")); err != nil {
+		t.Errorf("expected entry to be kept until the next prune, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := s.Save("key2", []types.SecretFile{{Path: "secret2"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+	if _, err := os.Stat(s.path("key1")); !os.IsNotExist(err) {
+		t.Errorf("expected stale entry to be removed, got %v", err)
+	}
+}
+
+func TestNodeKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+
+	s1, err := New(filepath.Join(dir, "cache"), keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	if err = s1.Save("key1", []types.SecretFile{{Path: "secret1"}}); err != nil {
+		t.Fatalf("Save() = %v, want nil", err)
+	}
+
+	// a new store with the same key file can read the entries
+	s2, err := New(filepath.Join(dir, "cache"), keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	if _, _, err = s2.Load("key1"); err != nil {
+		t.Errorf("Load() = %v, want nil", err)
+	}
+
+	if err = os.WriteFile(keyFile, []byte("short"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	if _, err = New(filepath.Join(dir, "cache"), keyFile, time.Hour); err == nil {
+		t.Errorf("New() = nil, want error for invalid key size")
+	}
+}
@@ -20,8 +20,13 @@ var (
 	grpcMethodKey   = "grpc_method"
 	grpcCodeKey     = "grpc_code"
 	grpcMessageKey  = "grpc_message"
+	cacheNameKey    = "cache_name"
 	keyvaultRequest metric.Float64Histogram
 	grpcRequest     metric.Float64Histogram
+	cacheHit        metric.Int64Counter
+	cacheMiss       metric.Int64Counter
+	dedupRequest    metric.Int64Counter
+	fallbackServed  metric.Int64Counter
 )
 
 type reporter struct {
@@ -32,6 +37,9 @@ type reporter struct {
 type StatsReporter interface {
 	ReportKeyvaultRequest(ctx context.Context, duration float64, objectType, objectName, err string)
 	ReportGRPCRequest(ctx context.Context, duration float64, method, code, message string)
+	ReportCacheRequest(ctx context.Context, cacheName string, hit bool)
+	ReportDeduplicatedRequest(ctx context.Context, objectType string)
+	ReportFallbackServed(ctx context.Context)
 }
 
 // NewStatsReporter creates a new StatsReporter
@@ -47,6 +55,22 @@ func NewStatsReporter() StatsReporter {
 	if err != nil {
 		panic(err)
 	}
+	cacheHit, err = meter.Int64Counter("cache_hit", metric.WithDescription("Number of lookups served from the provider caches"))
+	if err != nil {
+		panic(err)
+	}
+	cacheMiss, err = meter.Int64Counter("cache_miss", metric.WithDescription("Number of lookups not found in the provider caches"))
+	if err != nil {
+		panic(err)
+	}
+	dedupRequest, err = meter.Int64Counter("keyvault_request_deduplicated", metric.WithDescription("Number of keyvault requests merged into an identical in-flight request"))
+	if err != nil {
+		panic(err)
+	}
+	fallbackServed, err = meter.Int64Counter("fallback_served", metric.WithDescription("Number of mount requests served from the fallback cache because keyvault was unavailable"))
+	if err != nil {
+		panic(err)
+	}
 	return &reporter{meter: meter}
 }
 
@@ -83,3 +107,41 @@ func (r *reporter) ReportGRPCRequest(ctx context.Context, duration float64, meth
 		metric.WithAttributes(attributes...),
 	)
 }
+
+// ReportCacheRequest reports a lookup in one of the provider caches
+// cacheName is used to identify the cache and hit is whether the entry was found
+func (r *reporter) ReportCacheRequest(ctx context.Context, cacheName string, hit bool) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(cacheNameKey, cacheName),
+	}
+	if hit {
+		cacheHit.Add(ctx, 1, metric.WithAttributes(attributes...))
+		return
+	}
+	cacheMiss.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportDeduplicatedRequest reports a keyvault request that was merged into an
+// identical in-flight request instead of being sent to keyvault
+func (r *reporter) ReportDeduplicatedRequest(ctx context.Context, objectType string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(objectTypeKey, objectType),
+	}
+	dedupRequest.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportFallbackServed reports a mount request served from the fallback cache
+func (r *reporter) ReportFallbackServed(ctx context.Context) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+	}
+	fallbackServed.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
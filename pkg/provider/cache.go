@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+)
+
+const (
+	// credentialCacheName is the name of the credential cache reported in metrics
+	credentialCacheName = "credential"
+	// clientCacheName is the name of the key vault client cache reported in metrics
+	clientCacheName = "client"
+
+	// tokenRefreshWindow is how long before expiry a cached access token is refreshed
+	tokenRefreshWindow = 5 * time.Minute
+)
+
+// lruCache is a size bounded cache that evicts the least recently used entry
+// once full. Entries also expire ttl after they were added.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// newLRUCache creates a new lruCache. A cache with a size or ttl of 0 never
+// stores any entries.
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *lruCache) enabled() bool {
+	return c.size > 0 && c.ttl > 0
+}
+
+// get returns the value for the key if it's present and not expired
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// add adds the value to the cache, evicting the least recently used entry if the cache is full
+func (c *lruCache) add(key string, value interface{}) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+	for c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: c.now().Add(c.ttl)})
+}
+
+// cachedTokenCredential wraps a credential and reuses the access token for a
+// set of scopes until it's close to expiry.
+type cachedTokenCredential struct {
+	cred azcore.TokenCredential
+
+	mu      sync.Mutex
+	tokens  map[string]azcore.AccessToken
+	fetches map[string]*tokenFetch
+	now     func() time.Time
+}
+
+// tokenFetch is a token request in flight for a set of scopes
+type tokenFetch struct {
+	done  chan struct{}
+	token azcore.AccessToken
+	err   error
+}
+
+func newCachedTokenCredential(cred azcore.TokenCredential) *cachedTokenCredential {
+	return &cachedTokenCredential{
+		cred:    cred,
+		tokens:  make(map[string]azcore.AccessToken),
+		fetches: make(map[string]*tokenFetch),
+		now:     time.Now,
+	}
+}
+
+// GetToken returns the cached token for the requested scopes or gets a new one
+// from the underlying credential. Concurrent callers for the same scopes wait for
+// a single request instead of holding the lock during the request.
+func (c *cachedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// claims are set when responding to a claims challenge and always require a new token
+	if opts.Claims != "" {
+		return c.cred.GetToken(ctx, opts)
+	}
+	key := opts.TenantID + "|" + strings.Join(opts.Scopes, " ")
+
+	for {
+		token, shared, err := c.fetch(ctx, key, opts)
+		// the request of another caller was cancelled, the caller gets its own token if it's still waiting
+		if shared && err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return azcore.AccessToken{}, err
+		}
+		return token, nil
+	}
+}
+
+// fetch returns the cached token for the key, waits for the request in flight for the key
+// or requests a new token. shared is true if the token was requested by another caller.
+func (c *cachedTokenCredential) fetch(ctx context.Context, key string, opts policy.TokenRequestOptions) (token azcore.AccessToken, shared bool, err error) {
+	c.mu.Lock()
+	if token, ok := c.tokens[key]; ok && c.now().Add(tokenRefreshWindow).Before(token.ExpiresOn) {
+		c.mu.Unlock()
+		return token, false, nil
+	}
+	if f, ok := c.fetches[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.token, true, f.err
+		case <-ctx.Done():
+			return azcore.AccessToken{}, false, ctx.Err()
+		}
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	c.fetches[key] = f
+	c.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			f.token, f.err = azcore.AccessToken{}, fmt.Errorf("panic in the token request: %v", r)
+		}
+		c.mu.Lock()
+		if f.err == nil {
+			c.tokens[key] = f.token
+		}
+		delete(c.fetches, key)
+		c.mu.Unlock()
+		close(f.done)
+		token, shared, err = f.token, false, f.err
+	}()
+	f.token, f.err = c.cred.GetToken(ctx, opts)
+	return f.token, false, f.err
+}
+
+// SetServiceAccountToken replaces the service account token of the underlying credential
+func (c *cachedTokenCredential) SetServiceAccountToken(token string) {
+	if cred, ok := c.cred.(auth.ServiceAccountTokenSetter); ok {
+		cred.SetServiceAccountToken(token)
+	}
+}
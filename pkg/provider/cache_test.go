@@ -0,0 +1,361 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	c := newLRUCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.add("a", 1)
+	c.add("b", 2)
+	// access a so b is the least recently used entry
+	if v, ok := c.get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("get(a) = %v, %v, want 1, true", v, ok)
+	}
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Errorf("get(b) found evicted entry")
+	}
+	if v, ok := c.get("c"); !ok || v.(int) != 3 {
+		t.Errorf("get(c) = %v, %v, want 3, true", v, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get(a) found expired entry")
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	c := newLRUCache(10, 0)
+	c.add("a", 1)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get(a) found entry in disabled cache")
+	}
+}
+
+type fakeCredential struct {
+	calls   int
+	expires time.Time
+	err     error
+}
+
+func (f *fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.calls++
+	if f.err != nil {
+		return azcore.AccessToken{}, f.err
+	}
+	return azcore.AccessToken{Token: "token", ExpiresOn: f.expires}, nil
+}
+
+func TestCachedTokenCredential(t *testing.T) {
+	now := time.Now()
+	fake := &fakeCredential{expires: now.Add(time.Hour)}
+	cred := newCachedTokenCredential(fake)
+	cred.now = func() time.Time { return now }
+
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}
+	for i := 0; i < 3; i++ {
+		if _, err := cred.GetToken(context.Background(), opts); err != nil {
+			t.Fatalf("GetToken() = %v, want nil", err)
+		}
+	}
+	if fake.calls != 1 {
+		t.Errorf("expected 1 token request, got %d", fake.calls)
+	}
+
+	// token is within the refresh window
+	now = now.Add(56 * time.Minute)
+	if _, err := cred.GetToken(context.Background(), opts); err != nil {
+		t.Fatalf("GetToken() = %v, want nil", err)
+	}
+	if fake.calls != 2 {
+		t.Errorf("expected 2 token requests, got %d", fake.calls)
+	}
+
+	// claims challenges always bypass the cache
+	opts.Claims = "claims"
+	if _, err := cred.GetToken(context.Background(), opts); err != nil {
+		t.Fatalf("GetToken() = %v, want nil", err)
+	}
+	if fake.calls != 3 {
+		t.Errorf("expected 3 token requests, got %d", fake.calls)
+	}
+}
+
+// blockingCredential returns a token once it's released
+type blockingCredential struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	b.calls.Add(1)
+	close(b.started)
+	<-b.release
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCachedTokenCredentialConcurrent(t *testing.T) {
+	blocking := &blockingCredential{started: make(chan struct{}), release: make(chan struct{})}
+	cred := newCachedTokenCredential(blocking)
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := cred.GetToken(context.Background(), opts)
+		errs <- err
+	}()
+	<-blocking.started
+
+	// the lock isn't held during the request so a waiter can give up
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cred.GetToken(canceled, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetToken() = %v, want %v", err, context.Canceled)
+	}
+
+	go func() {
+		_, err := cred.GetToken(context.Background(), opts)
+		errs <- err
+	}()
+	close(blocking.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("GetToken() = %v, want nil", err)
+		}
+	}
+	if calls := blocking.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 token request, got %d", calls)
+	}
+}
+
+// cancelingCredential fails the first token request once the second caller waits for it
+type cancelingCredential struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *cancelingCredential) GetToken(ctx context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if c.calls.Add(1) == 1 {
+		close(c.started)
+		<-c.release
+		return azcore.AccessToken{}, ctx.Err()
+	}
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCachedTokenCredentialSharedCancel(t *testing.T) {
+	canceling := &cancelingCredential{started: make(chan struct{}), release: make(chan struct{})}
+	cred := newCachedTokenCredential(canceling)
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cred.GetToken(ctx, opts)
+		first <- err
+	}()
+	<-canceling.started
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cred.GetToken(context.Background(), opts)
+		second <- err
+	}()
+	// give the second caller time to join the request in flight
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(canceling.release)
+
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetToken() = %v, want %v", err, context.Canceled)
+	}
+	// the waiter's context is still live so it requests its own token
+	if err := <-second; err != nil {
+		t.Fatalf("GetToken() = %v, want nil", err)
+	}
+}
+
+// panickingCredential panics on the first token request
+type panickingCredential struct {
+	calls atomic.Int32
+}
+
+func (p *panickingCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if p.calls.Add(1) == 1 {
+		panic("boom")
+	}
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCachedTokenCredentialPanic(t *testing.T) {
+	cred := newCachedTokenCredential(&panickingCredential{})
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}
+
+	if _, err := cred.GetToken(context.Background(), opts); err == nil {
+		t.Fatalf("GetToken() = nil, want error")
+	}
+	// the request is no longer in flight so the next caller doesn't block
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cred.GetToken(ctx, opts); err != nil {
+		t.Fatalf("GetToken() = %v, want nil", err)
+	}
+}
+
+// fakeServiceAccountTokenCredential records the service account token it was given
+type fakeServiceAccountTokenCredential struct {
+	fakeCredential
+	token string
+}
+
+func (f *fakeServiceAccountTokenCredential) SetServiceAccountToken(token string) {
+	f.token = token
+}
+
+func TestGetCachedCredentialReplacesServiceAccountToken(t *testing.T) {
+	p := &provider{
+		reporter:        metrics.NewStatsReporter(),
+		credentialCache: newLRUCache(10, time.Hour),
+	}
+	mc := &mountConfig{
+		azureCloudEnvironment: azure.PublicCloud,
+		authConfig:            auth.Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: "rotated-token"},
+		tenantID:              "tid",
+	}
+	resource := mc.getResource(types.VaultTypeKeyVault)
+	fake := &fakeServiceAccountTokenCredential{token: "token"}
+	p.credentialCache.add(mc.credentialCacheKey(resource), newCachedTokenCredential(fake))
+
+	if _, err := p.getCachedCredential(testContext(t), mc, resource); err != nil {
+		t.Fatalf("getCachedCredential() = %v, want nil", err)
+	}
+	if fake.token != "rotated-token" {
+		t.Errorf("expected the service account token of the cached credential to be replaced, got %q", fake.token)
+	}
+}
+
+func TestInitializeKvClientCacheReplacesServiceAccountToken(t *testing.T) {
+	p := &provider{
+		reporter:        metrics.NewStatsReporter(),
+		credentialCache: newLRUCache(10, time.Hour),
+		clientCache:     newLRUCache(10, time.Hour),
+		inflight:        newFlightGroup(),
+	}
+	// kubelet rotates the token of the service account, the claims identifying the service account stay the same
+	newServiceAccountToken := func(iat int) string {
+		encode := base64.RawURLEncoding.EncodeToString
+		claims := fmt.Sprintf(`{"aud":["api://AzureADTokenExchange"],"sub":"system:serviceaccount:ns:sa","iat":%d}`, iat)
+		return encode([]byte(`{"alg":"RS256"}`)) + "." + encode([]byte(claims)) + ".signature"
+	}
+	newMountConfig := func(token string) *mountConfig {
+		return &mountConfig{
+			azureCloudEnvironment: azure.PublicCloud,
+			authConfig:            auth.Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: token},
+			tenantID:              "tid",
+		}
+	}
+	token, rotatedToken := newServiceAccountToken(1), newServiceAccountToken(2)
+	mc := newMountConfig(token)
+	resource := mc.getResource(types.VaultTypeKeyVault)
+	fake := &fakeServiceAccountTokenCredential{token: token}
+	p.credentialCache.add(mc.credentialCacheKey(resource), newCachedTokenCredential(fake))
+	ctx := testContext(t)
+
+	c1, err := p.initializeKvClient(ctx, mc, "https://kv1.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	// the credential cache entry is gone, the client cache still has the client and its credential
+	p.credentialCache = newLRUCache(10, time.Hour)
+	c2, err := p.initializeKvClient(ctx, newMountConfig(rotatedToken), "https://kv1.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	if c1 != c2 {
+		t.Fatalf("expected cached client to be reused for the same identity and vault")
+	}
+	if fake.token != rotatedToken {
+		t.Errorf("expected the service account token of the cached client to be replaced, got %q", fake.token)
+	}
+}
+
+func TestInitializeKvClientCache(t *testing.T) {
+	p := &provider{
+		reporter:        metrics.NewStatsReporter(),
+		credentialCache: newLRUCache(10, time.Hour),
+		clientCache:     newLRUCache(10, time.Hour),
+		inflight:        newFlightGroup(),
+	}
+	newMountConfig := func(secret string) *mountConfig {
+		return &mountConfig{
+			azureCloudEnvironment: azure.PublicCloud,
+			authConfig:            auth.Config{AADClientID: "clientid", AADClientSecret: secret},
+			tenantID:              "tid",
+		}
+	}
+	ctx := testContext(t)
+
+	c1, err := p.initializeKvClient(ctx, newMountConfig("secret"), "https://kv1.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	c2, err := p.initializeKvClient(ctx, newMountConfig("secret"), "https://kv1.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	if c1 != c2 {
+		t.Errorf("expected cached client to be reused for the same identity and vault")
+	}
+
+	c3, err := p.initializeKvClient(ctx, newMountConfig("other-secret"), "https://kv1.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	if c1 == c3 {
+		t.Errorf("expected a new client for a different client secret")
+	}
+
+	c4, err := p.initializeKvClient(ctx, newMountConfig("secret"), "https://kv2.vault.azure.net/", types.VaultTypeKeyVault)
+	if err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	if c1 == c4 {
+		t.Errorf("expected a new client for a different vault")
+	}
+	if c1.(*dedupClient).kv.(*client).secrets == c4.(*dedupClient).kv.(*client).secrets {
+		t.Errorf("expected clients for different vaults to not share the secrets client")
+	}
+
+	// credentials for a managed HSM are requested for a different resource and aren't shared with key vault
+	if _, err = p.initializeKvClient(ctx, newMountConfig("secret"), "https://hsm.managedhsm.azure.net/", types.VaultTypeManagedHSM); err != nil {
+		t.Fatalf("initializeKvClient() = %v, want nil", err)
+	}
+	mc := newMountConfig("secret")
+	if _, ok := p.credentialCache.get(mc.credentialCacheKey(mc.getResource(types.VaultTypeManagedHSM))); !ok {
+		t.Errorf("expected managed HSM credential to be cached")
+	}
+	if mc.credentialCacheKey(mc.getResource(types.VaultTypeManagedHSM)) == mc.credentialCacheKey(mc.getResource(types.VaultTypeKeyVault)) {
+		t.Errorf("expected different credential cache keys for key vault and managed HSM")
+	}
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// certChainLeafSuffix is appended to the file name of the object for the leaf certificate
+	certChainLeafSuffix = ".leaf.pem"
+	// certChainIntermediatesSuffix is appended to the file name of the object for the intermediate certificates
+	certChainIntermediatesSuffix = ".chain.pem"
+	// certChainRootSuffix is appended to the file name of the object for the root certificate
+	certChainRootSuffix = ".root.pem"
+	// certChainFullSuffix is appended to the file name of the object for the leaf and intermediate certificates
+	certChainFullSuffix = ".fullchain.pem"
+)
+
+// getCertChainFiles returns the leaf, intermediate, root and full chain files of the certificates in the PEM content.
+// The intermediate and root files are only returned if the chain has intermediates or a root.
+func getCertChainFiles(kvObject types.KeyVaultObject, content, version string) ([]keyvaultObject, error) {
+	certs, _ := splitCertAndKey(content)
+	if certs == "" {
+		return nil, fmt.Errorf("no certificate found to write the certificate chain files")
+	}
+	chain, allCerts, err := buildCertChain([]byte(certs), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build certificate chain: %w", err)
+	}
+	if len(chain) != len(allCerts) {
+		if !strings.EqualFold(kvObject.IncompleteCertChain, types.IncompleteCertChainWarn) {
+			return nil, fmt.Errorf("certificate chain is incomplete due to a missing intermediate certificate, %d of %d certificates are not part of the chain of the leaf certificate", len(allCerts)-len(chain), len(allCerts))
+		}
+		klog.Warningf("certificate chain of %s is not complete due to missing intermediate certificates, writing the chain of the leaf certificate", kvObject.ObjectName)
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return nil, fmt.Errorf("certificate %q is not signed by %q: %w", chain[i].Subject.String(), chain[i+1].Subject.String(), err)
+		}
+	}
+
+	// the root is the self-signed certificate at the end of the chain, it's usually not part of the certificate
+	leaf, intermediates := chain[0], chain[1:]
+	var root []*x509.Certificate
+	if last := chain[len(chain)-1]; len(chain) > 1 && bytes.Equal(last.RawSubject, last.RawIssuer) {
+		intermediates, root = chain[1:len(chain)-1], chain[len(chain)-1:]
+	}
+	fullChain := append([]*x509.Certificate{leaf}, intermediates...)
+
+	files := []keyvaultObject{
+		{version: version, content: string(encodeCertificates([]*x509.Certificate{leaf})), fileNameSuffix: certChainLeafSuffix},
+	}
+	if len(intermediates) > 0 {
+		files = append(files, keyvaultObject{version: version, content: string(encodeCertificates(intermediates)), fileNameSuffix: certChainIntermediatesSuffix})
+	}
+	if len(root) > 0 {
+		files = append(files, keyvaultObject{version: version, content: string(encodeCertificates(root)), fileNameSuffix: certChainRootSuffix})
+	}
+	return append(files, keyvaultObject{version: version, content: string(encodeCertificates(fullChain)), fileNameSuffix: certChainFullSuffix}), nil
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestGetCertChainFiles(t *testing.T) {
+	_, chain, content := newTestCertChain(t)
+	leaf, intermediate, root := chain[0], chain[1], chain[2]
+	kvObject := types.KeyVaultObject{ObjectName: "cert1", ObjectType: types.VaultObjectTypeSecret, WriteCertChainFiles: true}
+
+	objs, err := getCertChainFiles(kvObject, content, "v1")
+	if err != nil {
+		t.Fatalf("getCertChainFiles() = %v, want nil", err)
+	}
+	assert.Equal(t, []keyvaultObject{
+		{version: "v1", content: encodeTestCerts(leaf), fileNameSuffix: ".leaf.pem"},
+		{version: "v1", content: encodeTestCerts(intermediate), fileNameSuffix: ".chain.pem"},
+		{version: "v1", content: encodeTestCerts(root), fileNameSuffix: ".root.pem"},
+		{version: "v1", content: encodeTestCerts(leaf, intermediate), fileNameSuffix: ".fullchain.pem"},
+	}, objs)
+
+	// the root is usually not part of the certificate
+	objs, err = getCertChainFiles(kvObject, encodeTestCerts(intermediate, leaf), "v1")
+	if err != nil {
+		t.Fatalf("getCertChainFiles() = %v, want nil", err)
+	}
+	// the root file isn't written without a root
+	assert.Equal(t, []keyvaultObject{
+		{version: "v1", content: encodeTestCerts(leaf), fileNameSuffix: ".leaf.pem"},
+		{version: "v1", content: encodeTestCerts(intermediate), fileNameSuffix: ".chain.pem"},
+		{version: "v1", content: encodeTestCerts(leaf, intermediate), fileNameSuffix: ".fullchain.pem"},
+	}, objs)
+}
+
+func TestGetCertChainFilesIncomplete(t *testing.T) {
+	_, chain, _ := newTestCertChain(t)
+	leaf, root := chain[0], chain[2]
+	content := encodeTestCerts(root, leaf)
+
+	kvObject := types.KeyVaultObject{ObjectName: "cert1", ObjectType: types.VaultObjectTypeSecret, WriteCertChainFiles: true}
+	_, err := getCertChainFiles(kvObject, content, "v1")
+	if err == nil || !strings.Contains(err.Error(), "certificate chain is incomplete due to a missing intermediate certificate") {
+		t.Fatalf("getCertChainFiles() = %v, want incomplete chain error", err)
+	}
+
+	kvObject.IncompleteCertChain = "warn"
+	objs, err := getCertChainFiles(kvObject, content, "v1")
+	if err != nil {
+		t.Fatalf("getCertChainFiles() = %v, want nil", err)
+	}
+	// the root left over from the incomplete chain isn't taken as the leaf, and the chain only has the leaf
+	assert.Equal(t, []keyvaultObject{
+		{version: "v1", content: encodeTestCerts(leaf), fileNameSuffix: ".leaf.pem"},
+		{version: "v1", content: encodeTestCerts(leaf), fileNameSuffix: ".fullchain.pem"},
+	}, objs)
+
+	if _, err = getCertChainFiles(kvObject, "secret", "v1"); err == nil {
+		t.Fatalf("getCertChainFiles() = nil, want error for content without certificates")
+	}
+}
+
+func TestBuildCertChainLeaf(t *testing.T) {
+	_, chain, _ := newTestCertChain(t)
+	leaf, root := chain[0], chain[2]
+	content := []byte(encodeTestCerts(root, leaf))
+
+	// the first certificate that isn't an issuer is the leaf by default
+	got, certs, err := buildCertChain(content, false)
+	if err != nil {
+		t.Fatalf("buildCertChain() = %v, want nil", err)
+	}
+	assert.Equal(t, []*x509.Certificate{root}, got)
+	assert.Equal(t, []*x509.Certificate{root, leaf}, certs)
+
+	got, _, err = buildCertChain(content, true)
+	if err != nil {
+		t.Fatalf("buildCertChain() = %v, want nil", err)
+	}
+	assert.Equal(t, []*x509.Certificate{leaf}, got)
+
+	// the PEM chain is written in the original order when it's incomplete
+	pemChain, err := fetchCertChains(content)
+	if err != nil {
+		t.Fatalf("fetchCertChains() = %v, want nil", err)
+	}
+	assert.Equal(t, content, pemChain)
+}
+
+func encodeTestCerts(certs ...*x509.Certificate) string {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: types.CertificateType, Bytes: cert.Raw})...)
+	}
+	return string(out)
+}
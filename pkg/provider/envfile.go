@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// envFileUIDPrefix is the prefix of the UID of the env file
+const envFileUIDPrefix = "envfile/"
+
+// envFile is the env file written with the objects that set an envName
+type envFile struct {
+	path     string
+	format   string
+	fileMode int32
+}
+
+// envValueReplacer escapes a value in a double quoted dotenv value
+var envValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+
+// parseEnvFile parses the envFile and envFileFormat parameters and checks the envName of the objects
+func parseEnvFile(path, format string, kvObjects []types.KeyVaultObject, defaultFilePermission os.FileMode) (*envFile, error) {
+	if path == "" {
+		for _, kvObject := range kvObjects {
+			if kvObject.EnvName != "" {
+				return nil, fmt.Errorf("envName is only supported with envFile")
+			}
+		}
+		if format != "" {
+			return nil, fmt.Errorf("envFileFormat is only supported with envFile")
+		}
+		return nil, nil
+	}
+	if err := validateFileName(path); err != nil {
+		return nil, fmt.Errorf("invalid envFile: %w", err)
+	}
+	switch {
+	case format == "" || strings.EqualFold(format, types.EnvFileFormatDotenv):
+		format = types.EnvFileFormatDotenv
+	case strings.EqualFold(format, types.EnvFileFormatExport):
+		format = types.EnvFileFormatExport
+	default:
+		return nil, fmt.Errorf("invalid envFileFormat: %s, should be dotenv or export", format)
+	}
+
+	names := make(map[string]bool)
+	for _, kvObject := range kvObjects {
+		if kvObject.EnvName == "" {
+			continue
+		}
+		if names[kvObject.EnvName] {
+			return nil, fmt.Errorf("duplicate envName %s", kvObject.EnvName)
+		}
+		names[kvObject.EnvName] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("envFile is set but no object sets envName")
+	}
+	//nolint:gosec // Safe to cast, file permissions fit within int32 range
+	return &envFile{path: path, format: format, fileMode: int32(defaultFilePermission)}, nil
+}
+
+// render returns the env file with a line for every object that sets an envName, sorted by name
+func (e *envFile) render(kvObjects []types.KeyVaultObject, files []types.SecretFile) (*types.SecretFile, error) {
+	filesByPath := make(map[string]types.SecretFile, len(files))
+	for _, file := range files {
+		filesByPath[file.Path] = file
+	}
+	if _, ok := filesByPath[e.path]; ok {
+		return nil, fmt.Errorf("envFile %s conflicts with an object file", e.path)
+	}
+
+	values := make(map[string]string)
+	var names []string
+	var envFiles []types.SecretFile
+	for _, kvObject := range kvObjects {
+		if kvObject.EnvName == "" {
+			continue
+		}
+		file, ok := filesByPath[kvObject.GetFileName()]
+		if !ok {
+			return nil, fmt.Errorf("file %s of envName %s not found", kvObject.GetFileName(), kvObject.EnvName)
+		}
+		if err := validateEnvValue(file.Content); err != nil {
+			return nil, fmt.Errorf("invalid value for envName %s: %w", kvObject.EnvName, err)
+		}
+		values[kvObject.EnvName] = string(file.Content)
+		names = append(names, kvObject.EnvName)
+		envFiles = append(envFiles, file)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if e.format == types.EnvFileFormatExport {
+			// a single quoted value is taken literally by the shell, a single quote is
+			// written by closing the quotes, escaping it and opening the quotes again
+			fmt.Fprintf(&b, "export %s='%s'\n", name, strings.ReplaceAll(values[name], "'", `'\''`))
+			continue
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"\n", name, envValueReplacer.Replace(values[name]))
+	}
+	return &types.SecretFile{
+		Path:     e.path,
+		Content:  []byte(b.String()),
+		FileMode: e.fileMode,
+		UID:      envFileUIDPrefix + e.path,
+		Version:  combineVersions(envFiles),
+	}, nil
+}
+
+// validateEnvValue checks that the value can be written to an env file
+func validateEnvValue(value []byte) error {
+	if !utf8.Valid(value) {
+		return fmt.Errorf("value is not valid UTF-8")
+	}
+	for _, r := range string(value) {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return fmt.Errorf("value contains invalid character %U", r)
+		}
+	}
+	return nil
+}
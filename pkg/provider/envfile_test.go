@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	objects := []types.KeyVaultObject{
+		{ObjectName: "secret1", EnvName: "DB_PASSWORD"},
+		{ObjectName: "secret2"},
+	}
+
+	cases := []struct {
+		desc           string
+		path           string
+		format         string
+		objects        []types.KeyVaultObject
+		expectedFormat string
+		expectedErr    string
+	}{
+		{
+			desc:    "no env file",
+			objects: []types.KeyVaultObject{{ObjectName: "secret1"}},
+		},
+		{
+			desc:           "default format",
+			path:           ".env",
+			objects:        objects,
+			expectedFormat: types.EnvFileFormatDotenv,
+		},
+		{
+			desc:           "export format",
+			path:           "config/app.env",
+			format:         "Export",
+			objects:        objects,
+			expectedFormat: types.EnvFileFormatExport,
+		},
+		{
+			desc:        "env name without env file",
+			objects:     objects,
+			expectedErr: "envName is only supported with envFile",
+		},
+		{
+			desc:        "format without env file",
+			format:      "export",
+			expectedErr: "envFileFormat is only supported with envFile",
+		},
+		{
+			desc:        "invalid path",
+			path:        "../.env",
+			objects:     objects,
+			expectedErr: "invalid envFile: file name must not contain '..'",
+		},
+		{
+			desc:        "invalid format",
+			path:        ".env",
+			format:      "yaml",
+			objects:     objects,
+			expectedErr: "invalid envFileFormat: yaml, should be dotenv or export",
+		},
+		{
+			desc:        "duplicate env name",
+			path:        ".env",
+			objects:     []types.KeyVaultObject{{ObjectName: "secret1", EnvName: "DB"}, {ObjectName: "secret2", EnvName: "DB"}},
+			expectedErr: "duplicate envName DB",
+		},
+		{
+			desc:        "no env names",
+			path:        ".env",
+			objects:     []types.KeyVaultObject{{ObjectName: "secret1"}},
+			expectedErr: "envFile is set but no object sets envName",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			env, err := parseEnvFile(tc.path, tc.format, tc.objects, 0644)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			if tc.path == "" {
+				assert.Nil(t, env)
+				return
+			}
+			assert.Equal(t, &envFile{path: tc.path, format: tc.expectedFormat, fileMode: 0644}, env)
+		})
+	}
+}
+
+func TestRenderEnvFile(t *testing.T) {
+	objects := []types.KeyVaultObject{
+		{ObjectName: "secret1", EnvName: "PASSWORD"},
+		{ObjectName: "secret2", ObjectAlias: "api", EnvName: "API_KEY"},
+		{ObjectName: "secret3"},
+	}
+	files := []types.SecretFile{
+		{Path: "secret1", Content: []byte("p\"a$s`s\\'\nword"), Version: "v1"},
+		{Path: "api", Content: []byte("key"), Version: "v2"},
+		{Path: "secret3", Content: []byte("\x00"), Version: "v3"},
+	}
+
+	cases := []struct {
+		desc            string
+		format          string
+		expectedContent string
+	}{
+		{
+			desc:            "dotenv",
+			format:          types.EnvFileFormatDotenv,
+			expectedContent: "API_KEY=\"key\"\nPASSWORD=\"p\\\"a\\$s\\`s\\\\'\\nword\"\n",
+		},
+		{
+			desc:            "export",
+			format:          types.EnvFileFormatExport,
+			expectedContent: "export API_KEY='key'\nexport PASSWORD='p\"a$s`s\\'\\''\nword'\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			env := &envFile{path: ".env", format: tc.format, fileMode: 0600}
+			file, err := env.render(objects, files)
+			if err != nil {
+				t.Fatalf("render() = %v, want nil", err)
+			}
+			assert.Equal(t, tc.expectedContent, string(file.Content))
+			assert.Equal(t, ".env", file.Path)
+			assert.Equal(t, "envfile/.env", file.UID)
+			assert.Equal(t, int32(0600), file.FileMode)
+			// the version only depends on the objects in the env file
+			assert.Equal(t, combineVersions(files[:2]), file.Version)
+		})
+	}
+}
+
+func TestRenderEnvFileErrors(t *testing.T) {
+	env := &envFile{path: ".env", format: types.EnvFileFormatDotenv}
+
+	_, err := env.render([]types.KeyVaultObject{{ObjectName: "secret1", EnvName: "SECRET"}}, []types.SecretFile{{Path: "secret1", Content: []byte("a\x00b")}})
+	assert.EqualError(t, err, "invalid value for envName SECRET: value contains invalid character U+0000")
+
+	_, err = env.render([]types.KeyVaultObject{{ObjectName: "secret1", EnvName: "SECRET"}}, []types.SecretFile{{Path: "secret1", Content: []byte{0xff}}})
+	assert.EqualError(t, err, "invalid value for envName SECRET: value is not valid UTF-8")
+
+	_, err = env.render([]types.KeyVaultObject{{ObjectName: "secret1", EnvName: "SECRET"}}, []types.SecretFile{{Path: "secret2"}})
+	assert.EqualError(t, err, "file secret1 of envName SECRET not found")
+
+	_, err = env.render([]types.KeyVaultObject{{ObjectName: "secret1", EnvName: "SECRET"}}, []types.SecretFile{{Path: "secret1"}, {Path: ".env"}})
+	assert.EqualError(t, err, "envFile .env conflicts with an object file")
+}
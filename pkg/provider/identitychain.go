@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/utils"
+)
+
+// identityPins holds the identity mode of the identity chain that succeeded for a pod
+// until the pin expires
+type identityPins struct {
+	mu   sync.Mutex
+	pins map[string]identityPin
+	now  func() time.Time
+}
+
+type identityPin struct {
+	mode    string
+	expires time.Time
+}
+
+func newIdentityPins() *identityPins {
+	return &identityPins{
+		pins: make(map[string]identityPin),
+		now:  time.Now,
+	}
+}
+
+// get returns the pinned identity mode for the key if the pin hasn't expired
+func (p *identityPins) get(key string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.pins[key]
+	if !ok {
+		return "", false
+	}
+	if p.now().After(pin.expires) {
+		delete(p.pins, key)
+		return "", false
+	}
+	return pin.mode, true
+}
+
+// add pins the identity mode for the key for the duration, the expired pins are removed
+func (p *identityPins) add(key, mode string, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for k, pin := range p.pins {
+		if now.After(pin.expires) {
+			delete(p.pins, k)
+		}
+	}
+	p.pins[key] = identityPin{mode: mode, expires: now.Add(duration)}
+}
+
+// remove removes the pin for the key
+func (p *identityPins) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, key)
+}
+
+// identityChainModes are the identity modes supported in the identity chain
+var identityChainModes = []string{
+	types.IdentityChainWorkloadIdentity,
+	types.IdentityChainPodIdentity,
+	types.IdentityChainVMManagedIdentity,
+	types.IdentityChainAzureTokenProxy,
+	types.IdentityChainServicePrincipal,
+}
+
+// validateIdentityChain validates the identity modes of the identity chain
+func validateIdentityChain(chain []string) error {
+	for _, mode := range chain {
+		supported := false
+		for _, m := range identityChainModes {
+			supported = supported || strings.EqualFold(mode, m)
+		}
+		if !supported {
+			return fmt.Errorf("invalid identityChain mode: %s, should be one of %s", mode, strings.Join(identityChainModes, ", "))
+		}
+	}
+	return nil
+}
+
+// identityChainInput returns the auth config input for the identity mode of the identity chain
+func identityChainInput(mode string, input authConfigInput) (authConfigInput, error) {
+	// the user assigned identity only selects the VM managed identity
+	if !strings.EqualFold(mode, types.IdentityChainVMManagedIdentity) {
+		input.userAssignedIdentityID = ""
+		input.userAssignedIdentityResourceID = ""
+		input.userAssignedIdentityObjectID = ""
+	}
+
+	// the audience and the cross tenant access only apply to workload identity
+	if !strings.EqualFold(mode, types.IdentityChainWorkloadIdentity) {
+		input.workloadIdentityAudience = ""
+		input.workloadIdentityTenantID = ""
+		input.crossTenantClientID = ""
+		input.crossTenantAudience = ""
+	}
+
+	switch {
+	case strings.EqualFold(mode, types.IdentityChainWorkloadIdentity):
+		if input.workloadIdentityClientID == "" {
+			return input, fmt.Errorf("clientID is required for workload identity")
+		}
+		input.identityMode = auth.IdentityModeNone
+	case strings.EqualFold(mode, types.IdentityChainServicePrincipal):
+		// the service principal is used if the workload identity isn't configured
+		input.identityMode = auth.IdentityModeNone
+		input.workloadIdentityClientID = ""
+	case strings.EqualFold(mode, types.IdentityChainPodIdentity):
+		input.identityMode = auth.IdentityModePodIdentity
+	case strings.EqualFold(mode, types.IdentityChainVMManagedIdentity):
+		input.identityMode = auth.IdentityModeVMManagedIdentity
+	case strings.EqualFold(mode, types.IdentityChainAzureTokenProxy):
+		input.identityMode = auth.IdentityModeAzureTokenProxy
+	default:
+		return input, validateIdentityChain([]string{mode})
+	}
+	return input, nil
+}
+
+// identityChainPinKey returns the key of the pinned mode of the identity chain for the pod
+func identityChainPinKey(mc *mountConfig, chain []string) string {
+	return strings.Join([]string{mc.podNamespace, mc.podName, strings.Join(chain, ",")}, "|")
+}
+
+// resolveIdentityChain returns the auth config and the identity mode of the first identity mode of the identity
+// chain that gets a token for the resources of the vaults of the mount. The mode pinned for the pod is used without
+// trying the modes before it.
+func (p *provider) resolveIdentityChain(ctx context.Context, mc *mountConfig, chain []string, resources []string, input authConfigInput) (auth.Config, string, error) {
+	pod := klog.ObjectRef{Namespace: mc.podNamespace, Name: mc.podName}
+	pinKey := identityChainPinKey(mc, chain)
+
+	if mode, ok := p.identityPins.get(pinKey); ok {
+		config, err := buildIdentityChainConfig(mode, input)
+		if err == nil {
+			klog.V(2).InfoS("using the pinned mode of the identity chain", "mode", mode, "pod", pod)
+			return config, mode, nil
+		}
+		klog.InfoS("pinned mode of the identity chain is not available, trying the identity chain", "mode", mode, "reason", redactIdentityChainReason(err.Error(), input), "pod", pod)
+		p.identityPins.remove(pinKey)
+	}
+
+	reasons := make([]string, 0, len(chain))
+	for i, mode := range chain {
+		config, err := buildIdentityChainConfig(mode, input)
+		if err == nil {
+			mc.authConfig = config
+			err = p.probeCredential(ctx, mc, resources)
+		}
+		if err != nil {
+			reason := redactIdentityChainReason(err.Error(), input)
+			klog.InfoS("identity chain mode failed", "mode", mode, "step", i+1, "reason", reason, "pod", pod)
+			reasons = append(reasons, fmt.Sprintf("%s: %s", mode, reason))
+			continue
+		}
+		klog.InfoS("identity chain mode succeeded", "mode", mode, "step", i+1, "pod", pod)
+		return config, mode, nil
+	}
+	return auth.Config{}, "", fmt.Errorf("all modes of the identity chain failed: %s", strings.Join(reasons, "; "))
+}
+
+// pinIdentityChainMode pins the identity mode of the identity chain for the pod for the pin duration once the
+// objects of the mount were fetched with it, so the following mounts of the pod use it. The pin is removed if
+// fetching the objects failed.
+func (p *provider) pinIdentityChainMode(mc *mountConfig, chain []string, mode string, pinDuration time.Duration, fetchErr error) {
+	pinKey := identityChainPinKey(mc, chain)
+	if fetchErr != nil {
+		p.identityPins.remove(pinKey)
+		return
+	}
+	if _, ok := p.identityPins.get(pinKey); ok {
+		return
+	}
+	klog.V(2).InfoS("pinning the mode of the identity chain", "mode", mode, "pinDuration", pinDuration, "pod", klog.ObjectRef{Namespace: mc.podNamespace, Name: mc.podName})
+	p.identityPins.add(pinKey, mode, pinDuration)
+}
+
+// identityChainResources returns the resources of the vaults of the objects of the mount that the modes of
+// the identity chain are probed for
+func identityChainResources(mc *mountConfig, attrib map[string]string) []string {
+	var resources []string
+	add := func(kv types.KeyVaultObject) {
+		resource := mc.getResource(mc.getObjectVaultType(kv))
+		for _, r := range resources {
+			if r == resource {
+				return
+			}
+		}
+		resources = append(resources, resource)
+	}
+	// the objects are validated when they're fetched
+	if objects, err := types.GetObjectsArray(types.GetObjects(attrib)); err == nil {
+		for _, object := range objects.Array {
+			var kv types.KeyVaultObject
+			if err := yaml.Unmarshal([]byte(object), &kv); err != nil {
+				continue
+			}
+			formatKeyVaultObject(&kv)
+			add(kv)
+		}
+	}
+	if len(resources) == 0 {
+		add(types.KeyVaultObject{})
+	}
+	return resources
+}
+
+// buildIdentityChainConfig builds the auth config for the identity mode of the identity chain
+func buildIdentityChainConfig(mode string, input authConfigInput) (auth.Config, error) {
+	modeInput, err := identityChainInput(mode, input)
+	if err != nil {
+		return auth.Config{}, err
+	}
+	return buildAuthConfig(modeInput)
+}
+
+// probeCredential gets a token for every resource with the credential of the mount. The credentials
+// are cached so the tokens are reused by the key vault clients of the mount.
+func (p *provider) probeCredential(ctx context.Context, mc *mountConfig, resources []string) error {
+	for _, resource := range resources {
+		cred, err := p.getCachedCredential(ctx, mc, resource)
+		if err != nil {
+			return err
+		}
+		if _, err = cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{resource + "/.default"}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactIdentityChainReason redacts the client IDs and the credentials of the mount in the reason
+// an identity mode failed
+func redactIdentityChainReason(reason string, input authConfigInput) string {
+	sensitive := []string{input.workloadIdentityClientID, input.userAssignedIdentityID, input.userAssignedIdentityObjectID}
+	for _, v := range input.secrets {
+		sensitive = append(sensitive, v)
+	}
+	// the longer values are redacted first so they aren't broken up by the values they contain
+	sort.Slice(sensitive, func(i, j int) bool { return len(sensitive[i]) > len(sensitive[j]) })
+	for _, s := range sensitive {
+		if s == "" {
+			continue
+		}
+		redacted := utils.RedactSecureString(s)
+		// short values are redacted entirely
+		if redacted == s {
+			redacted = "##### REDACTED #####"
+		}
+		reason = strings.ReplaceAll(reason, s, redacted)
+	}
+	return reason
+}
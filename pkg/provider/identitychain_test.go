@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestIdentityPins(t *testing.T) {
+	now := time.Now()
+	pins := newIdentityPins()
+	pins.now = func() time.Time { return now }
+
+	pins.add("pod1", types.IdentityChainWorkloadIdentity, time.Minute)
+	pins.add("pod2", types.IdentityChainPodIdentity, 0)
+	if mode, ok := pins.get("pod1"); !ok || mode != types.IdentityChainWorkloadIdentity {
+		t.Errorf("get() = %s, %v, want %s", mode, ok, types.IdentityChainWorkloadIdentity)
+	}
+	if _, ok := pins.get("pod2"); ok {
+		t.Errorf("expected a pin duration of 0 to not pin the mode")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := pins.get("pod1"); ok {
+		t.Errorf("expected the pin to expire")
+	}
+}
+
+func TestValidateIdentityChain(t *testing.T) {
+	if err := validateIdentityChain([]string{"workloadIdentity", "VMManagedIdentity", "servicePrincipal"}); err != nil {
+		t.Errorf("validateIdentityChain() = %v, want nil", err)
+	}
+	expectedErr := "invalid identityChain mode: msi, should be one of workloadIdentity, podIdentity, vmManagedIdentity, azureTokenProxy, servicePrincipal"
+	if err := validateIdentityChain([]string{"workloadIdentity", "msi"}); err == nil || err.Error() != expectedErr {
+		t.Errorf("validateIdentityChain() = %v, want %s", err, expectedErr)
+	}
+}
+
+func TestResolveIdentityChain(t *testing.T) {
+	p := &provider{
+		reporter:        metrics.NewStatsReporter(),
+		credentialCache: newLRUCache(10, time.Hour),
+		identityPins:    newIdentityPins(),
+	}
+	mc := &mountConfig{
+		azureCloudEnvironment: azure.PublicCloud,
+		tenantID:              "tid",
+		podName:               "pod",
+		podNamespace:          "ns",
+	}
+	input := authConfigInput{
+		userAssignedIdentityID: "user-assigned-client-id",
+		secrets:                map[string]string{"clientid": "service-principal-client-id", "clientsecret": "secret"},
+	}
+	resource := mc.getResource(types.VaultTypeKeyVault)
+
+	// the credentials of the modes are cached so the token requests don't leave the test
+	podIdentity := &fakeCredential{err: errors.New("nmi is not available for service-principal-client-id")}
+	vmManagedIdentity := &fakeCredential{expires: time.Now().Add(time.Hour)}
+	for cred, config := range map[*fakeCredential]auth.Config{
+		podIdentity:       {IdentityMode: auth.IdentityModePodIdentity},
+		vmManagedIdentity: {IdentityMode: auth.IdentityModeVMManagedIdentity, UserAssignedIdentityID: "user-assigned-client-id"},
+	} {
+		p.credentialCache.add((&mountConfig{azureCloudEnvironment: mc.azureCloudEnvironment, tenantID: "tid", podName: "pod", podNamespace: "ns", authConfig: config}).credentialCacheKey(resource), cred)
+	}
+
+	chain := []string{types.IdentityChainWorkloadIdentity, types.IdentityChainPodIdentity, types.IdentityChainVMManagedIdentity}
+	resources := []string{resource}
+	config, mode, err := p.resolveIdentityChain(testContext(t), mc, chain, resources, input)
+	if err != nil {
+		t.Fatalf("resolveIdentityChain() = %v, want nil", err)
+	}
+	assert.Equal(t, types.IdentityChainVMManagedIdentity, mode)
+	assert.Equal(t, auth.IdentityModeVMManagedIdentity, config.IdentityMode)
+	assert.Equal(t, "user-assigned-client-id", config.UserAssignedIdentityID)
+	assert.Equal(t, 1, podIdentity.calls)
+	assert.Equal(t, 1, vmManagedIdentity.calls)
+
+	// the mode isn't pinned until the objects are fetched with it
+	p.pinIdentityChainMode(mc, chain, mode, time.Minute, errors.New("forbidden"))
+	if _, _, err = p.resolveIdentityChain(testContext(t), mc, chain, resources, input); err != nil {
+		t.Fatalf("resolveIdentityChain() = %v, want nil", err)
+	}
+	assert.Equal(t, 2, podIdentity.calls)
+
+	// the pinned mode is used without trying the modes before it
+	p.pinIdentityChainMode(mc, chain, mode, time.Minute, nil)
+	if config, _, err = p.resolveIdentityChain(testContext(t), mc, chain, resources, input); err != nil {
+		t.Fatalf("resolveIdentityChain() = %v, want nil", err)
+	}
+	assert.Equal(t, auth.IdentityModeVMManagedIdentity, config.IdentityMode)
+	assert.Equal(t, 2, podIdentity.calls)
+
+	// the pin is removed when fetching the objects with the pinned mode fails
+	p.pinIdentityChainMode(mc, chain, mode, time.Minute, errors.New("forbidden"))
+	if _, ok := p.identityPins.get(identityChainPinKey(mc, chain)); ok {
+		t.Errorf("expected the pin to be removed")
+	}
+
+	// the reasons of all the modes are returned with the client IDs redacted
+	_, _, err = p.resolveIdentityChain(testContext(t), mc, chain[:2], resources, input)
+	if err == nil {
+		t.Fatalf("resolveIdentityChain() = nil, want error")
+	}
+	expectedErr := "all modes of the identity chain failed: workloadIdentity: clientID is required for workload identity; podIdentity: nmi is not available for serv##### REDACTED #####t-id"
+	assert.Equal(t, expectedErr, err.Error())
+	if strings.Contains(err.Error(), "service-principal-client-id") {
+		t.Errorf("expected the client ID to be redacted")
+	}
+}
+
+func TestIdentityChainResources(t *testing.T) {
+	mc := &mountConfig{azureCloudEnvironment: azure.PublicCloud, vaultType: types.VaultTypeKeyVault}
+	keyVault := mc.getResource(types.VaultTypeKeyVault)
+	managedHSM := mc.getResource(types.VaultTypeManagedHSM)
+
+	// the vault type of the objects overrides the vault type of the mount
+	attrib := map[string]string{"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+  - |
+    objectName: key1
+    objectType: key
+    vaultType: managedHSM
+  - |
+    objectName: key2
+    objectType: key
+    vaultType: managedHSM
+`}
+	assert.Equal(t, []string{keyVault, managedHSM}, identityChainResources(mc, attrib))
+
+	attrib = map[string]string{"objects": `
+array:
+  - |
+    objectName: key1
+    objectType: key
+    vaultType: managedHSM
+`}
+	assert.Equal(t, []string{managedHSM}, identityChainResources(mc, attrib))
+
+	// the vault of the mount is probed if the objects can't be parsed
+	assert.Equal(t, []string{keyVault}, identityChainResources(mc, map[string]string{"objects": "invalid"}))
+}
+
+func TestRedactIdentityChainReason(t *testing.T) {
+	input := authConfigInput{
+		workloadIdentityClientID: "workload-identity-client-id",
+		secrets:                  map[string]string{"clientid": "cid1", "clientsecret": "short"},
+	}
+	// short values are redacted entirely
+	reason := redactIdentityChainReason("failed for cid1 and workload-identity-client-id with secret short", input)
+	assert.Equal(t, "failed for ##### REDACTED ##### and work##### REDACTED #####t-id with secret ##### REDACTED #####", reason)
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// jsonField is a value selected from a JSON secret
+type jsonField struct {
+	// fileNameSuffix is appended to the file name of the object
+	fileNameSuffix string
+	content        []byte
+}
+
+// extractJSONFields returns the values selected from the JSON secret by the
+// objectJSONPath and jsonKeys of the object
+func extractJSONFields(content []byte, kv types.KeyVaultObject) ([]jsonField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// keep numbers as they are written in the secret
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to parse secret as JSON, error: %w", err)
+	}
+
+	// the validity of the path and keys is already checked in the validate function
+	path, _ := kv.GetJSONPath()
+	keys, _ := kv.GetJSONKeys()
+
+	value, err := lookupJSONPath(value, path)
+	if err != nil {
+		return nil, fmt.Errorf("objectJSONPath %s not found in secret: %w", kv.ObjectJSONPath, err)
+	}
+	if len(keys) == 0 {
+		content, err := jsonValueBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		return []jsonField{{content: content}}, nil
+	}
+
+	fields := make([]jsonField, 0, len(keys))
+	for _, key := range keys {
+		keyValue, err := lookupJSONPath(value, []string{key})
+		if err != nil {
+			if kv.ObjectJSONPath != "" {
+				return nil, fmt.Errorf("jsonKey %s not found in secret at objectJSONPath %s: %w", key, kv.ObjectJSONPath, err)
+			}
+			return nil, fmt.Errorf("jsonKey %s not found in secret: %w", key, err)
+		}
+		content, err := jsonValueBytes(keyValue)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{fileNameSuffix: "/" + key, content: content})
+	}
+	return fields, nil
+}
+
+// lookupJSONPath returns the value at the path of keys and array indexes
+func lookupJSONPath(value interface{}, path []string) (interface{}, error) {
+	for i, segment := range path {
+		parent := strings.Join(path[:i], ".")
+		if parent == "" {
+			parent = "the root"
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[segment]
+			if !ok {
+				return nil, fmt.Errorf("no key %s in %s", segment, parent)
+			}
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("no index %s in array %s of length %d", segment, parent, len(v))
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("%s is not an object or array", parent)
+		}
+	}
+	return value, nil
+}
+
+// jsonValueBytes returns the content of the file written for a JSON value. Strings
+// are written as they are, all other values are written as JSON.
+func jsonValueBytes(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
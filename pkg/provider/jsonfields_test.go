@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestExtractJSONFields(t *testing.T) {
+	content := []byte(`{"username": "admin", "password": "p@ss", "port": 5432, "tls": {"enabled": true, "hosts": ["a", "b"]}}`)
+
+	cases := []struct {
+		desc           string
+		object         types.KeyVaultObject
+		expectedFields []jsonField
+		expectedErr    string
+	}{
+		{
+			desc:           "string value",
+			object:         types.KeyVaultObject{ObjectJSONPath: "password"},
+			expectedFields: []jsonField{{content: []byte("p@ss")}},
+		},
+		{
+			desc:           "number value",
+			object:         types.KeyVaultObject{ObjectJSONPath: "port"},
+			expectedFields: []jsonField{{content: []byte("5432")}},
+		},
+		{
+			desc:           "nested array value",
+			object:         types.KeyVaultObject{ObjectJSONPath: "tls.hosts.1"},
+			expectedFields: []jsonField{{content: []byte("b")}},
+		},
+		{
+			desc:           "object value",
+			object:         types.KeyVaultObject{ObjectJSONPath: "tls"},
+			expectedFields: []jsonField{{content: []byte(`{"enabled":true,"hosts":["a","b"]}`)}},
+		},
+		{
+			desc:   "json keys",
+			object: types.KeyVaultObject{JSONKeys: "username,password"},
+			expectedFields: []jsonField{
+				{fileNameSuffix: "/username", content: []byte("admin")},
+				{fileNameSuffix: "/password", content: []byte("p@ss")},
+			},
+		},
+		{
+			desc:   "json keys from path",
+			object: types.KeyVaultObject{ObjectJSONPath: "tls", JSONKeys: "enabled"},
+			expectedFields: []jsonField{
+				{fileNameSuffix: "/enabled", content: []byte("true")},
+			},
+		},
+		{
+			desc:        "missing path",
+			object:      types.KeyVaultObject{ObjectJSONPath: "tls.ca"},
+			expectedErr: "objectJSONPath tls.ca not found in secret: no key ca in tls",
+		},
+		{
+			desc:        "index out of range",
+			object:      types.KeyVaultObject{ObjectJSONPath: "tls.hosts.2"},
+			expectedErr: "objectJSONPath tls.hosts.2 not found in secret: no index 2 in array tls.hosts of length 2",
+		},
+		{
+			desc:        "path through a value",
+			object:      types.KeyVaultObject{ObjectJSONPath: "port.value"},
+			expectedErr: "objectJSONPath port.value not found in secret: port is not an object or array",
+		},
+		{
+			desc:        "missing key",
+			object:      types.KeyVaultObject{JSONKeys: "username,host"},
+			expectedErr: "jsonKey host not found in secret: no key host in the root",
+		},
+		{
+			desc:        "missing key at path",
+			object:      types.KeyVaultObject{ObjectJSONPath: "tls", JSONKeys: "ca"},
+			expectedErr: "jsonKey ca not found in secret at objectJSONPath tls: no key ca in the root",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			fields, err := extractJSONFields(content, tc.object)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedFields, fields)
+		})
+	}
+
+	_, err := extractJSONFields([]byte("not json"), types.KeyVaultObject{ObjectJSONPath: "password"})
+	assert.ErrorContains(t, err, "failed to parse secret as JSON")
+}
+
+func TestGetObjectFilesWithJSONKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			return &azsecrets.SecretBundle{
+				ID: &id,
+				// {"username":"admin","password":"p@ss"}
+				Value: to.StringPtr("eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJwQHNzIn0="),
+			}, nil
+		},
+	)
+
+	kvObject := types.KeyVaultObject{ObjectName: "secret1", ObjectAlias: "db", ObjectType: types.VaultObjectTypeSecret, ObjectEncoding: "base64", JSONKeys: "username,password"}
+	files, err := p.getObjectFiles(testContext(t), kvClient, kvObject, 0644, klog.ObjectRef{})
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+	assert.Equal(t, []types.SecretFile{
+		{Path: "db/username", Content: []byte("admin"), FileMode: 0644, UID: "secret/secret1", Version: "v1"},
+		{Path: "db/password", Content: []byte("p@ss"), FileMode: 0644, UID: "secret/secret1", Version: "v1"},
+	}, files)
+}
@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// jwksUIDPrefix is the prefix of the UID of the JWKS file
+const jwksUIDPrefix = "jwks/"
+
+// jsonWebKey is a public JSON Web Key (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jsonWebKeySet is a JSON Web Key Set (RFC 7517)
+type jsonWebKeySet struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// newPublicJWK returns the public JWK of the key with the key vault key ID as kid
+func newPublicJWK(key *azkeys.JSONWebKey) (*jsonWebKey, error) {
+	jwk := &jsonWebKey{Kid: string(*key.KID)}
+	switch *key.Kty {
+	case azkeys.JSONWebKeyTypeRSA, azkeys.JSONWebKeyTypeRSAHSM:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N)
+		jwk.E = base64.RawURLEncoding.EncodeToString(key.E)
+	case azkeys.JSONWebKeyTypeEC, azkeys.JSONWebKeyTypeECHSM:
+		if key.Crv == nil {
+			return nil, fmt.Errorf("curve of key is nil")
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = string(*key.Crv)
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X)
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y)
+	default:
+		return nil, fmt.Errorf("JWK format only supported for RSA and EC keys, got key type '%s'", *key.Kty)
+	}
+	return jwk, nil
+}
+
+// marshal returns the content of the JWK file
+func (k *jsonWebKey) marshal() ([]byte, error) {
+	return json.MarshalIndent(k, "", "  ")
+}
+
+// jwksFile is the JWKS file written with the keys with objectFormat jwk
+type jwksFile struct {
+	path     string
+	fileMode int32
+}
+
+// parseJWKSFile parses the jwksFile parameter
+func parseJWKSFile(path string, kvObjects []types.KeyVaultObject, defaultFilePermission os.FileMode) (*jwksFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := validateFileName(path); err != nil {
+		return nil, fmt.Errorf("invalid jwksFile: %w", err)
+	}
+	found := false
+	for _, kvObject := range kvObjects {
+		found = found || isJWKObject(kvObject)
+	}
+	if !found {
+		return nil, fmt.Errorf("jwksFile is set but no object sets objectFormat: jwk")
+	}
+	//nolint:gosec // Safe to cast, file permissions fit within int32 range
+	return &jwksFile{path: path, fileMode: int32(defaultFilePermission)}, nil
+}
+
+// render returns the JWKS file with the JWK of every version of the keys with objectFormat jwk,
+// in the order of the objects and with the newest version first
+func (j *jwksFile) render(kvObjects []types.KeyVaultObject, files []types.SecretFile) (*types.SecretFile, error) {
+	filesByPath := make(map[string]types.SecretFile, len(files))
+	for _, file := range files {
+		filesByPath[file.Path] = file
+	}
+	if _, ok := filesByPath[j.path]; ok {
+		return nil, fmt.Errorf("jwksFile %s conflicts with an object file", j.path)
+	}
+
+	jwks := jsonWebKeySet{Keys: []json.RawMessage{}}
+	var jwkFiles []types.SecretFile
+	kids := make(map[string]bool)
+	for _, kvObject := range kvObjects {
+		if !isJWKObject(kvObject) {
+			continue
+		}
+		for _, path := range getVersionFileNames(kvObject, filesByPath) {
+			file := filesByPath[path]
+			var jwk jsonWebKey
+			if err := json.Unmarshal(file.Content, &jwk); err != nil {
+				return nil, fmt.Errorf("failed to parse JWK %s: %w", path, err)
+			}
+			// the same key version can be fetched by more than one object
+			if kids[jwk.Kid] {
+				continue
+			}
+			kids[jwk.Kid] = true
+			jwks.Keys = append(jwks.Keys, file.Content)
+			jwkFiles = append(jwkFiles, file)
+		}
+	}
+
+	content, err := json.MarshalIndent(jwks, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &types.SecretFile{
+		Path:     j.path,
+		Content:  content,
+		FileMode: j.fileMode,
+		UID:      jwksUIDPrefix + j.path,
+		Version:  combineVersions(jwkFiles),
+	}, nil
+}
+
+// isJWKObject returns true if the public key of the object is written as a JWK
+func isJWKObject(kvObject types.KeyVaultObject) bool {
+	return kvObject.ObjectType == types.VaultObjectTypeKey && strings.EqualFold(kvObject.ObjectFormat, types.ObjectFormatJWK)
+}
+
+// getVersionFileNames returns the names of the files written for the versions of the object,
+// newest version first
+func getVersionFileNames(kvObject types.KeyVaultObject, filesByPath map[string]types.SecretFile) []string {
+	if kvObject.IsSyncingSingleVersion() {
+		return []string{kvObject.GetFileName()}
+	}
+	var paths []string
+	for i := 0; ; i++ {
+		path := filepath.Join(kvObject.GetFileName(), strconv.Itoa(i))
+		if _, ok := filesByPath[path]; !ok {
+			return paths
+		}
+		paths = append(paths, path)
+	}
+}
@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestNewPublicJWK(t *testing.T) {
+	kid := azkeys.ID("https://test.vault.azure.net/keys/key1/v1")
+	rsaKty := azkeys.JSONWebKeyTypeRSAHSM
+	ecKty := azkeys.JSONWebKeyTypeEC
+	octKty := azkeys.JSONWebKeyTypeOctHSM
+	crv := azkeys.JSONWebKeyCurveNameP256
+
+	cases := []struct {
+		desc        string
+		key         *azkeys.JSONWebKey
+		expectedJWK *jsonWebKey
+		expectedErr string
+	}{
+		{
+			desc:        "RSA key",
+			key:         &azkeys.JSONWebKey{KID: &kid, Kty: &rsaKty, N: []byte{0xff, 0x01}, E: []byte{0x01, 0x00, 0x01}},
+			expectedJWK: &jsonWebKey{Kty: "RSA", Kid: string(kid), N: "_wE", E: "AQAB"},
+		},
+		{
+			desc:        "EC key",
+			key:         &azkeys.JSONWebKey{KID: &kid, Kty: &ecKty, Crv: &crv, X: []byte{0x01}, Y: []byte{0x02}},
+			expectedJWK: &jsonWebKey{Kty: "EC", Kid: string(kid), Crv: "P-256", X: "AQ", Y: "Ag"},
+		},
+		{
+			desc:        "oct key",
+			key:         &azkeys.JSONWebKey{KID: &kid, Kty: &octKty},
+			expectedErr: "JWK format only supported for RSA and EC keys, got key type 'oct-HSM'",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			jwk, err := newPublicJWK(tc.key)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedJWK, jwk)
+		})
+	}
+}
+
+func TestGetKeyJWK(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kid := azkeys.ID("https://test.vault.azure.net/keys/key1/v1")
+	kty := azkeys.JSONWebKeyTypeRSA
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{
+		Key: &azkeys.JSONWebKey{KID: &kid, Kty: &kty, N: []byte{0xff, 0x01}, E: []byte{0x01, 0x00, 0x01}},
+	}, nil)
+
+	result, err := p.getKey(testContext(t), kvClient, types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey, ObjectFormat: "jwk"})
+	assert.NoError(t, err)
+	expectedContent := `{
+  "kty": "RSA",
+  "kid": "https://test.vault.azure.net/keys/key1/v1",
+  "n": "_wE",
+  "e": "AQAB"
+}`
+	assert.Equal(t, []keyvaultObject{{content: expectedContent, version: "v1"}}, result)
+}
+
+func TestRenderJWKSFile(t *testing.T) {
+	jwk := func(kid string) []byte {
+		content, err := (&jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256", X: "AQ", Y: "Ag"}).marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+	kvObjects := []types.KeyVaultObject{
+		{ObjectName: "signing", ObjectType: types.VaultObjectTypeKey, ObjectFormat: types.ObjectFormatJWK, ObjectVersionHistory: 3},
+		{ObjectName: "signing", ObjectAlias: "current", ObjectType: types.VaultObjectTypeKey, ObjectFormat: "JWK"},
+		{ObjectName: "other", ObjectType: types.VaultObjectTypeKey},
+	}
+	files := []types.SecretFile{
+		{Path: "signing/0", Content: jwk("kid/v2"), Version: "v2"},
+		{Path: "signing/1", Content: jwk("kid/v1"), Version: "v1"},
+		{Path: "current", Content: jwk("kid/v2"), Version: "v2"},
+		{Path: "other", Content: []byte("-----BEGIN PUBLIC KEY-----"), Version: "v1"},
+	}
+
+	jwks, err := parseJWKSFile("jwks.json", kvObjects, 0644)
+	if err != nil {
+		t.Fatalf("parseJWKSFile() = %v, want nil", err)
+	}
+	file, err := jwks.render(kvObjects, files)
+	if err != nil {
+		t.Fatalf("render() = %v, want nil", err)
+	}
+	expectedContent := `{
+  "keys": [
+    {
+      "kty": "EC",
+      "kid": "kid/v2",
+      "crv": "P-256",
+      "x": "AQ",
+      "y": "Ag"
+    },
+    {
+      "kty": "EC",
+      "kid": "kid/v1",
+      "crv": "P-256",
+      "x": "AQ",
+      "y": "Ag"
+    }
+  ]
+}`
+	assert.Equal(t, expectedContent, string(file.Content))
+	assert.Equal(t, "jwks/jwks.json", file.UID)
+	assert.Equal(t, int32(0644), file.FileMode)
+	assert.Equal(t, combineVersions(files[:2]), file.Version)
+
+	_, err = jwks.render(kvObjects, append(files, types.SecretFile{Path: "jwks.json"}))
+	assert.EqualError(t, err, "jwksFile jwks.json conflicts with an object file")
+}
+
+func TestParseJWKSFile(t *testing.T) {
+	jwks, err := parseJWKSFile("", nil, 0644)
+	assert.NoError(t, err)
+	assert.Nil(t, jwks)
+
+	_, err = parseJWKSFile("/jwks.json", nil, 0644)
+	assert.EqualError(t, err, "invalid jwksFile: file name must be a relative path")
+
+	_, err = parseJWKSFile("jwks.json", []types.KeyVaultObject{{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey}}, 0644)
+	assert.EqualError(t, err, "jwksFile is set but no object sets objectFormat: jwk")
+}
+
+func TestAppendRenderedFilesConflict(t *testing.T) {
+	templates, err := parseTemplates("array:\n  - |\n    path: .env\n    template: 'x'", 0644)
+	if err != nil {
+		t.Fatalf("parseTemplates() = %v, want nil", err)
+	}
+	kvObjects := []types.KeyVaultObject{{ObjectName: "secret1", EnvName: "SECRET"}}
+	files := []types.SecretFile{{Path: "secret1", Content: []byte("value")}}
+
+	_, err = appendRenderedFiles(context.Background(), files, kvObjects, templates, &envFile{path: ".env", format: types.EnvFileFormatDotenv}, nil)
+	assert.EqualError(t, err, ".env is written by more than one of templates, envFile and jwksFile")
+}
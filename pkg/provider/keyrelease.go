@@ -0,0 +1,446 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	// register the hashes used for RSA-OAEP
+	_ "crypto/sha1" //nolint:gosec // CKM_RSA_AES_KEY_WRAP uses SHA-1 for RSA-OAEP
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// attestationEndpointTimeout is the timeout for getting the attestation token from the endpoint
+	attestationEndpointTimeout = 30 * time.Second
+	// maxAttestationTokenSize is the maximum size of the attestation token read from the endpoint
+	maxAttestationTokenSize = 1 << 20
+)
+
+// attestationClient gets the attestation token from the attestation endpoint, redirects aren't followed
+var attestationClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// aesKeyWrapPadIV is the alternative initial value of AES key wrap with padding (RFC 5649)
+var aesKeyWrapPadIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// releaseAlgorithmHashes maps the algorithms the released key can be wrapped with
+// to the hash used to wrap the transient AES key with RSA-OAEP
+var releaseAlgorithmHashes = map[azkeys.KeyEncryptionAlgorithm]crypto.Hash{
+	azkeys.KeyEncryptionAlgorithmCKMRSAAESKEYWRAP: crypto.SHA1,
+	azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP256: crypto.SHA256,
+	azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP384: crypto.SHA384,
+}
+
+// releasedKey is the payload of the signed object returned by the key release
+type releasedKey struct {
+	Response struct {
+		Key struct {
+			Key struct {
+				KeyHSM string `json:"key_hsm"`
+			} `json:"key"`
+		} `json:"key"`
+	} `json:"response"`
+}
+
+// keyHSM is the wrapped key material of the released key
+type keyHSM struct {
+	Header struct {
+		Enc string `json:"enc"`
+	} `json:"header"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// getReleaseAlgorithm returns the algorithm the released key is wrapped with
+func getReleaseAlgorithm(algorithm string) (azkeys.KeyEncryptionAlgorithm, error) {
+	if algorithm == "" {
+		return azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP256, nil
+	}
+	for alg := range releaseAlgorithmHashes {
+		if strings.EqualFold(algorithm, string(alg)) {
+			return alg, nil
+		}
+	}
+	return "", fmt.Errorf("invalid releaseAlgorithm: %s, should be CKM_RSA_AES_KEY_WRAP, RSA_AES_KEY_WRAP_256 or RSA_AES_KEY_WRAP_384", algorithm)
+}
+
+// KeyReleaseConfig is the Secure Key Release configuration of the provider. The attestation token
+// and the wrapping key are set by the operator as the provider reads them for all the pods of the node.
+type KeyReleaseConfig struct {
+	// AttestationTokenFile is the path of the file with the attestation token the keys are released with
+	AttestationTokenFile string
+	// AttestationEndpoint is the URL of the endpoint that returns the attestation token the keys are released with
+	AttestationEndpoint string
+	// WrappingKeyFile is the path of the PEM file with the RSA private key of the attestation token that
+	// the released keys are unwrapped with
+	WrappingKeyFile string
+}
+
+// Enabled returns true if keys can be released
+func (c KeyReleaseConfig) Enabled() bool {
+	return c.WrappingKeyFile != ""
+}
+
+// Validate validates the attestation token is read from exactly one of the file or the endpoint
+// and the wrapping key is set
+func (c KeyReleaseConfig) Validate() error {
+	if !c.Enabled() && c.AttestationTokenFile == "" && c.AttestationEndpoint == "" {
+		return nil
+	}
+	if (c.AttestationTokenFile == "") == (c.AttestationEndpoint == "") {
+		return fmt.Errorf("exactly one of the attestation token file and the attestation endpoint is required for key release")
+	}
+	if c.AttestationEndpoint != "" {
+		u, err := url.Parse(c.AttestationEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid attestation endpoint: %s, must be an absolute http or https URL", c.AttestationEndpoint)
+		}
+	}
+	if c.WrappingKeyFile == "" {
+		return fmt.Errorf("the wrapping key file is required for key release")
+	}
+	return nil
+}
+
+// releaseKey releases the version of the key in the key ID with the attestation token of the provider
+// and returns the private key in PKCS#8 PEM format, or the key material of a symmetric key
+func (p *provider) releaseKey(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject, kid azkeys.ID, kty azkeys.JSONWebKeyType) (string, error) {
+	if !p.keyRelease.Enabled() {
+		return "", fmt.Errorf("releaseKey requires key release to be enabled for the provider with an attestation token and a wrapping key")
+	}
+	// the validity of the algorithm is already checked in the validate function
+	algorithm, _ := getReleaseAlgorithm(kvObject.ReleaseAlgorithm)
+	wrappingKey, err := readWrappingKey(p.keyRelease.WrappingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	token, err := getAttestationToken(ctx, p.keyRelease)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(string(kid))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse key id %s: %w", kid, err)
+	}
+
+	released, err := kvClient.ReleaseKey(ctx, kvObject.ObjectName, kid.Version(), token, algorithm)
+	if err != nil {
+		return "", wrapReleaseError(err)
+	}
+	payload, err := verifyReleasedKey(released, u.Hostname(), p.releaseRoots)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify released key: %w", err)
+	}
+	keyMaterial, err := unwrapReleasedKey(payload, wrappingKey, algorithm)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap released key: %w", err)
+	}
+	if kty == azkeys.JSONWebKeyTypeOct || kty == azkeys.JSONWebKeyTypeOctHSM {
+		return string(keyMaterial), nil
+	}
+	if _, err = x509.ParsePKCS8PrivateKey(keyMaterial); err != nil {
+		return "", fmt.Errorf("released key is not a PKCS#8 private key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyMaterial})), nil
+}
+
+// wrapReleaseError explains the errors returned by the vault when the key release is rejected
+func wrapReleaseError(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusForbidden:
+			return fmt.Errorf("key release denied, the attestation token doesn't satisfy the release policy of the key or the identity doesn't have the release permission: %w", err)
+		case http.StatusBadRequest:
+			return fmt.Errorf("key release rejected, the key may not be exportable or the attestation token is invalid or expired: %w", err)
+		}
+	}
+	return fmt.Errorf("failed to release key: %w", err)
+}
+
+// readWrappingKey reads the RSA private key the released key is unwrapped with
+func readWrappingKey(file string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wrapping key file %s: %w", file, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("wrapping key file %s has no PEM private key", file)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse wrapping key file %s: %w", file, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("wrapping key file %s must have an RSA private key", file)
+	}
+	return rsaKey, nil
+}
+
+// getAttestationToken reads the attestation token from the file or endpoint of the key release config
+func getAttestationToken(ctx context.Context, config KeyReleaseConfig) (string, error) {
+	if config.AttestationTokenFile != "" {
+		data, err := os.ReadFile(config.AttestationTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read attestation token file %s: %w", config.AttestationTokenFile, err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("attestation token file %s is empty", config.AttestationTokenFile)
+		}
+		return token, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, attestationEndpointTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.AttestationEndpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for attestation endpoint %s: %w", config.AttestationEndpoint, err)
+	}
+	resp, err := attestationClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get attestation token from %s: %w", config.AttestationEndpoint, err)
+	}
+	defer resp.Body.Close()
+	// the body isn't added to the errors as they are returned to the pod
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("attestation endpoint %s returned status %d", config.AttestationEndpoint, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAttestationTokenSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read attestation token from %s: %w", config.AttestationEndpoint, err)
+	}
+	// the endpoint returns the token or a JSON object with the token
+	var tokenResponse struct {
+		Token string `json:"token"`
+	}
+	token := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &tokenResponse) == nil && tokenResponse.Token != "" {
+		token = tokenResponse.Token
+	}
+	if token == "" {
+		return "", fmt.Errorf("attestation endpoint %s returned an empty token", config.AttestationEndpoint)
+	}
+	return token, nil
+}
+
+// verifyReleasedKey verifies the signature of the JWS returned by the key release and returns its payload.
+// The JWS is signed with the certificate of the vault in the x5c header, the certificate must chain to
+// the roots, or the system roots if nil, and be issued for the host of the vault the key was released from.
+func verifyReleasedKey(released, vaultHost string, roots *x509.CertPool) ([]byte, error) {
+	parts := strings.Split(released, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("released key is not a JWS")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode released key header: %w", err)
+	}
+	var header struct {
+		Alg string   `json:"alg"`
+		X5c []string `json:"x5c"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse released key header: %w", err)
+	}
+	if len(header.X5c) == 0 {
+		return nil, fmt.Errorf("released key has no x5c certificate chain")
+	}
+	certs := make([]*x509.Certificate, 0, len(header.X5c))
+	for _, c := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode released key certificate: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse released key certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       vaultHost,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("released key certificate is not trusted for %s: %w", vaultHost, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode released key signature: %w", err)
+	}
+	if err = verifyJWSSignature(header.Alg, certs[0].PublicKey, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode released key payload: %w", err)
+	}
+	return payload, nil
+}
+
+// verifyJWSSignature verifies the JWS signature of the signing input with the public key
+func verifyJWSSignature(alg string, publicKey crypto.PublicKey, signingInput, signature []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported released key signature algorithm %q", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported released key signature algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	var err error
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return fmt.Errorf("released key signature algorithm %s doesn't match the RSA certificate", alg)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return fmt.Errorf("released key signature algorithm %s doesn't match the EC certificate", alg)
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			err = fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported released key certificate key type %T", publicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("released key signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// unwrapReleasedKey returns the key material of the verified payload of the released key.
+// The key material is wrapped with a transient AES key with AES key wrap with padding,
+// prefixed with the transient key wrapped with the wrapping key with RSA-OAEP.
+func unwrapReleasedKey(payload []byte, wrappingKey *rsa.PrivateKey, algorithm azkeys.KeyEncryptionAlgorithm) ([]byte, error) {
+	var key releasedKey
+	if err := json.Unmarshal(payload, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse released key payload: %w", err)
+	}
+	if key.Response.Key.Key.KeyHSM == "" {
+		return nil, fmt.Errorf("released key has no key_hsm")
+	}
+	keyHSMBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.Response.Key.Key.KeyHSM, "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key_hsm: %w", err)
+	}
+	var wrapped keyHSM
+	if err = json.Unmarshal(keyHSMBytes, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to parse key_hsm: %w", err)
+	}
+	if wrapped.Header.Enc != "" && !strings.EqualFold(wrapped.Header.Enc, string(algorithm)) {
+		return nil, fmt.Errorf("released key is wrapped with %s, expected %s", wrapped.Header.Enc, algorithm)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(wrapped.Ciphertext, "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key_hsm ciphertext: %w", err)
+	}
+
+	rsaSize := wrappingKey.Size()
+	if len(ciphertext) <= rsaSize {
+		return nil, fmt.Errorf("key_hsm ciphertext is too short")
+	}
+	transientKey, err := rsa.DecryptOAEP(releaseAlgorithmHashes[algorithm].New(), rand.Reader, wrappingKey, ciphertext[:rsaSize], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap transient key with the wrapping key: %w", err)
+	}
+	return aesKeyUnwrapPad(transientKey, ciphertext[rsaSize:])
+}
+
+// aesKeyUnwrapPad unwraps the ciphertext with AES key wrap with padding (RFC 5649)
+func aesKeyUnwrapPad(kek, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < 16 || len(ciphertext)%8 != 0 {
+		return nil, fmt.Errorf("invalid wrapped key length %d", len(ciphertext))
+	}
+
+	n := len(ciphertext)/8 - 1
+	a := make([]byte, 8)
+	r := make([]byte, n*8)
+	buf := make([]byte, 16)
+	if n == 1 {
+		// a single block is encrypted with AES directly
+		block.Decrypt(buf, ciphertext)
+		copy(a, buf[:8])
+		copy(r, buf[8:])
+	} else {
+		copy(a, ciphertext[:8])
+		copy(r, ciphertext[8:])
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				//nolint:gosec // n is bounded by the length of the ciphertext
+				t := uint64(n*j + i)
+				binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a)^t)
+				copy(buf[8:], r[(i-1)*8:i*8])
+				block.Decrypt(buf, buf)
+				copy(a, buf[:8])
+				copy(r[(i-1)*8:i*8], buf[8:])
+			}
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a[:4], aesKeyWrapPadIV) != 1 {
+		return nil, fmt.Errorf("wrapped key integrity check failed")
+	}
+	length := int(binary.BigEndian.Uint32(a[4:]))
+	if length <= (n-1)*8 || length > n*8 {
+		return nil, fmt.Errorf("wrapped key integrity check failed")
+	}
+	for _, b := range r[length:] {
+		if b != 0 {
+			return nil, fmt.Errorf("wrapped key integrity check failed")
+		}
+	}
+	return r[:length], nil
+}
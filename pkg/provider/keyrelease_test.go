@@ -0,0 +1,518 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// aesKeyWrapPad wraps the plaintext with AES key wrap with padding (RFC 5649)
+func aesKeyWrapPad(t *testing.T, kek, plaintext []byte) []byte {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, (len(plaintext)+7)/8*8)
+	copy(r, plaintext)
+	a := append([]byte{}, aesKeyWrapPadIV...)
+	a = binary.BigEndian.AppendUint32(a, uint32(len(plaintext)))
+	n := len(r) / 8
+	buf := make([]byte, 16)
+	if n == 1 {
+		copy(buf, a)
+		copy(buf[8:], r)
+		block.Encrypt(buf, buf)
+		return buf
+	}
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^uint64(n*j+i))
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	return append(a, r...)
+}
+
+func TestAESKeyUnwrapPad(t *testing.T) {
+	// test vectors from RFC 5649 section 6
+	kek := mustDecodeHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	cases := []struct {
+		desc       string
+		ciphertext string
+		plaintext  string
+	}{
+		{
+			desc:       "20 octets",
+			ciphertext: "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a",
+			plaintext:  "c37b7e6492584340bed12207808941155068f738",
+		},
+		{
+			desc:       "7 octets",
+			ciphertext: "afbeb0f07dfbf5419200f2ccb50bb24f",
+			plaintext:  "466f7250617369",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			plaintext, err := aesKeyUnwrapPad(kek, mustDecodeHex(t, tc.ciphertext))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.plaintext, hex.EncodeToString(plaintext))
+			assert.Equal(t, tc.ciphertext, hex.EncodeToString(aesKeyWrapPad(t, kek, plaintext)))
+
+			tampered := mustDecodeHex(t, tc.ciphertext)
+			tampered[0] ^= 1
+			_, err = aesKeyUnwrapPad(kek, tampered)
+			assert.EqualError(t, err, "wrapped key integrity check failed")
+		})
+	}
+}
+
+// releaseSigner signs released keys with a certificate issued for the vault host by a test root
+type releaseSigner struct {
+	roots *x509.CertPool
+	key   *rsa.PrivateKey
+	x5c   []string
+}
+
+// newReleaseSigner returns a signer with a certificate for the host issued by a new root
+func newReleaseSigner(t *testing.T, host string) *releaseSigner {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return &releaseSigner{
+		roots: roots,
+		key:   key,
+		x5c:   []string{base64.StdEncoding.EncodeToString(leafDER), base64.StdEncoding.EncodeToString(rootDER)},
+	}
+}
+
+// sign returns the payload signed as a JWS with RS256
+func (s *releaseSigner) sign(t *testing.T, payload []byte) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]interface{}{"alg": "RS256", "x5c": s.x5c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newReleasedKeyPayload returns the payload of a released key with the key material wrapped for the wrapping key
+func newReleasedKeyPayload(t *testing.T, wrappingKey *rsa.PublicKey, keyMaterial []byte) []byte {
+	transientKey := make([]byte, 32)
+	if _, err := rand.Read(transientKey); err != nil {
+		t.Fatal(err)
+	}
+	wrappedTransientKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, wrappingKey, transientKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := append(wrappedTransientKey, aesKeyWrapPad(t, transientKey, keyMaterial)...)
+	keyHSM, err := json.Marshal(map[string]interface{}{
+		"schema_version": "1.0",
+		"header":         map[string]string{"alg": "dir", "enc": string(azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP256)},
+		"ciphertext":     base64.RawURLEncoding.EncodeToString(ciphertext),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(fmt.Sprintf(`{"response":{"key":{"key":{"kty":"EC-HSM","key_hsm":%q}}}}`, base64.RawURLEncoding.EncodeToString(keyHSM)))
+}
+
+// newKeyReleaseConfig writes the wrapping key and the attestation token to files and returns the key release config
+func newKeyReleaseConfig(t *testing.T, wrappingKey *rsa.PrivateKey) KeyReleaseConfig {
+	t.Helper()
+	dir := t.TempDir()
+	wrappingKeyFile := filepath.Join(dir, "wrapping.pem")
+	wrappingKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(wrappingKey)})
+	if err := os.WriteFile(wrappingKeyFile, wrappingKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("attestation-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return KeyReleaseConfig{AttestationTokenFile: tokenFile, WrappingKeyFile: wrappingKeyFile}
+}
+
+func TestGetKeyRelease(t *testing.T) {
+	wrappingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRelease := newKeyReleaseConfig(t, wrappingKey)
+	signer := newReleaseSigner(t, "test.vault.azure.net")
+	otherSigner := newReleaseSigner(t, "other.vault.azure.net")
+	// trust the root of the other vault so the released key is only rejected for its host
+	signer.roots.AddCert(mustParseX5c(t, otherSigner.x5c[1]))
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid := azkeys.ID("https://test.vault.azure.net/keys/key1/v1")
+	kty := azkeys.JSONWebKeyTypeECHSM
+	keyBundle := func(exportable bool) *azkeys.KeyBundle {
+		return &azkeys.KeyBundle{
+			Key:        &azkeys.JSONWebKey{KID: &kid, Kty: &kty},
+			Attributes: &azkeys.KeyAttributes{Exportable: to.BoolPtr(exportable)},
+		}
+	}
+	kvObject := types.KeyVaultObject{
+		ObjectName: "key1",
+		ObjectType: types.VaultObjectTypeKey,
+		ReleaseKey: true,
+	}
+
+	cases := []struct {
+		desc            string
+		exportable      bool
+		keyRelease      KeyReleaseConfig
+		released        string
+		releaseErr      error
+		expectedContent string
+		expectedErr     string
+	}{
+		{
+			desc:            "key released",
+			exportable:      true,
+			keyRelease:      keyRelease,
+			released:        signer.sign(t, newReleasedKeyPayload(t, &wrappingKey.PublicKey, der)),
+			expectedContent: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		},
+		{
+			desc:        "key not exportable",
+			exportable:  false,
+			keyRelease:  keyRelease,
+			expectedErr: "failed to release key. key is not exportable",
+		},
+		{
+			desc:        "key release not enabled for the provider",
+			exportable:  true,
+			expectedErr: "releaseKey requires key release to be enabled for the provider",
+		},
+		{
+			desc:        "release denied",
+			exportable:  true,
+			keyRelease:  keyRelease,
+			releaseErr:  &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "AccessDenied"},
+			expectedErr: "key release denied, the attestation token doesn't satisfy the release policy of the key",
+		},
+		{
+			desc:        "attestation token rejected",
+			exportable:  true,
+			keyRelease:  keyRelease,
+			releaseErr:  &azcore.ResponseError{StatusCode: http.StatusBadRequest, ErrorCode: "BadParameter"},
+			expectedErr: "key release rejected, the key may not be exportable or the attestation token is invalid or expired",
+		},
+		{
+			desc:        "released key signed for another vault",
+			exportable:  true,
+			keyRelease:  keyRelease,
+			released:    otherSigner.sign(t, newReleasedKeyPayload(t, &wrappingKey.PublicKey, der)),
+			expectedErr: "failed to verify released key",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			p := &provider{reporter: metrics.NewStatsReporter(), keyRelease: tc.keyRelease, releaseRoots: signer.roots}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(keyBundle(tc.exportable), nil)
+			if tc.exportable && tc.keyRelease.Enabled() {
+				kvClient.EXPECT().ReleaseKey(gomock.Any(), "key1", "v1", "attestation-token", azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP256).
+					Return(tc.released, tc.releaseErr)
+			}
+
+			result, err := p.getKey(testContext(t), kvClient, kvObject)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []keyvaultObject{{content: tc.expectedContent, version: "v1"}}, result)
+		})
+	}
+}
+
+func mustParseX5c(t *testing.T, x5c string) *x509.Certificate {
+	t.Helper()
+	der, err := base64.StdEncoding.DecodeString(x5c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGetKeyReleaseOct(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	wrappingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newReleaseSigner(t, "testhsm.managedhsm.azure.net")
+	p := &provider{reporter: metrics.NewStatsReporter(), keyRelease: newKeyReleaseConfig(t, wrappingKey), releaseRoots: signer.roots}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kid := azkeys.ID("https://testhsm.managedhsm.azure.net/keys/key1/v1")
+	kty := azkeys.JSONWebKeyTypeOctHSM
+	symmetricKey := []byte("0123456789abcdef0123456789abcdef")
+	// the key material of an exportable key isn't returned by get
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{
+		Key:        &azkeys.JSONWebKey{KID: &kid, Kty: &kty},
+		Attributes: &azkeys.KeyAttributes{Exportable: to.BoolPtr(true)},
+	}, nil)
+	kvClient.EXPECT().ReleaseKey(gomock.Any(), "key1", "v1", "attestation-token", azkeys.KeyEncryptionAlgorithmRSAAESKEYWRAP256).
+		Return(signer.sign(t, newReleasedKeyPayload(t, &wrappingKey.PublicKey, symmetricKey)), nil)
+
+	result, err := p.getKey(testContext(t), kvClient, types.KeyVaultObject{
+		ObjectName: "key1",
+		ObjectType: types.VaultObjectTypeKey,
+		ReleaseKey: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []keyvaultObject{{content: string(symmetricKey), version: "v1"}}, result)
+}
+
+func TestVerifyReleasedKey(t *testing.T) {
+	signer := newReleaseSigner(t, "test.vault.azure.net")
+	released := signer.sign(t, []byte(`{"response":{}}`))
+	parts := strings.Split(released, ".")
+
+	payload, err := verifyReleasedKey(released, "test.vault.azure.net", signer.roots)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"response":{}}`, string(payload))
+
+	cases := []struct {
+		desc        string
+		released    string
+		host        string
+		roots       *x509.CertPool
+		expectedErr string
+	}{
+		{
+			desc:        "tampered payload",
+			released:    parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"response":{"key":{}}}`)) + "." + parts[2],
+			host:        "test.vault.azure.net",
+			roots:       signer.roots,
+			expectedErr: "released key signature verification failed",
+		},
+		{
+			desc:        "certificate for another vault",
+			released:    released,
+			host:        "attacker.vault.azure.net",
+			roots:       signer.roots,
+			expectedErr: "released key certificate is not trusted for attacker.vault.azure.net",
+		},
+		{
+			desc:        "untrusted root",
+			released:    released,
+			host:        "test.vault.azure.net",
+			roots:       newReleaseSigner(t, "test.vault.azure.net").roots,
+			expectedErr: "released key certificate is not trusted for test.vault.azure.net",
+		},
+		{
+			desc:        "no certificate chain",
+			released:    base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+			host:        "test.vault.azure.net",
+			roots:       signer.roots,
+			expectedErr: "released key has no x5c certificate chain",
+		},
+		{
+			desc:        "not a JWS",
+			released:    "released",
+			host:        "test.vault.azure.net",
+			roots:       signer.roots,
+			expectedErr: "released key is not a JWS",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := verifyReleasedKey(tc.released, tc.host, tc.roots)
+			assert.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestKeyReleaseConfigValidate(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      KeyReleaseConfig
+		expectedErr string
+	}{
+		{
+			desc: "disabled",
+		},
+		{
+			desc:   "attestation token file",
+			config: KeyReleaseConfig{AttestationTokenFile: "/var/run/token", WrappingKeyFile: "/var/run/key.pem"},
+		},
+		{
+			desc:   "attestation endpoint",
+			config: KeyReleaseConfig{AttestationEndpoint: "http://localhost:8080/attest", WrappingKeyFile: "/var/run/key.pem"},
+		},
+		{
+			desc:        "no attestation token",
+			config:      KeyReleaseConfig{WrappingKeyFile: "/var/run/key.pem"},
+			expectedErr: "exactly one of the attestation token file and the attestation endpoint is required for key release",
+		},
+		{
+			desc:        "attestation token file and endpoint",
+			config:      KeyReleaseConfig{AttestationTokenFile: "/var/run/token", AttestationEndpoint: "http://localhost:8080/attest", WrappingKeyFile: "/var/run/key.pem"},
+			expectedErr: "exactly one of the attestation token file and the attestation endpoint is required for key release",
+		},
+		{
+			desc:        "invalid attestation endpoint",
+			config:      KeyReleaseConfig{AttestationEndpoint: "localhost:8080", WrappingKeyFile: "/var/run/key.pem"},
+			expectedErr: "invalid attestation endpoint: localhost:8080, must be an absolute http or https URL",
+		},
+		{
+			desc:        "no wrapping key",
+			config:      KeyReleaseConfig{AttestationTokenFile: "/var/run/token"},
+			expectedErr: "the wrapping key file is required for key release",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestGetAttestationToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			fmt.Fprint(w, `{"token": "json-token"}`)
+		case "/raw":
+			fmt.Fprint(w, "raw-token\n")
+		case "/redirect":
+			http.Redirect(w, r, "/raw", http.StatusFound)
+		default:
+			http.Error(w, "attestation failed for 10.0.0.1", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	token, err := getAttestationToken(testContext(t), KeyReleaseConfig{AttestationEndpoint: server.URL + "/json"})
+	assert.NoError(t, err)
+	assert.Equal(t, "json-token", token)
+
+	token, err = getAttestationToken(testContext(t), KeyReleaseConfig{AttestationEndpoint: server.URL + "/raw"})
+	assert.NoError(t, err)
+	assert.Equal(t, "raw-token", token)
+
+	// the response body isn't returned in the error
+	_, err = getAttestationToken(testContext(t), KeyReleaseConfig{AttestationEndpoint: server.URL + "/error"})
+	assert.EqualError(t, err, fmt.Sprintf("attestation endpoint %s/error returned status 500", server.URL))
+
+	_, err = getAttestationToken(testContext(t), KeyReleaseConfig{AttestationEndpoint: server.URL + "/redirect"})
+	assert.EqualError(t, err, fmt.Sprintf("attestation endpoint %s/redirect returned status 302", server.URL))
+
+	_, err = getAttestationToken(context.Background(), KeyReleaseConfig{AttestationTokenFile: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "failed to read attestation token file")
+}
@@ -0,0 +1,677 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec // 3DES is only used for the legacy PKCS#12 encryption
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1" //nolint:gosec // SHA-1 is used by the legacy PKCS#12 encryption and the JKS format
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// keystoreIterations is the iteration count of the PBKDF2 key derivation and the MAC of an aes256 keystore
+	keystoreIterations = 10000
+	// legacyKeystoreIterations is the iteration count of the key derivation and the MAC of a des3 keystore
+	legacyKeystoreIterations = 2048
+	// jksMagic is the magic number at the start of a JKS keystore
+	jksMagic = 0xfeedfeed
+	// jksVersion is the version of the JKS keystore format
+	jksVersion = 2
+	// jksPrivateKeyEntry is the tag of a private key entry in a JKS keystore
+	jksPrivateKeyEntry = 1
+	// jksDigestWhitener is appended to the password in the integrity check of a JKS keystore
+	jksDigestWhitener = "Mighty Aphrodite"
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyDESCBC  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256           = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC                = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256                   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidJKSKeyProtector          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+)
+
+// pfxPdu is the PFX structure of RFC 7292
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// isKeystoreObject returns true if the certificate of the object is written as a keystore
+func isKeystoreObject(kvObject types.KeyVaultObject) bool {
+	return strings.EqualFold(kvObject.ObjectFormat, types.ObjectFormatPKCS12) || strings.EqualFold(kvObject.ObjectFormat, types.ObjectFormatJKS)
+}
+
+// getObjectPassword returns the password of the keystore or the encrypted private key of the object
+// from the objectPasswordSecret in the same Key Vault
+func getObjectPassword(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (string, error) {
+	secret, err := kvClient.GetSecret(ctx, kvObject.ObjectPasswordSecret, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get objectPasswordSecret %s: %w", kvObject.ObjectPasswordSecret, err)
+	}
+	if secret.Value == nil || *secret.Value == "" {
+		return "", fmt.Errorf("objectPasswordSecret %s is empty", kvObject.ObjectPasswordSecret)
+	}
+	return *secret.Value, nil
+}
+
+// buildKeystore returns the keystore of the object with the private key and certificate chain in the PEM content.
+// The salts and IVs are derived from the version and the certificates, so the keystore of a version only
+// changes when the password changes.
+func buildKeystore(kvObject types.KeyVaultObject, content, version, password string) ([]byte, error) {
+	key, certs, err := parseCertAndKey(content)
+	if err != nil {
+		return nil, err
+	}
+	alias := kvObject.KeystoreAlias
+	if alias == "" {
+		alias = kvObject.ObjectName
+	}
+	var raw [][]byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw)
+	}
+	entropy := newSaltEntropy(version, raw)
+	if strings.EqualFold(kvObject.ObjectFormat, types.ObjectFormatJKS) {
+		// the creation date of the entry is the start of the validity of the certificate
+		return encodeJKS(entropy, key, certs, password, alias, certs[0].NotBefore)
+	}
+	return encodePKCS12(entropy, key, certs, password, alias, strings.EqualFold(kvObject.KeystoreEncryption, types.KeystoreEncryptionDES3))
+}
+
+// parseCertAndKey returns the private key and the certificates in the PEM content. The certificates
+// are ordered from the certificate of the private key to the root, followed by any other certificates.
+func parseCertAndKey(content string) (crypto.Signer, []*x509.Certificate, error) {
+	var key crypto.Signer
+	var certs []*x509.Certificate
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type == types.CertificateType {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			certs = append(certs, cert)
+			continue
+		}
+		if key != nil {
+			return nil, nil, fmt.Errorf("found more than one private key")
+		}
+		parsed, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported private key type %T", parsed)
+		}
+		key = signer
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("private key not found, the certificate must be exportable to be written as a keystore")
+	}
+	certs, err := orderCertChain(key, certs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, certs, nil
+}
+
+// orderCertChain orders the certificates from the certificate of the key to the root
+func orderCertChain(key crypto.Signer, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	type publicKey interface {
+		Equal(crypto.PublicKey) bool
+	}
+	leaf := -1
+	for i, cert := range certs {
+		if pub, ok := cert.PublicKey.(publicKey); ok && pub.Equal(key.Public()) {
+			leaf = i
+			break
+		}
+	}
+	if leaf == -1 {
+		return nil, fmt.Errorf("certificate of the private key not found")
+	}
+
+	used := make([]bool, len(certs))
+	used[leaf] = true
+	chain := []*x509.Certificate{certs[leaf]}
+	for {
+		last := chain[len(chain)-1]
+		if bytes.Equal(last.RawIssuer, last.RawSubject) {
+			break
+		}
+		next := -1
+		for i, cert := range certs {
+			if !used[i] && bytes.Equal(cert.RawSubject, last.RawIssuer) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			break
+		}
+		used[next] = true
+		chain = append(chain, certs[next])
+	}
+	for i, cert := range certs {
+		if !used[i] {
+			chain = append(chain, cert)
+		}
+	}
+	return chain, nil
+}
+
+// encodePKCS12 returns a PKCS#12 keystore (RFC 7292) with the private key and certificates.
+// The key and certificates are encrypted with PBES2 and AES-256-CBC and the keystore is
+// authenticated with HMAC-SHA256, or with 3DES and HMAC-SHA1 when legacy is set.
+func encodePKCS12(entropy io.Reader, key crypto.Signer, certs []*x509.Certificate, password, alias string, legacy bool) ([]byte, error) {
+	bmpPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypt := func(data []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+		if legacy {
+			return encryptPBE(entropy, data, bmpPassword)
+		}
+		return encryptPBES2(entropy, data, []byte(password))
+	}
+
+	localKeyID := sha1.Sum(certs[0].Raw) //nolint:gosec // the local key ID only matches the key to its certificate
+	attributes, err := keystoreAttributes(alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificates are encrypted in an encrypted data content
+	var certBags []safeBag
+	for i, cert := range certs {
+		value, err := asn1.Marshal(cert.Raw)
+		if err != nil {
+			return nil, err
+		}
+		bag, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: explicitTag(value)})
+		if err != nil {
+			return nil, err
+		}
+		entry := safeBag{ID: oidCertBag, Value: explicitTag(bag)}
+		if i == 0 {
+			entry.Attributes = attributes
+		}
+		certBags = append(certBags, entry)
+	}
+	certSafe, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err := encrypt(certSafe)
+	if err != nil {
+		return nil, err
+	}
+	certContent, err := asn1.Marshal(encryptedData{
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is encrypted in a shrouded key bag of a data content
+	algorithm, encrypted, err = encrypt(keyDER)
+	if err != nil {
+		return nil, err
+	}
+	shroudedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted})
+	if err != nil {
+		return nil, err
+	}
+	keySafe, err := asn1.Marshal([]safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicitTag(shroudedKey), Attributes: attributes}})
+	if err != nil {
+		return nil, err
+	}
+	keyContent, err := asn1.Marshal(keySafe)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{
+		{ContentType: oidEncryptedDataContentType, Content: explicitTag(certContent)},
+		{ContentType: oidDataContentType, Content: explicitTag(keyContent)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macHash, macOID, iterations := sha256.New, oidSHA256, keystoreIterations
+	if legacy {
+		macHash, macOID, iterations = sha1.New, oidSHA1, legacyKeystoreIterations
+	}
+	macSalt, err := readBytes(entropy, 8)
+	if err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(macHash, 3, bmpPassword, macSalt, iterations, macHash().Size())
+	mac := hmac.New(macHash, macKey)
+	mac.Write(authSafe)
+
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicitTag(authSafeContent)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: macOID, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: iterations,
+		},
+	})
+}
+
+// keystoreAttributes returns the friendly name and local key ID attributes of the key and its certificate
+func keystoreAttributes(alias string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	friendlyName, err := bmpString(alias)
+	if err != nil {
+		return nil, err
+	}
+	// the null terminator is only part of the password
+	friendlyName = friendlyName[:len(friendlyName)-2]
+	name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: friendlyName})
+	if err != nil {
+		return nil, err
+	}
+	id, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return []pkcs12Attribute{
+		{ID: oidFriendlyName, Value: setOf(name)},
+		{ID: oidLocalKeyID, Value: setOf(id)},
+	}, nil
+}
+
+// encryptPBE encrypts the data with pbeWithSHAAnd3-KeyTripleDES-CBC
+func encryptPBE(entropy io.Reader, data, bmpPassword []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt, err := readBytes(entropy, 8)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: legacyKeystoreIterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	key := pkcs12KDF(sha1.New, 1, bmpPassword, salt, legacyKeystoreIterations, 24)
+	iv := pkcs12KDF(sha1.New, 2, bmpPassword, salt, legacyKeystoreIterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key) //nolint:gosec // 3DES is only used for the legacy PKCS#12 encryption
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyDESCBC, Parameters: asn1.RawValue{FullBytes: params}}
+	return algorithm, encryptCBC(block, iv, data), nil
+}
+
+// encryptPBES2 encrypts the data with PBES2 (RFC 8018), PBKDF2 with HMAC-SHA256 and AES-256-CBC
+func encryptPBES2(entropy io.Reader, data, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt, err := readBytes(entropy, 16)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	iv, err := readBytes(entropy, aes.BlockSize)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: keystoreIterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, string(password), salt, keystoreIterations, 32)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return algorithm, encryptCBC(block, iv, data), nil
+}
+
+// encryptCBC encrypts the data padded with PKCS#7 in CBC mode
+func encryptCBC(block cipher.Block, iv, data []byte) []byte {
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted
+}
+
+// pkcs12KDF derives a key from the BMP password with the key derivation of RFC 7292 appendix B.2
+func pkcs12KDF(newHash func() hash.Hash, id byte, bmpPassword, salt []byte, iterations, size int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+	// fill repeats b to a multiple of v bytes
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(bmpPassword)...)
+
+	var out []byte
+	for len(out) < size {
+		h.Reset()
+		h.Write(d)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(nil)
+		}
+		out = append(out, a...)
+
+		// every v byte block of the input is incremented by b + 1
+		b := fill(a)
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString returns the null terminated big endian UTF-16 encoding of the string used by PKCS#12
+func bmpString(s string) ([]byte, error) {
+	out := make([]byte, 0, 2*len(s)+2)
+	for _, r := range s {
+		if r > 0xffff {
+			return nil, fmt.Errorf("character %U is not supported in a keystore", r)
+		}
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return append(out, 0, 0), nil
+}
+
+// encodeJKS returns a Java keystore with a private key entry with the private key and certificate chain
+func encodeJKS(entropy io.Reader, key crypto.Signer, certs []*x509.Certificate, password, alias string, created time.Time) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	passwordBytes := javaPasswordBytes(password)
+	protectedKey, err := protectJKSKey(entropy, keyDER, passwordBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeUint32 := func(v uint32) { _ = binary.Write(&buf, binary.BigEndian, v) }
+	writeUTF := func(s string) error {
+		if len(s) > 0xffff {
+			return fmt.Errorf("%q is too long for a keystore", s)
+		}
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(s))) //nolint:gosec // the length is checked above
+		buf.WriteString(s)
+		return nil
+	}
+
+	writeUint32(jksMagic)
+	writeUint32(jksVersion)
+	writeUint32(1)
+	writeUint32(jksPrivateKeyEntry)
+	// aliases are case insensitive and stored in lower case by Java
+	if err := writeUTF(strings.ToLower(alias)); err != nil {
+		return nil, err
+	}
+	_ = binary.Write(&buf, binary.BigEndian, created.UnixMilli())
+	writeUint32(uint32(len(protectedKey))) //nolint:gosec // the key length fits within uint32 range
+	buf.Write(protectedKey)
+	writeUint32(uint32(len(certs))) //nolint:gosec // the number of certificates fits within uint32 range
+	for _, cert := range certs {
+		if err := writeUTF("X.509"); err != nil {
+			return nil, err
+		}
+		writeUint32(uint32(len(cert.Raw))) //nolint:gosec // the certificate length fits within uint32 range
+		buf.Write(cert.Raw)
+	}
+
+	digest := jksDigest(passwordBytes, buf.Bytes())
+	buf.Write(digest)
+	return buf.Bytes(), nil
+}
+
+// protectJKSKey encrypts the PKCS#8 private key with the proprietary algorithm of the Sun JKS key protector
+func protectJKSKey(entropy io.Reader, keyDER, passwordBytes []byte) ([]byte, error) {
+	salt, err := readBytes(entropy, sha1.Size)
+	if err != nil {
+		return nil, err
+	}
+	// the key is XORed with a keystream of chained SHA-1 digests of the password and the salt
+	encrypted := make([]byte, len(keyDER))
+	digest := salt
+	for i := 0; i < len(keyDER); i += sha1.Size {
+		sum := sha1.Sum(append(bytes.Clone(passwordBytes), digest...)) //nolint:gosec // required by the JKS format
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(keyDER); j++ {
+			encrypted[i+j] = keyDER[i+j] ^ digest[j]
+		}
+	}
+	check := sha1.Sum(append(bytes.Clone(passwordBytes), keyDER...)) //nolint:gosec // required by the JKS format
+
+	protected := append(append(salt, encrypted...), check[:]...)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// jksDigest returns the integrity check of the JKS keystore data
+func jksDigest(passwordBytes, data []byte) []byte {
+	h := sha1.New() //nolint:gosec // required by the JKS format
+	h.Write(passwordBytes)
+	h.Write([]byte(jksDigestWhitener))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// javaPasswordBytes returns the password as the big endian UTF-16 code units of a Java string
+func javaPasswordBytes(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	out := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		out = append(out, byte(u>>8), byte(u))
+	}
+	return out
+}
+
+// explicitTag returns the DER content in an explicit context specific tag 0
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// setOf returns the DER value in a set
+func setOf(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+// saltEntropy is the SHA-256 counter mode stream the salts and IVs of a keystore or an encrypted
+// private key are read from
+type saltEntropy struct {
+	seed    []byte
+	counter uint32
+	buf     []byte
+}
+
+// newSaltEntropy returns the stream of the salts and IVs of the version with the public data, the certificates
+// or the public key. The stream doesn't depend on the password or the private key, and is unique to the version
+// of the object.
+func newSaltEntropy(version string, public [][]byte) io.Reader {
+	h := sha256.New()
+	h.Write([]byte("keystore\x00"))
+	h.Write([]byte(version))
+	for _, data := range public {
+		sum := sha256.Sum256(data)
+		h.Write(sum[:])
+	}
+	return &saltEntropy{seed: h.Sum(nil)}
+}
+
+func (e *saltEntropy) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(e.buf) == 0 {
+			block := make([]byte, len(e.seed)+4)
+			copy(block, e.seed)
+			binary.BigEndian.PutUint32(block[len(e.seed):], e.counter)
+			e.counter++
+			sum := sha256.Sum256(block)
+			e.buf = sum[:]
+		}
+		copied := copy(p[n:], e.buf)
+		e.buf = e.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// readBytes returns n bytes read from the reader
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return b, nil
+}
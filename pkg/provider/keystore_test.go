@@ -0,0 +1,547 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec // 3DES is only used for the legacy PKCS#12 encryption
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // required by the JKS format
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// newTestCertChain returns a private key, the chain of its certificate from the leaf to the root
+// and the PEM content with the key and the certificates out of order
+func newTestCertChain(t *testing.T) (*ecdsa.PrivateKey, []*x509.Certificate, string) {
+	t.Helper()
+	newCert := func(name string, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  parent == nil || name != "leaf",
+			BasicConstraintsValid: true,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		return cert
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		return key
+	}
+	rootKey, intermediateKey, leafKey := newKey(), newKey(), newKey()
+	root := newCert("root", rootKey, nil, nil)
+	intermediate := newCert("intermediate", intermediateKey, root, rootKey)
+	leaf := newCert("leaf", leafKey, intermediate, intermediateKey)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	var content []byte
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	for _, cert := range []*x509.Certificate{root, leaf, intermediate} {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: types.CertificateType, Bytes: cert.Raw})...)
+	}
+	return leafKey, []*x509.Certificate{leaf, intermediate, root}, string(content)
+}
+
+func TestParseCertAndKey(t *testing.T) {
+	key, chain, content := newTestCertChain(t)
+
+	parsedKey, certs, err := parseCertAndKey(content)
+	if err != nil {
+		t.Fatalf("parseCertAndKey() = %v, want nil", err)
+	}
+	assert.True(t, key.Equal(parsedKey))
+	assert.Equal(t, chain, certs)
+
+	certsOnly := content[strings.Index(content, "-----BEGIN CERTIFICATE-----"):]
+	if _, _, err = parseCertAndKey(certsOnly); err == nil || !strings.Contains(err.Error(), "private key not found") {
+		t.Fatalf("parseCertAndKey() = %v, want private key not found error", err)
+	}
+	keyOnly := content[:strings.Index(content, "-----BEGIN CERTIFICATE-----")]
+	if _, _, err = parseCertAndKey(keyOnly); err == nil || !strings.Contains(err.Error(), "certificate of the private key not found") {
+		t.Fatalf("parseCertAndKey() = %v, want certificate not found error", err)
+	}
+}
+
+func TestEncodePKCS12Legacy(t *testing.T) {
+	key, chain, _ := newTestCertChain(t)
+
+	pfx, err := encodePKCS12(rand.Reader, key, chain, "pässword", "server", true)
+	if err != nil {
+		t.Fatalf("encodePKCS12() = %v, want nil", err)
+	}
+	if _, err = pkcs12.ToPEM(pfx, "wrong"); err == nil {
+		t.Fatalf("ToPEM() with wrong password = nil, want error")
+	}
+	blocks, err := pkcs12.ToPEM(pfx, "pässword")
+	if err != nil {
+		t.Fatalf("ToPEM() = %v, want nil", err)
+	}
+	var certs [][]byte
+	for _, block := range blocks {
+		if block.Type == types.CertificateType {
+			certs = append(certs, block.Bytes)
+			continue
+		}
+		parsed, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		assert.True(t, key.Equal(parsed))
+		assert.Equal(t, "server", block.Headers["friendlyName"])
+	}
+	assert.Equal(t, [][]byte{chain[0].Raw, chain[1].Raw, chain[2].Raw}, certs)
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	key, chain, _ := newTestCertChain(t)
+	password := "pässword"
+
+	pfx, err := encodePKCS12(rand.Reader, key, chain, password, "server", false)
+	if err != nil {
+		t.Fatalf("encodePKCS12() = %v, want nil", err)
+	}
+
+	var pdu pfxPdu
+	if _, err = asn1.Unmarshal(pfx, &pdu); err != nil {
+		t.Fatalf("failed to parse PFX: %v", err)
+	}
+	var authSafe []byte
+	if _, err = asn1.Unmarshal(pdu.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		t.Fatalf("failed to parse auth safe: %v", err)
+	}
+	assert.Equal(t, oidSHA256, pdu.MacData.Mac.Algorithm.Algorithm)
+	bmpPassword, _ := bmpString(password)
+	mac := hmac.New(sha256.New, pkcs12KDF(sha256.New, 3, bmpPassword, pdu.MacData.MacSalt, pdu.MacData.Iterations, sha256.Size))
+	mac.Write(authSafe)
+	assert.Equal(t, mac.Sum(nil), pdu.MacData.Mac.Digest)
+
+	var contents []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &contents); err != nil {
+		t.Fatalf("failed to parse contents: %v", err)
+	}
+	assert.Len(t, contents, 2)
+
+	// the certificates are in the encrypted data content
+	var data encryptedData
+	if _, err = asn1.Unmarshal(contents[0].Content.Bytes, &data); err != nil {
+		t.Fatalf("failed to parse encrypted data: %v", err)
+	}
+	certSafe := decryptPBES2(t, data.EncryptedContentInfo.ContentEncryptionAlgorithm, data.EncryptedContentInfo.EncryptedContent, password)
+	var certBags []safeBag
+	if _, err = asn1.Unmarshal(certSafe, &certBags); err != nil {
+		t.Fatalf("failed to parse certificate bags: %v", err)
+	}
+	assert.Len(t, certBags, len(chain))
+	for i, bag := range certBags {
+		var cert certBag
+		if _, err = asn1.Unmarshal(bag.Value.Bytes, &cert); err != nil {
+			t.Fatalf("failed to parse certificate bag: %v", err)
+		}
+		var raw []byte
+		if _, err = asn1.Unmarshal(cert.Data.Bytes, &raw); err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		assert.Equal(t, chain[i].Raw, raw)
+	}
+
+	// the key is in a shrouded key bag of the data content
+	var keySafe []byte
+	if _, err = asn1.Unmarshal(contents[1].Content.Bytes, &keySafe); err != nil {
+		t.Fatalf("failed to parse key safe: %v", err)
+	}
+	var keyBags []safeBag
+	if _, err = asn1.Unmarshal(keySafe, &keyBags); err != nil {
+		t.Fatalf("failed to parse key bags: %v", err)
+	}
+	assert.Len(t, keyBags, 1)
+	assert.Equal(t, oidPKCS8ShroudedKeyBag, keyBags[0].ID)
+	var shroudedKey encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(keyBags[0].Value.Bytes, &shroudedKey); err != nil {
+		t.Fatalf("failed to parse shrouded key: %v", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(decryptPBES2(t, shroudedKey.Algorithm, shroudedKey.EncryptedData, password))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	assert.True(t, key.Equal(parsed))
+}
+
+// decryptPBES2 decrypts data encrypted with PBES2, PBKDF2 with HMAC-SHA256 and AES-256-CBC
+func decryptPBES2(t *testing.T, algorithm pkix.AlgorithmIdentifier, data []byte, password string) []byte {
+	t.Helper()
+	assert.Equal(t, oidPBES2, algorithm.Algorithm)
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("failed to parse PBES2 parameters: %v", err)
+	}
+	assert.Equal(t, oidAES256CBC, params.EncryptionScheme.Algorithm)
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		t.Fatalf("failed to parse PBKDF2 parameters: %v", err)
+	}
+	assert.Equal(t, oidHMACWithSHA256, kdfParams.PRF.Algorithm)
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("failed to parse IV: %v", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, kdfParams.Salt, kdfParams.IterationCount, 32)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+	return decrypted[:len(decrypted)-int(decrypted[len(decrypted)-1])]
+}
+
+// decryptPBE decrypts data encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC
+func decryptPBE(t *testing.T, algorithm pkix.AlgorithmIdentifier, data []byte, password string) []byte {
+	t.Helper()
+	assert.Equal(t, oidPBEWithSHAAnd3KeyDESCBC, algorithm.Algorithm)
+	var params pbeParams
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("failed to parse PBE parameters: %v", err)
+	}
+	bmpPassword, _ := bmpString(password)
+	key := pkcs12KDF(sha1.New, 1, bmpPassword, params.Salt, params.Iterations, 24)
+	iv := pkcs12KDF(sha1.New, 2, bmpPassword, params.Salt, params.Iterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key) //nolint:gosec // 3DES is only used for the legacy PKCS#12 encryption
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+	return decrypted[:len(decrypted)-int(decrypted[len(decrypted)-1])]
+}
+
+// decodeTestPKCS12 checks the MAC of the PFX and returns the private key and the certificates in it
+func decodeTestPKCS12(t *testing.T, pfx []byte, password string) (interface{}, [][]byte) {
+	t.Helper()
+	var pdu pfxPdu
+	if _, err := asn1.Unmarshal(pfx, &pdu); err != nil {
+		t.Fatalf("failed to parse PFX: %v", err)
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pdu.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		t.Fatalf("failed to parse auth safe: %v", err)
+	}
+	macHash := sha256.New
+	if pdu.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA1) {
+		macHash = sha1.New
+	}
+	bmpPassword, _ := bmpString(password)
+	mac := hmac.New(macHash, pkcs12KDF(macHash, 3, bmpPassword, pdu.MacData.MacSalt, pdu.MacData.Iterations, macHash().Size()))
+	mac.Write(authSafe)
+	assert.Equal(t, mac.Sum(nil), pdu.MacData.Mac.Digest)
+
+	decrypt := func(algorithm pkix.AlgorithmIdentifier, data []byte) []byte {
+		if algorithm.Algorithm.Equal(oidPBES2) {
+			return decryptPBES2(t, algorithm, data, password)
+		}
+		return decryptPBE(t, algorithm, data, password)
+	}
+	var contents []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		t.Fatalf("failed to parse contents: %v", err)
+	}
+	var key interface{}
+	var certs [][]byte
+	for _, content := range contents {
+		var bagsDER []byte
+		if content.ContentType.Equal(oidEncryptedDataContentType) {
+			var data encryptedData
+			if _, err := asn1.Unmarshal(content.Content.Bytes, &data); err != nil {
+				t.Fatalf("failed to parse encrypted data: %v", err)
+			}
+			bagsDER = decrypt(data.EncryptedContentInfo.ContentEncryptionAlgorithm, data.EncryptedContentInfo.EncryptedContent)
+		} else if _, err := asn1.Unmarshal(content.Content.Bytes, &bagsDER); err != nil {
+			t.Fatalf("failed to parse data: %v", err)
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(bagsDER, &bags); err != nil {
+			t.Fatalf("failed to parse bags: %v", err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cert certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cert); err != nil {
+					t.Fatalf("failed to parse certificate bag: %v", err)
+				}
+				var raw []byte
+				if _, err := asn1.Unmarshal(cert.Data.Bytes, &raw); err != nil {
+					t.Fatalf("failed to parse certificate: %v", err)
+				}
+				certs = append(certs, raw)
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				var shroudedKey encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &shroudedKey); err != nil {
+					t.Fatalf("failed to parse shrouded key: %v", err)
+				}
+				parsed, err := x509.ParsePKCS8PrivateKey(decrypt(shroudedKey.Algorithm, shroudedKey.EncryptedData))
+				if err != nil {
+					t.Fatalf("failed to parse key: %v", err)
+				}
+				key = parsed
+			}
+		}
+	}
+	return key, certs
+}
+
+// readKeystoreFixture returns the content of the file in testdata/keystore
+func readKeystoreFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "keystore", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// TestOpenSSLKeystoreFixtures checks the key derivation, encryption and MAC of the encoder against
+// keystores written by openssl pkcs12 -export from server.pem, with the default AES-256 encryption
+// and with -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+func TestOpenSSLKeystoreFixtures(t *testing.T) {
+	key, certs, err := parseCertAndKey(string(readKeystoreFixture(t, "server.pem")))
+	if err != nil {
+		t.Fatalf("parseCertAndKey() = %v, want nil", err)
+	}
+	for _, name := range []string{"openssl.p12", "openssl-des3.p12"} {
+		t.Run(name, func(t *testing.T) {
+			parsedKey, parsedCerts := decodeTestPKCS12(t, readKeystoreFixture(t, name), "password")
+			assert.True(t, key.(*ecdsa.PrivateKey).Equal(parsedKey))
+			assert.Equal(t, [][]byte{certs[0].Raw, certs[1].Raw}, parsedCerts)
+		})
+	}
+}
+
+// TestBuildKeystoreFixtures checks the keystores of server.pem against the fixtures. The PKCS#12
+// fixtures were checked with openssl pkcs12 -info.
+func TestBuildKeystoreFixtures(t *testing.T) {
+	content := string(readKeystoreFixture(t, "server.pem"))
+	cases := []struct {
+		fixture  string
+		kvObject types.KeyVaultObject
+	}{
+		{
+			fixture:  "server.p12",
+			kvObject: types.KeyVaultObject{ObjectName: "server", ObjectFormat: types.ObjectFormatPKCS12},
+		},
+		{
+			fixture:  "server-des3.p12",
+			kvObject: types.KeyVaultObject{ObjectName: "server", ObjectFormat: types.ObjectFormatPKCS12, KeystoreEncryption: types.KeystoreEncryptionDES3},
+		},
+		{
+			fixture:  "server.jks",
+			kvObject: types.KeyVaultObject{ObjectName: "server", ObjectFormat: types.ObjectFormatJKS},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			keystore, err := buildKeystore(tc.kvObject, content, "v1", "password")
+			if err != nil {
+				t.Fatalf("buildKeystore() = %v, want nil", err)
+			}
+			// the keystore of a version is the same every time it's written
+			assert.Equal(t, readKeystoreFixture(t, tc.fixture), keystore)
+
+			// the salts change with the version and the content with the password
+			keystore, err = buildKeystore(tc.kvObject, content, "v2", "password")
+			if err != nil {
+				t.Fatalf("buildKeystore() = %v, want nil", err)
+			}
+			assert.NotEqual(t, readKeystoreFixture(t, tc.fixture), keystore)
+			keystore, err = buildKeystore(tc.kvObject, content, "v1", "password2")
+			if err != nil {
+				t.Fatalf("buildKeystore() = %v, want nil", err)
+			}
+			assert.NotEqual(t, readKeystoreFixture(t, tc.fixture), keystore)
+		})
+	}
+}
+
+func TestEncodeJKS(t *testing.T) {
+	key, chain, _ := newTestCertChain(t)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	jks, err := encodeJKS(rand.Reader, key, chain, "password", "Server", created)
+	if err != nil {
+		t.Fatalf("encodeJKS() = %v, want nil", err)
+	}
+
+	// the keystore ends with the SHA-1 digest of the password, the whitener and the keystore
+	passwordBytes := []byte{0, 'p', 0, 'a', 0, 's', 0, 's', 0, 'w', 0, 'o', 0, 'r', 0, 'd'}
+	data, digest := jks[:len(jks)-sha1.Size], jks[len(jks)-sha1.Size:]
+	h := sha1.New() //nolint:gosec // required by the JKS format
+	h.Write(passwordBytes)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(data)
+	assert.Equal(t, h.Sum(nil), digest)
+
+	r := bytes.NewReader(data)
+	readUint32 := func() uint32 {
+		var v uint32
+		if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+			t.Fatalf("failed to read keystore: %v", err)
+		}
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil {
+			t.Fatalf("failed to read keystore: %v", err)
+		}
+		return b
+	}
+	readUTF := func() string {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			t.Fatalf("failed to read keystore: %v", err)
+		}
+		return string(readBytes(int(n)))
+	}
+
+	assert.Equal(t, uint32(0xfeedfeed), readUint32())
+	assert.Equal(t, uint32(2), readUint32())
+	assert.Equal(t, uint32(1), readUint32())
+	assert.Equal(t, uint32(1), readUint32())
+	assert.Equal(t, "server", readUTF())
+	var timestamp int64
+	if err := binary.Read(r, binary.BigEndian, &timestamp); err != nil {
+		t.Fatalf("failed to read keystore: %v", err)
+	}
+	assert.Equal(t, created.UnixMilli(), timestamp)
+
+	var protectedKey encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(readBytes(int(readUint32())), &protectedKey); err != nil {
+		t.Fatalf("failed to parse protected key: %v", err)
+	}
+	assert.Equal(t, oidJKSKeyProtector, protectedKey.Algorithm.Algorithm)
+	// the key is XORed with chained SHA-1 digests of the password and the salt
+	protected := protectedKey.EncryptedData
+	salt, encrypted, check := protected[:sha1.Size], protected[sha1.Size:len(protected)-sha1.Size], protected[len(protected)-sha1.Size:]
+	keyDER := make([]byte, len(encrypted))
+	stream := salt
+	for i := range encrypted {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(bytes.Clone(passwordBytes), stream...)) //nolint:gosec // required by the JKS format
+			stream = sum[:]
+		}
+		keyDER[i] = encrypted[i] ^ stream[i%sha1.Size]
+	}
+	sum := sha1.Sum(append(bytes.Clone(passwordBytes), keyDER...)) //nolint:gosec // required by the JKS format
+	assert.Equal(t, sum[:], check)
+	parsed, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	assert.True(t, key.Equal(parsed))
+
+	assert.Equal(t, uint32(len(chain)), readUint32())
+	for _, cert := range chain {
+		assert.Equal(t, "X.509", readUTF())
+		assert.Equal(t, cert.Raw, readBytes(int(readUint32())))
+	}
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestGetSecretKeystore(t *testing.T) {
+	key, chain, content := newTestCertChain(t)
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/cert1/v1")
+	certSecret := &azsecrets.SecretBundle{
+		ID:          &id,
+		Value:       to.StringPtr(content),
+		Kid:         to.StringPtr("https://test.vault.azure.net/keys/cert1/v1"),
+		ContentType: to.StringPtr(types.CertTypePem),
+	}
+	kvObject := types.KeyVaultObject{
+		ObjectName:           "cert1",
+		ObjectType:           types.VaultObjectTypeSecret,
+		ObjectFormat:         types.ObjectFormatPKCS12,
+		ObjectPasswordSecret: "password1",
+		KeystoreEncryption:   types.KeystoreEncryptionDES3,
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "cert1", "").Return(certSecret, nil)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "password1", "").Return(&azsecrets.SecretBundle{Value: to.StringPtr("password")}, nil)
+
+	objs, err := p.getSecret(testContext(t), kvClient, kvObject)
+	if err != nil {
+		t.Fatalf("getSecret() = %v, want nil", err)
+	}
+	assert.Len(t, objs, 1)
+	assert.Equal(t, "v1", objs[0].version)
+	blocks, err := pkcs12.ToPEM([]byte(objs[0].content), "password")
+	if err != nil {
+		t.Fatalf("ToPEM() = %v, want nil", err)
+	}
+	// the key is followed by the chain from the leaf to the root
+	assert.Len(t, blocks, len(chain)+1)
+	parsed, err := parsePrivateKey(blocks[len(blocks)-1].Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	assert.True(t, key.Equal(parsed))
+	assert.Equal(t, chain[0].Raw, blocks[0].Bytes)
+
+	// the password secret must exist
+	kvClient.EXPECT().GetSecret(gomock.Any(), "cert1", "").Return(certSecret, nil)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "password1", "").Return(nil, errors.New("secret not found"))
+	_, err = p.getSecret(testContext(t), kvClient, kvObject)
+	if err == nil || !strings.Contains(err.Error(), "failed to get objectPasswordSecret password1: secret not found") {
+		t.Fatalf("getSecret() = %v, want password secret error", err)
+	}
+}
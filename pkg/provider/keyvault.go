@@ -2,13 +2,27 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/Azure/go-autorest/autorest/date"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
 
@@ -19,6 +33,10 @@ type KeyVault interface {
 	GetKeyVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error)
 	GetCertificate(ctx context.Context, name, version string) (*azcertificates.CertificateBundle, error)
 	GetCertificateVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error)
+	ListSecrets(ctx context.Context) ([]types.KeyVaultObjectProperties, error)
+	ListKeys(ctx context.Context) ([]types.KeyVaultObjectProperties, error)
+	ListCertificates(ctx context.Context) ([]types.KeyVaultObjectProperties, error)
+	ReleaseKey(ctx context.Context, name, version, targetAttestationToken string, algorithm azkeys.KeyEncryptionAlgorithm) (string, error)
 }
 
 // TODO(aramase): add user agent
@@ -28,17 +46,76 @@ type client struct {
 	certs   *azcertificates.Client
 }
 
+// ClientOptions configures the connection to the vault
+type ClientOptions struct {
+	// CABundle is a PEM bundle of CA certificates trusted for the vault in addition to the system roots
+	CABundle []byte
+	// ServerName overrides the name used to verify the vault certificate and sent as SNI
+	ServerName string
+	// DisableChallengeResourceVerification allows the vault to be reached with a host
+	// outside of the Key Vault and Managed HSM domains
+	DisableChallengeResourceVerification bool
+}
+
+// cacheKey returns a key that identifies the options in the client cache
+func (o *ClientOptions) cacheKey() string {
+	if o == nil {
+		return ""
+	}
+	sum := sha256.Sum256(o.CABundle)
+	return strings.Join([]string{hex.EncodeToString(sum[:]), o.ServerName, strconv.FormatBool(o.DisableChallengeResourceVerification)}, "|")
+}
+
+// clientOptions returns the azure sdk client options for the options
+func (o *ClientOptions) clientOptions() (azcore.ClientOptions, error) {
+	if o == nil || (len(o.CABundle) == 0 && o.ServerName == "") {
+		return azcore.ClientOptions{}, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: o.ServerName,
+	}
+	if len(o.CABundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(o.CABundle) {
+			return azcore.ClientOptions{}, fmt.Errorf("failed to parse CA bundle, no PEM certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return azcore.ClientOptions{Transport: &http.Client{Transport: transport}}, nil
+}
+
 // NewClient creates a new KeyVault client
-func NewClient(cred azcore.TokenCredential, vaultURI string) (KeyVault, error) {
-	secrets, err := azsecrets.NewClient(vaultURI, cred, nil)
+func NewClient(cred azcore.TokenCredential, vaultURI string, opts *ClientOptions) (KeyVault, error) {
+	clientOptions, err := opts.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	disableChallengeResourceVerification := opts != nil && opts.DisableChallengeResourceVerification
+
+	secrets, err := azsecrets.NewClient(vaultURI, cred, &azsecrets.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: disableChallengeResourceVerification,
+	})
 	if err != nil {
 		return nil, err
 	}
-	keys, err := azkeys.NewClient(vaultURI, cred, nil)
+	keys, err := azkeys.NewClient(vaultURI, cred, &azkeys.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: disableChallengeResourceVerification,
+	})
 	if err != nil {
 		return nil, err
 	}
-	certs, err := azcertificates.NewClient(vaultURI, cred, nil)
+	certs, err := azcertificates.NewClient(vaultURI, cred, &azcertificates.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: disableChallengeResourceVerification,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -100,6 +177,7 @@ func (c *client) GetSecretVersions(ctx context.Context, name string) ([]types.Ke
 			versions = append(versions, types.KeyVaultObjectVersion{
 				Version: id.Version(),
 				Created: created,
+				Tags:    copyTags(secret.Tags),
 			})
 		}
 	}
@@ -133,6 +211,7 @@ func (c *client) GetKeyVersions(ctx context.Context, name string) ([]types.KeyVa
 			versions = append(versions, types.KeyVaultObjectVersion{
 				Version: id.Version(),
 				Created: created,
+				Tags:    copyTags(key.Tags),
 			})
 		}
 	}
@@ -166,9 +245,358 @@ func (c *client) GetCertificateVersions(ctx context.Context, name string) ([]typ
 			versions = append(versions, types.KeyVaultObjectVersion{
 				Version: id.Version(),
 				Created: created,
+				Tags:    copyTags(cert.Tags),
 			})
 		}
 	}
 
 	return versions, nil
 }
+
+func (c *client) ListSecrets(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	pager := c.secrets.NewListSecretsPager(&azsecrets.ListSecretsOptions{})
+	var objects []types.KeyVaultObjectProperties
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range page.SecretListResult.Value {
+			if secret.ID == nil {
+				continue
+			}
+			objects = append(objects, types.KeyVaultObjectProperties{
+				Name:    secret.ID.Name(),
+				Enabled: secret.Attributes == nil || secret.Attributes.Enabled == nil || *secret.Attributes.Enabled,
+				Tags:    copyTags(secret.Tags),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+func (c *client) ListKeys(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	pager := c.keys.NewListKeysPager(&azkeys.ListKeysOptions{})
+	var objects []types.KeyVaultObjectProperties
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page.KeyListResult.Value {
+			if key.KID == nil {
+				continue
+			}
+			objects = append(objects, types.KeyVaultObjectProperties{
+				Name:    key.KID.Name(),
+				Enabled: key.Attributes == nil || key.Attributes.Enabled == nil || *key.Attributes.Enabled,
+				Tags:    copyTags(key.Tags),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+func (c *client) ListCertificates(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	pager := c.certs.NewListCertificatesPager(&azcertificates.ListCertificatesOptions{})
+	var objects []types.KeyVaultObjectProperties
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range page.CertificateListResult.Value {
+			if cert.ID == nil {
+				continue
+			}
+			objects = append(objects, types.KeyVaultObjectProperties{
+				Name:    cert.ID.Name(),
+				Enabled: cert.Attributes == nil || cert.Attributes.Enabled == nil || *cert.Attributes.Enabled,
+				Tags:    copyTags(cert.Tags),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// ReleaseKey releases an exportable key to the target in the attestation token and returns
+// the signed object with the key material wrapped with the key in the attestation token
+func (c *client) ReleaseKey(ctx context.Context, name, version, targetAttestationToken string, algorithm azkeys.KeyEncryptionAlgorithm) (string, error) {
+	resp, err := c.keys.Release(ctx, name, version, azkeys.ReleaseParameters{
+		TargetAttestationToken: &targetAttestationToken,
+		Enc:                    &algorithm,
+	}, &azkeys.ReleaseOptions{})
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("released key is nil")
+	}
+	return *resp.Value, nil
+}
+
+// copyTags returns the tags of an object without the nil values
+func copyTags(tags map[string]*string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			result[k] = *v
+		}
+	}
+	return result
+}
+
+// scopedTokenCredential only issues tokens for the allowed scopes. It's used when the
+// challenge resource isn't verified so a vault can't request a token for another resource.
+type scopedTokenCredential struct {
+	cred   azcore.TokenCredential
+	scopes []string
+}
+
+func newScopedTokenCredential(cred azcore.TokenCredential, scopes ...string) *scopedTokenCredential {
+	return &scopedTokenCredential{cred: cred, scopes: scopes}
+}
+
+// GetToken returns a token from the underlying credential if all the requested scopes are allowed
+func (s *scopedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	for _, scope := range opts.Scopes {
+		if !slices.Contains(s.scopes, scope) {
+			return azcore.AccessToken{}, fmt.Errorf("vault requested a token for scope %q, only %s are allowed", scope, strings.Join(s.scopes, ", "))
+		}
+	}
+	return s.cred.GetToken(ctx, opts)
+}
+
+// dedupClient merges concurrent identical reads into a single Key Vault request.
+// The identity the client was created for is part of the request key, so a request
+// is only ever served by a response obtained with the same credentials.
+type dedupClient struct {
+	kv       KeyVault
+	identity string
+	group    *flightGroup
+	reporter metrics.StatsReporter
+}
+
+// newDedupClient wraps the KeyVault client to merge identical in-flight reads.
+// identity must uniquely identify the credentials and vault used by kv.
+func newDedupClient(kv KeyVault, identity string, group *flightGroup, reporter metrics.StatsReporter) KeyVault {
+	return &dedupClient{
+		kv:       kv,
+		identity: identity,
+		group:    group,
+		reporter: reporter,
+	}
+}
+
+// do runs fn once for all concurrent callers with the same key. If the caller that
+// made the request was cancelled, the remaining callers retry with their own context.
+// shared is true if the result came from the request of another caller.
+func (d *dedupClient) do(ctx context.Context, objectType, op, name, version string, fn func(context.Context) (interface{}, error)) (interface{}, bool, error) {
+	key := strings.Join([]string{d.identity, objectType, op, name, version}, "|")
+	v, shared, err := d.group.do(ctx, key, func() (interface{}, error) {
+		return fn(ctx)
+	})
+	if !shared {
+		return v, false, err
+	}
+	if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil {
+		v, err = fn(ctx)
+		return v, false, err
+	}
+	d.reporter.ReportDeduplicatedRequest(ctx, objectType)
+	return v, true, err
+}
+
+func (d *dedupClient) GetSecret(ctx context.Context, name, version string) (*azsecrets.SecretBundle, error) {
+	v, shared, err := d.do(ctx, types.VaultObjectTypeSecret, "get", name, version, func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetSecret(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		return copyBundle(v.(*azsecrets.SecretBundle))
+	}
+	return v.(*azsecrets.SecretBundle), nil
+}
+
+func (d *dedupClient) GetKey(ctx context.Context, name, version string) (*azkeys.KeyBundle, error) {
+	v, shared, err := d.do(ctx, types.VaultObjectTypeKey, "get", name, version, func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetKey(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		return copyBundle(v.(*azkeys.KeyBundle))
+	}
+	return v.(*azkeys.KeyBundle), nil
+}
+
+func (d *dedupClient) GetCertificate(ctx context.Context, name, version string) (*azcertificates.CertificateBundle, error) {
+	v, shared, err := d.do(ctx, types.VaultObjectTypeCertificate, "get", name, version, func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetCertificate(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		return copyBundle(v.(*azcertificates.CertificateBundle))
+	}
+	return v.(*azcertificates.CertificateBundle), nil
+}
+
+func (d *dedupClient) GetSecretVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeSecret, "versions", name, "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetSecretVersions(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyVersions(v.([]types.KeyVaultObjectVersion)), nil
+}
+
+func (d *dedupClient) GetKeyVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeKey, "versions", name, "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetKeyVersions(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyVersions(v.([]types.KeyVaultObjectVersion)), nil
+}
+
+func (d *dedupClient) GetCertificateVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeCertificate, "versions", name, "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.GetCertificateVersions(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyVersions(v.([]types.KeyVaultObjectVersion)), nil
+}
+
+func (d *dedupClient) ListSecrets(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeSecret, "list", "", "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.ListSecrets(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyProperties(v.([]types.KeyVaultObjectProperties)), nil
+}
+
+func (d *dedupClient) ListKeys(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeKey, "list", "", "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.ListKeys(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyProperties(v.([]types.KeyVaultObjectProperties)), nil
+}
+
+func (d *dedupClient) ListCertificates(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	v, _, err := d.do(ctx, types.VaultObjectTypeCertificate, "list", "", "", func(ctx context.Context) (interface{}, error) {
+		return d.kv.ListCertificates(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyProperties(v.([]types.KeyVaultObjectProperties)), nil
+}
+
+// ReleaseKey is not deduplicated as the release is bound to the attestation token of the caller
+func (d *dedupClient) ReleaseKey(ctx context.Context, name, version, targetAttestationToken string, algorithm azkeys.KeyEncryptionAlgorithm) (string, error) {
+	return d.kv.ReleaseKey(ctx, name, version, targetAttestationToken, algorithm)
+}
+
+// copyBundle returns a deep copy of the bundle so callers sharing a result can't modify each other's bundle
+func copyBundle[T any](bundle *T) (*T, error) {
+	if bundle == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy the shared result, error: %w", err)
+	}
+	c := new(T)
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to copy the shared result, error: %w", err)
+	}
+	return c, nil
+}
+
+// copyProperties returns a copy of the object list so callers sharing a result can't modify each other's list
+func copyProperties(objects []types.KeyVaultObjectProperties) []types.KeyVaultObjectProperties {
+	if objects == nil {
+		return nil
+	}
+	return append([]types.KeyVaultObjectProperties{}, objects...)
+}
+
+// copyVersions returns a copy of the versions as the callers sort the list in place
+func copyVersions(versions []types.KeyVaultObjectVersion) []types.KeyVaultObjectVersion {
+	if versions == nil {
+		return nil
+	}
+	return append([]types.KeyVaultObjectVersion{}, versions...)
+}
+
+// flightGroup tracks the in-flight calls by key
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do executes fn if there is no in-flight call for the key, otherwise it waits for the
+// in-flight call to complete or ctx to be done and returns its result. shared is true if
+// the result came from a call made by another caller. A panic in fn is returned as an error
+// to all the callers.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, true, c.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, fmt.Errorf("panic in the key vault request: %v", r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+		v, shared, err = c.val, false, c.err
+	}()
+	c.val, c.err = fn()
+	return c.val, false, c.err
+}
@@ -0,0 +1,299 @@
+package provider
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+)
+
+func TestDedupClientMergesConcurrentReads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	release := make(chan struct{})
+	kv := mock_keyvault.NewMockKeyVault(ctrl)
+	kv.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			<-release
+			return &azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("value")}, nil
+		},
+	).Times(1)
+
+	group := newFlightGroup()
+	client := newDedupClient(kv, "identity1", group, metrics.NewStatsReporter())
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			secret, err := client.GetSecret(context.Background(), "secret1", "")
+			if err == nil && *secret.Value != "value" {
+				err = errors.New("unexpected secret value")
+			}
+			errs <- err
+		}()
+	}
+	// wait for all the callers to join the in-flight request
+	waitForCallers(t, group, "identity1|secret|get|secret1|")
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetSecret() = %v, want nil", err)
+		}
+	}
+}
+
+func TestDedupClientDoesNotMergeAcrossIdentities(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	release := make(chan struct{})
+	kv1 := mock_keyvault.NewMockKeyVault(ctrl)
+	kv1.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			<-release
+			return &azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("value")}, nil
+		},
+	)
+	kv2 := mock_keyvault.NewMockKeyVault(ctrl)
+	kv2.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(nil, errors.New("forbidden"))
+
+	group := newFlightGroup()
+	reporter := metrics.NewStatsReporter()
+	client1 := newDedupClient(kv1, "identity1", group, reporter)
+	client2 := newDedupClient(kv2, "identity2", group, reporter)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := client1.GetSecret(context.Background(), "secret1", ""); err != nil {
+			t.Errorf("GetSecret() = %v, want nil", err)
+		}
+	}()
+	waitForCallers(t, group, "identity1|secret|get|secret1|")
+
+	if _, err := client2.GetSecret(context.Background(), "secret1", ""); err == nil {
+		t.Errorf("GetSecret() = nil, want error for identity2")
+	}
+	close(release)
+	<-done
+}
+
+func TestDedupClientRetriesCancelledRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	started := make(chan struct{})
+	kv := mock_keyvault.NewMockKeyVault(ctrl)
+	gomock.InOrder(
+		kv.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+			func(ctx context.Context, _, _ string) (*azsecrets.SecretBundle, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		),
+		kv.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("value")}, nil),
+	)
+
+	group := newFlightGroup()
+	client := newDedupClient(kv, "identity1", group, metrics.NewStatsReporter())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = client.GetSecret(ctx, "secret1", "")
+	}()
+	<-started
+
+	result := make(chan error)
+	go func() {
+		_, err := client.GetSecret(context.Background(), "secret1", "")
+		result <- err
+	}()
+	// let the second caller join the in-flight request before cancelling the first
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	if err := <-result; err != nil {
+		t.Errorf("GetSecret() = %v, want nil", err)
+	}
+}
+
+func TestDedupClientCopiesSharedResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	release := make(chan struct{})
+	kv := mock_keyvault.NewMockKeyVault(ctrl)
+	kv.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			<-release
+			return &azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("value"), Tags: map[string]*string{"env": to.StringPtr("prod")}}, nil
+		},
+	).Times(1)
+
+	group := newFlightGroup()
+	client := newDedupClient(kv, "identity1", group, metrics.NewStatsReporter())
+
+	results := make(chan *azsecrets.SecretBundle, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			secret, err := client.GetSecret(context.Background(), "secret1", "")
+			if err != nil {
+				t.Errorf("GetSecret() = %v, want nil", err)
+			}
+			results <- secret
+		}()
+	}
+	waitForCallers(t, group, "identity1|secret|get|secret1|")
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	first, second := <-results, <-results
+	if first == nil || second == nil {
+		t.Fatalf("GetSecret() returned a nil secret")
+	}
+	*first.Value = "modified"
+	first.Tags["env"] = to.StringPtr("modified")
+	if *second.Value != "value" || *second.Tags["env"] != "prod" {
+		t.Errorf("expected the callers sharing a result to not share the secret bundle, got %q, %q", *second.Value, *second.Tags["env"])
+	}
+}
+
+func TestFlightGroupRecoversPanic(t *testing.T) {
+	group := newFlightGroup()
+	_, _, err := group.do(context.Background(), "key", func() (interface{}, error) {
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("do() = %v, want panic error", err)
+	}
+	if _, ok := group.calls["key"]; ok {
+		t.Errorf("expected the call to be removed after the panic")
+	}
+}
+
+func TestFlightGroupWaiterContextDone(t *testing.T) {
+	group := newFlightGroup()
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = group.do(context.Background(), "key", func() (interface{}, error) {
+			<-release
+			return "value", nil
+		})
+	}()
+	waitForCallers(t, group, "key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := group.do(ctx, "key", func() (interface{}, error) { return "other", nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("do() = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	<-done
+}
+
+// waitForCallers waits until there is an in-flight call for the key
+func waitForCallers(t *testing.T, group *flightGroup, key string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		group.mu.Lock()
+		_, ok := group.calls[key]
+		group.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for in-flight call %s", key)
+}
+
+func TestNewClientWithCustomEndpoint(t *testing.T) {
+	var tokens []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || r.TLS.ServerName != "example.com" {
+			t.Errorf("expected SNI example.com, got %+v", r.TLS)
+		}
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tid", resource="https://vault.azure.net"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tokens = append(tokens, auth)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"https://%s/secrets/secret1/v1","value":"value"}`, r.Host)
+	}))
+	defer server.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cred := newScopedTokenCredential(&fakeCredential{expires: time.Now().Add(time.Hour)}, "https://vault.azure.net/.default")
+	kv, err := NewClient(cred, server.URL+"/", &ClientOptions{
+		CABundle:                             caBundle,
+		ServerName:                           "example.com",
+		DisableChallengeResourceVerification: true,
+	})
+	if err != nil {
+		t.Fatalf("NewClient() = %v, want nil", err)
+	}
+	secret, err := kv.GetSecret(testContext(t), "secret1", "")
+	if err != nil {
+		t.Fatalf("GetSecret() = %v, want nil", err)
+	}
+	if *secret.Value != "value" {
+		t.Errorf("GetSecret() = %s, want value", *secret.Value)
+	}
+	if len(tokens) != 1 || tokens[0] != "Bearer token" {
+		t.Errorf("expected request authorized with the token, got %v", tokens)
+	}
+
+}
+
+func TestNewClientInvalidCABundle(t *testing.T) {
+	_, err := NewClient(&fakeCredential{}, "https://test.vault.azure.net/", &ClientOptions{CABundle: []byte("invalid")})
+	if err == nil || !strings.Contains(err.Error(), "failed to parse CA bundle") {
+		t.Fatalf("NewClient() = %v, want CA bundle error", err)
+	}
+}
+
+func TestScopedTokenCredential(t *testing.T) {
+	cred := newScopedTokenCredential(&fakeCredential{expires: time.Now().Add(time.Hour)}, "https://vault.azure.net/.default")
+
+	if _, err := cred.GetToken(testContext(t), policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}}); err != nil {
+		t.Errorf("GetToken() = %v, want nil", err)
+	}
+	if _, err := cred.GetToken(testContext(t), policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}}); err == nil {
+		t.Errorf("GetToken() = nil, want error for scope that isn't allowed")
+	}
+}
@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// metadataFileNameSuffix is appended to the file name of the object for its metadata file
+const metadataFileNameSuffix = ".meta.json"
+
+// objectMetadata is the content of the metadata file written for an object
+type objectMetadata struct {
+	Version     string            `json:"version"`
+	ContentType string            `json:"contentType,omitempty"`
+	KeyType     string            `json:"keyType,omitempty"`
+	Created     *time.Time        `json:"created,omitempty"`
+	Updated     *time.Time        `json:"updated,omitempty"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	NotBefore   *time.Time        `json:"notBefore,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+func newSecretMetadata(secret *azsecrets.SecretBundle, version string) *objectMetadata {
+	metadata := &objectMetadata{Version: version, Tags: copyTags(secret.Tags)}
+	if secret.ContentType != nil {
+		metadata.ContentType = *secret.ContentType
+	}
+	if attributes := secret.Attributes; attributes != nil {
+		metadata.Created = attributes.Created
+		metadata.Updated = attributes.Updated
+		metadata.Expires = attributes.Expires
+		metadata.NotBefore = attributes.NotBefore
+	}
+	return metadata
+}
+
+func newKeyMetadata(key *azkeys.KeyBundle, version string) *objectMetadata {
+	metadata := &objectMetadata{Version: version, Tags: copyTags(key.Tags)}
+	if key.Key != nil && key.Key.Kty != nil {
+		metadata.KeyType = string(*key.Key.Kty)
+	}
+	if attributes := key.Attributes; attributes != nil {
+		metadata.Created = attributes.Created
+		metadata.Updated = attributes.Updated
+		metadata.Expires = attributes.Expires
+		metadata.NotBefore = attributes.NotBefore
+	}
+	return metadata
+}
+
+func newCertificateMetadata(cert *azcertificates.CertificateBundle, version string) *objectMetadata {
+	metadata := &objectMetadata{Version: version, Tags: copyTags(cert.Tags)}
+	if cert.ContentType != nil {
+		metadata.ContentType = *cert.ContentType
+	}
+	if attributes := cert.Attributes; attributes != nil {
+		metadata.Created = attributes.Created
+		metadata.Updated = attributes.Updated
+		metadata.Expires = attributes.Expires
+		metadata.NotBefore = attributes.NotBefore
+	}
+	return metadata
+}
+
+// marshal returns the content of the metadata file
+func (m *objectMetadata) marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
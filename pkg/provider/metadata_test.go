@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := created.Add(24 * time.Hour)
+
+	secret := newSecretMetadata(&azsecrets.SecretBundle{
+		ContentType: to.StringPtr("text/plain"),
+		Attributes:  &azsecrets.SecretAttributes{Created: &created, Updated: &created, Expires: &expires},
+		Tags:        map[string]*string{"env": to.StringPtr("prod")},
+	}, "v1")
+	assert.Equal(t, &objectMetadata{
+		Version:     "v1",
+		ContentType: "text/plain",
+		Created:     &created,
+		Updated:     &created,
+		Expires:     &expires,
+		Tags:        map[string]string{"env": "prod"},
+	}, secret)
+
+	kty := azkeys.JSONWebKeyTypeRSA
+	key := newKeyMetadata(&azkeys.KeyBundle{
+		Key:        &azkeys.JSONWebKey{Kty: &kty},
+		Attributes: &azkeys.KeyAttributes{Created: &created, NotBefore: &created},
+	}, "v2")
+	assert.Equal(t, &objectMetadata{Version: "v2", KeyType: "RSA", Created: &created, NotBefore: &created}, key)
+
+	cert := newCertificateMetadata(&azcertificates.CertificateBundle{
+		ContentType: to.StringPtr(types.CertTypePem),
+		Attributes:  &azcertificates.CertificateAttributes{Expires: &expires},
+	}, "v3")
+	assert.Equal(t, &objectMetadata{Version: "v3", ContentType: types.CertTypePem, Expires: &expires}, cert)
+}
+
+func TestGetObjectFilesWithMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			return &azsecrets.SecretBundle{
+				ID:         &id,
+				Value:      to.StringPtr("value"),
+				Attributes: &azsecrets.SecretAttributes{Created: &created},
+				Tags:       map[string]*string{"env": to.StringPtr("prod")},
+			}, nil
+		},
+	)
+
+	kvObject := types.KeyVaultObject{ObjectName: "secret1", ObjectAlias: "alias", ObjectType: types.VaultObjectTypeSecret, WriteMetadata: true}
+	files, err := p.getObjectFiles(testContext(t), kvClient, kvObject, 0644, klog.ObjectRef{})
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+	expectedMetadata := `{
+  "version": "v1",
+  "created": "2024-01-01T00:00:00Z",
+  "tags": {
+    "env": "prod"
+  }
+}`
+	assert.Equal(t, []types.SecretFile{
+		{Path: "alias", Content: []byte("value"), FileMode: 0644, UID: "secret/secret1", Version: "v1"},
+		{Path: "alias.meta.json", Content: []byte(expectedMetadata), FileMode: 0644, UID: "secret/secret1", Version: "v1"},
+	}, files)
+}
+
+func TestGetObjectFilesWithMetadataOnly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kty := azkeys.JSONWebKeyTypeOctHSM
+	kid := azkeys.ID("https://testhsm.managedhsm.azure.net/keys/key1/v1")
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{Key: &azkeys.JSONWebKey{KID: &kid, Kty: &kty}}, nil)
+
+	// the key material of a symmetric key isn't returned so only the metadata file is written
+	kvObject := types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey, WriteMetadata: true}
+	files, err := p.getObjectFiles(testContext(t), kvClient, kvObject, 0644, klog.ObjectRef{})
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+	expectedMetadata := `{
+  "version": "v1",
+  "keyType": "oct-HSM"
+}`
+	assert.Equal(t, []types.SecretFile{
+		{Path: "key1.meta.json", Content: []byte(expectedMetadata), FileMode: 0644, UID: "key/key1", Version: "v1"},
+	}, files)
+}
@@ -127,3 +127,63 @@ func (mr *MockKeyVaultMockRecorder) GetSecretVersions(ctx, name interface{}) *go
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSecretVersions", reflect.TypeOf((*MockKeyVault)(nil).GetSecretVersions), ctx, name)
 }
+
+// ListCertificates mocks base method.
+func (m *MockKeyVault) ListCertificates(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCertificates", ctx)
+	ret0, _ := ret[0].([]types.KeyVaultObjectProperties)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCertificates indicates an expected call of ListCertificates.
+func (mr *MockKeyVaultMockRecorder) ListCertificates(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCertificates", reflect.TypeOf((*MockKeyVault)(nil).ListCertificates), ctx)
+}
+
+// ListKeys mocks base method.
+func (m *MockKeyVault) ListKeys(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListKeys", ctx)
+	ret0, _ := ret[0].([]types.KeyVaultObjectProperties)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListKeys indicates an expected call of ListKeys.
+func (mr *MockKeyVaultMockRecorder) ListKeys(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListKeys", reflect.TypeOf((*MockKeyVault)(nil).ListKeys), ctx)
+}
+
+// ListSecrets mocks base method.
+func (m *MockKeyVault) ListSecrets(ctx context.Context) ([]types.KeyVaultObjectProperties, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSecrets", ctx)
+	ret0, _ := ret[0].([]types.KeyVaultObjectProperties)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSecrets indicates an expected call of ListSecrets.
+func (mr *MockKeyVaultMockRecorder) ListSecrets(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSecrets", reflect.TypeOf((*MockKeyVault)(nil).ListSecrets), ctx)
+}
+
+// ReleaseKey mocks base method.
+func (m *MockKeyVault) ReleaseKey(ctx context.Context, name, version, targetAttestationToken string, algorithm azkeys.KeyEncryptionAlgorithm) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseKey", ctx, name, version, targetAttestationToken, algorithm)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseKey indicates an expected call of ReleaseKey.
+func (mr *MockKeyVaultMockRecorder) ReleaseKey(ctx, name, version, targetAttestationToken, algorithm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseKey", reflect.TypeOf((*MockKeyVault)(nil).ReleaseKey), ctx, name, version, targetAttestationToken, algorithm)
+}
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// formatObjectPrivateKeys writes the private keys in the PEM content of the version in the privateKeyFormat of the object
+func formatObjectPrivateKeys(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject, content, version string) (string, error) {
+	var password string
+	if strings.EqualFold(kvObject.PrivateKeyFormat, types.PrivateKeyFormatEncryptedPKCS8) {
+		var err error
+		if password, err = getObjectPassword(ctx, kvClient, kvObject); err != nil {
+			return "", err
+		}
+	}
+	return formatPrivateKeys(content, kvObject.PrivateKeyFormat, version, password)
+}
+
+// formatPrivateKeys re-encodes the private keys in the PEM content of the version in the private key format,
+// the other blocks are written as is
+func formatPrivateKeys(content, privateKeyFormat, version, password string) (string, error) {
+	var out []byte
+	found := false
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			out = append(out, pem.EncodeToMemory(block)...)
+			continue
+		}
+		key, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		if block, err = encodePrivateKey(key, privateKeyFormat, version, password); err != nil {
+			return "", err
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+		found = true
+	}
+	if !found {
+		return "", fmt.Errorf("private key not found, privateKeyFormat requires a certificate with an exportable key or a secret with a PEM private key")
+	}
+	return string(out), nil
+}
+
+// encodePrivateKey returns the PEM block of the private key in the private key format. The salt and IV of
+// an encrypted key are derived from the version and the public key, so the key of a version only changes
+// when the password changes.
+func encodePrivateKey(key interface{}, privateKeyFormat, version, password string) (*pem.Block, error) {
+	switch strings.ToLower(privateKeyFormat) {
+	case types.PrivateKeyFormatPKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("privateKeyFormat pkcs1 only supported for RSA keys, got key type %T", key)
+		}
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	case types.PrivateKeyFormatSEC1:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("privateKeyFormat sec1 only supported for EC keys, got key type %T", key)
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	case types.PrivateKeyFormatEncryptedPKCS8:
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, encrypted, err := encryptPBES2(newSaltEntropy(version, [][]byte{publicKey}), der, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		if der, err = asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted}); err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+}
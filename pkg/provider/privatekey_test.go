@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestFormatPrivateKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	certBlock := &pem.Block{Type: types.CertificateType, Bytes: []byte("cert")}
+	pkcs8PEM := func(key interface{}) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	cases := []struct {
+		desc             string
+		content          string
+		privateKeyFormat string
+		expected         string
+		expectedErr      string
+	}{
+		{
+			desc:             "pkcs1",
+			content:          pkcs8PEM(rsaKey) + string(pem.EncodeToMemory(certBlock)),
+			privateKeyFormat: "PKCS1",
+			expected:         string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})) + string(pem.EncodeToMemory(certBlock)),
+		},
+		{
+			desc:             "sec1",
+			content:          string(pem.EncodeToMemory(certBlock)) + pkcs8PEM(ecKey),
+			privateKeyFormat: "sec1",
+			expected:         string(pem.EncodeToMemory(certBlock)) + string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})),
+		},
+		{
+			desc:             "pkcs8",
+			content:          string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})),
+			privateKeyFormat: "pkcs8",
+			expected:         pkcs8PEM(ecKey),
+		},
+		{
+			desc:             "pkcs1 with EC key",
+			content:          pkcs8PEM(ecKey),
+			privateKeyFormat: "pkcs1",
+			expectedErr:      "privateKeyFormat pkcs1 only supported for RSA keys, got key type *ecdsa.PrivateKey",
+		},
+		{
+			desc:             "sec1 with RSA key",
+			content:          pkcs8PEM(rsaKey),
+			privateKeyFormat: "sec1",
+			expectedErr:      "privateKeyFormat sec1 only supported for EC keys, got key type *rsa.PrivateKey",
+		},
+		{
+			desc:             "no private key",
+			content:          string(pem.EncodeToMemory(certBlock)),
+			privateKeyFormat: "pkcs1",
+			expectedErr:      "private key not found, privateKeyFormat requires a certificate with an exportable key or a secret with a PEM private key",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actual, err := formatPrivateKeys(tc.content, tc.privateKeyFormat, "v1", "")
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("formatPrivateKeys() = %v, want %s", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("formatPrivateKeys() = %v, want nil", err)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestGetSecretEncryptedPrivateKey(t *testing.T) {
+	key, _, content := newTestCertChain(t)
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/cert1/v1")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "cert1", "").Return(&azsecrets.SecretBundle{
+		ID:          &id,
+		Value:       to.StringPtr(content),
+		Kid:         to.StringPtr("https://test.vault.azure.net/keys/cert1/v1"),
+		ContentType: to.StringPtr(types.CertTypePem),
+	}, nil)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "password1", "").Return(&azsecrets.SecretBundle{Value: to.StringPtr("password")}, nil)
+
+	objs, err := p.getSecret(testContext(t), kvClient, types.KeyVaultObject{
+		ObjectName:           "cert1",
+		ObjectType:           types.VaultObjectTypeSecret,
+		PrivateKeyFormat:     types.PrivateKeyFormatEncryptedPKCS8,
+		ObjectPasswordSecret: "password1",
+	})
+	if err != nil {
+		t.Fatalf("getSecret() = %v, want nil", err)
+	}
+	assert.Len(t, objs, 1)
+	block, rest := pem.Decode([]byte(objs[0].content))
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("getSecret() content = %q, want ENCRYPTED PRIVATE KEY first", objs[0].content)
+	}
+	assert.Equal(t, 3, strings.Count(string(rest), "-----BEGIN CERTIFICATE-----"))
+
+	var encrypted encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(block.Bytes, &encrypted); err != nil {
+		t.Fatalf("failed to parse encrypted key: %v", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(decryptPBES2(t, encrypted.Algorithm, encrypted.EncryptedData, "password"))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	assert.True(t, key.Equal(parsed))
+}
+
+func TestGetSecretPrivateKeyFormatWithoutCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/key1/v1")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvObject := types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeSecret, PrivateKeyFormat: types.PrivateKeyFormatPKCS1}
+
+	// the private key of a secret that isn't part of a certificate is formatted too
+	kvClient.EXPECT().GetSecret(gomock.Any(), "key1", "").Return(&azsecrets.SecretBundle{
+		ID:    &id,
+		Value: to.StringPtr(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))),
+	}, nil)
+	objs, err := p.getSecret(testContext(t), kvClient, kvObject)
+	if err != nil {
+		t.Fatalf("getSecret() = %v, want nil", err)
+	}
+	assert.Equal(t, []keyvaultObject{{
+		content: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		version: "v1",
+	}}, objs)
+
+	// the mount fails for a secret without a private key
+	kvClient.EXPECT().GetSecret(gomock.Any(), "key1", "").Return(&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("secret")}, nil)
+	_, err = p.getSecret(testContext(t), kvClient, kvObject)
+	assert.ErrorContains(t, err, "private key not found")
+}
+
+func TestEncodeEncryptedPrivateKeyDeterministic(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	encode := func(version, password string) []byte {
+		block, err := encodePrivateKey(key, types.PrivateKeyFormatEncryptedPKCS8, version, password)
+		if err != nil {
+			t.Fatalf("encodePrivateKey() = %v, want nil", err)
+		}
+		return block.Bytes
+	}
+
+	// the key of a version is the same every time it's written
+	assert.Equal(t, encode("v1", "password"), encode("v1", "password"))
+	assert.NotEqual(t, encode("v1", "password"), encode("v2", "password"))
+	assert.NotEqual(t, encode("v1", "password"), encode("v1", "password2"))
+}
@@ -1,16 +1,22 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -18,12 +24,18 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/fallback"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/pkg/errors"
@@ -42,6 +54,28 @@ type provider struct {
 
 	constructPEMChain              bool
 	writeCertAndKeyInSeparateFiles bool
+	// objectFetchConcurrency is the default number of objects fetched from
+	// Key Vault in parallel for a single mount
+	objectFetchConcurrency int
+
+	// credentialCache holds the credentials shared by mounts using the same identity
+	credentialCache *lruCache
+	// clientCache holds the key vault clients shared by mounts using the same identity and vault
+	clientCache *lruCache
+	// identityPins holds the identity chain modes pinned for the pods
+	identityPins *identityPins
+	// inflight tracks the key vault reads in progress to merge identical concurrent reads
+	inflight *flightGroup
+	// fallbackStore holds the last fetched objects for mounts that opted in to the fallback cache.
+	// nil if the fallback cache is not enabled for the node.
+	fallbackStore *fallback.Store
+	// allowedVaultHosts are the hosts outside of the vault domains of the cloud that tokens can be sent to.
+	// A host starting with "*." allows all its subdomains.
+	allowedVaultHosts []string
+	// keyRelease is the attestation token and the wrapping key the keys are released with
+	keyRelease KeyReleaseConfig
+	// releaseRoots are the roots the certificates of released keys are verified with, the system roots if nil
+	releaseRoots *x509.CertPool
 
 	defaultCloudEnvironment azure.Environment
 }
@@ -50,6 +84,14 @@ type provider struct {
 type mountConfig struct {
 	// the name of the Azure Key Vault instance
 	keyvaultName string
+	// the URI of the Azure Key Vault instance, used instead of the name for custom endpoints
+	vaultURI string
+	// the PEM CA certificates trusted for the vault URI in addition to the system roots
+	vaultCABundle string
+	// the name used to verify the certificate of the vault URI and sent as SNI
+	vaultServerName string
+	// the type of the vault, keyvault or managedHSM
+	vaultType string
 	// the type of azure cloud based on azure go sdk
 	azureCloudEnvironment azure.Environment
 	// authConfig is the config parameters for accessing Key Vault
@@ -62,18 +104,39 @@ type mountConfig struct {
 	podNamespace string
 }
 
+// fallbackVersionSuffix is appended to the version of objects served from the fallback cache
+const fallbackVersionSuffix = "-fallback"
+
+// userAssignedIdentityResourceType is the ARM resource type of user assigned identities
+const userAssignedIdentityResourceType = "Microsoft.ManagedIdentity/userAssignedIdentities"
+
 type keyvaultObject struct {
 	content        string
 	fileNameSuffix string
 	version        string
+	// metadata is set for the main file of the object if its metadata file is written
+	metadata *objectMetadata
+	// metadataOnly is set if only the metadata file is written for the object
+	metadataOnly bool
 }
 
 // NewProvider creates a new provider
-func NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment azure.Environment) Interface {
+func NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles bool, objectFetchConcurrency int, clientCacheTTL time.Duration, clientCacheSize int, fallbackStore *fallback.Store, allowedVaultHosts []string, keyRelease KeyReleaseConfig, defaultCloudEnvironment azure.Environment) Interface {
+	if objectFetchConcurrency < 1 {
+		objectFetchConcurrency = 1
+	}
 	return &provider{
 		reporter:                       metrics.NewStatsReporter(),
 		constructPEMChain:              constructPEMChain,
 		writeCertAndKeyInSeparateFiles: writeCertAndKeyInSeparateFiles,
+		objectFetchConcurrency:         objectFetchConcurrency,
+		credentialCache:                newLRUCache(clientCacheSize, clientCacheTTL),
+		clientCache:                    newLRUCache(clientCacheSize, clientCacheTTL),
+		identityPins:                   newIdentityPins(),
+		inflight:                       newFlightGroup(),
+		fallbackStore:                  fallbackStore,
+		allowedVaultHosts:              allowedVaultHosts,
+		keyRelease:                     keyRelease,
 		defaultCloudEnvironment:        defaultCloudEnvironment,
 	}
 }
@@ -86,39 +149,299 @@ func (p *provider) parseAzureEnvironment(cloudName string) (azure.Environment, e
 	return azure.EnvironmentFromName(cloudName)
 }
 
-func (mc *mountConfig) initializeKvClient(vaultURI string) (KeyVault, error) {
-	kvEndpoint := strings.TrimSuffix(mc.azureCloudEnvironment.KeyVaultEndpoint, "/")
+// getResource returns the AAD resource of the vault type
+func (mc *mountConfig) getResource(vaultType string) string {
+	if vaultType == types.VaultTypeManagedHSM {
+		endpoint, _ := getManagedHSMEndpoints(mc.azureCloudEnvironment)
+		return strings.TrimSuffix(endpoint, "/")
+	}
+	return strings.TrimSuffix(mc.azureCloudEnvironment.KeyVaultEndpoint, "/")
+}
+
+func (mc *mountConfig) getCredential(resource string) (azcore.TokenCredential, error) {
+	return mc.authConfig.GetCredential(mc.podName, mc.podNamespace, resource, mc.azureCloudEnvironment.ActiveDirectoryEndpoint, mc.tenantID, types.PodIdentityNMIPort)
+}
+
+// credentialCacheKey returns the key for the credential of the mount in the credential cache
+func (mc *mountConfig) credentialCacheKey(resource string) string {
+	return mc.authConfig.CacheKey(mc.podName, mc.podNamespace, mc.azureCloudEnvironment.ActiveDirectoryEndpoint, mc.tenantID) + "|" + resource
+}
+
+// initializeKvClient returns the key vault client for the vault. Credentials and clients are
+// cached across mounts so the access token for an identity is reused until it's close to expiry.
+func (p *provider) initializeKvClient(ctx context.Context, mc *mountConfig, vaultURI, vaultType string) (KeyVault, error) {
+	resource := mc.getResource(vaultType)
+	credKey := mc.credentialCacheKey(resource)
+	opts := mc.getClientOptions(vaultURI)
+	clientKey := credKey + "|" + vaultURI + "|" + opts.cacheKey()
+
+	if cached, ok := p.clientCache.get(clientKey); ok {
+		p.reporter.ReportCacheRequest(ctx, clientCacheName, true)
+		entry := cached.(*cachedClient)
+		// the credential of the client exchanges the service account token kubelet rotated since it was cached
+		setServiceAccountToken(entry.cred, mc.authConfig.ServiceAccountToken)
+		return entry.kv, nil
+	}
+	p.reporter.ReportCacheRequest(ctx, clientCacheName, false)
+
+	// the token of the identity is only sent to a vault outside of the vault domains of the cloud
+	// if the operator allowed its host, the identity may be shared by all the pods of the node
+	if opts.DisableChallengeResourceVerification && !isAllowedVaultHost(p.allowedVaultHosts, vaultURI) {
+		return nil, fmt.Errorf("vault %s is not in the vault domains of the cloud and its host is not in the allowed vault hosts of the provider", vaultURI)
+	}
+
+	cachedCred, err := p.getCachedCredential(ctx, mc, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := cachedCred
+	if opts.DisableChallengeResourceVerification {
+		// the vault isn't in a known vault domain so the token is limited to the vault resource
+		cred = newScopedTokenCredential(cred, resource+"/.default")
+	}
+	kvClient, err := NewClient(cred, vaultURI, opts)
+	if err != nil {
+		return nil, err
+	}
+	// the client key identifies both the credentials and the vault so reads are only
+	// merged between mounts that are authorized the same way
+	kvClient = newDedupClient(kvClient, clientKey, p.inflight, p.reporter)
+	p.clientCache.add(clientKey, &cachedClient{kv: kvClient, cred: cachedCred})
+	return kvClient, nil
+}
+
+// cachedClient is a key vault client in the client cache and the credential it authenticates with
+type cachedClient struct {
+	kv   KeyVault
+	cred azcore.TokenCredential
+}
 
-	cred, err := mc.authConfig.GetCredential(mc.podName, mc.podNamespace, kvEndpoint, mc.azureCloudEnvironment.ActiveDirectoryEndpoint, mc.tenantID, types.PodIdentityNMIPort)
+// setServiceAccountToken replaces the service account token of the credential if it exchanges one
+func setServiceAccountToken(cred azcore.TokenCredential, token string) {
+	if len(token) == 0 {
+		return
+	}
+	if setter, ok := cred.(auth.ServiceAccountTokenSetter); ok {
+		setter.SetServiceAccountToken(token)
+	}
+}
+
+// getCachedCredential returns the credential of the mount for the resource from the credential cache,
+// or creates and caches it
+func (p *provider) getCachedCredential(ctx context.Context, mc *mountConfig, resource string) (azcore.TokenCredential, error) {
+	credKey := mc.credentialCacheKey(resource)
+	if cached, ok := p.credentialCache.get(credKey); ok {
+		p.reporter.ReportCacheRequest(ctx, credentialCacheName, true)
+		// the service account token of the mount replaces the token kubelet rotated since the credential was cached
+		setServiceAccountToken(cached.(azcore.TokenCredential), mc.authConfig.ServiceAccountToken)
+		return cached.(azcore.TokenCredential), nil
+	}
+	p.reporter.ReportCacheRequest(ctx, credentialCacheName, false)
+	c, err := mc.getCredential(resource)
 	if err != nil {
 		return nil, err
 	}
-	return NewClient(cred, vaultURI)
+	cred := newCachedTokenCredential(c)
+	p.credentialCache.add(credKey, cred)
+	return cred, nil
+}
+
+// getClientOptions returns the options for the client of the vault. The TLS settings
+// only apply to the vault URI in the SecretProviderClass parameters.
+func (mc *mountConfig) getClientOptions(vaultURI string) *ClientOptions {
+	opts := &ClientOptions{
+		DisableChallengeResourceVerification: !mc.isCloudVaultHost(vaultURI),
+	}
+	if mc.vaultURI != "" {
+		if u, err := parseVaultURI(mc.vaultURI); err == nil && *u == vaultURI {
+			opts.CABundle = []byte(mc.vaultCABundle)
+			opts.ServerName = mc.vaultServerName
+		}
+	}
+	return opts
+}
+
+// isCloudVaultHost returns true if the host of the vault URI is in the Key Vault
+// or Managed HSM domain of the cloud environment
+func (mc *mountConfig) isCloudVaultHost(vaultURI string) bool {
+	u, err := url.Parse(vaultURI)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	_, hsmSuffix := getManagedHSMEndpoints(mc.azureCloudEnvironment)
+	for _, suffix := range []string{mc.azureCloudEnvironment.KeyVaultDNSSuffix, hsmSuffix} {
+		if isAvailable(suffix) && strings.HasSuffix(host, "."+strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedVaultHost returns true if the host of the vault URI is one of the allowed hosts or
+// a subdomain of an allowed host starting with "*."
+func isAllowedVaultHost(allowedHosts []string, vaultURI string) bool {
+	u, err := url.Parse(vaultURI)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
 }
 
 func (mc *mountConfig) getVaultURL() (vaultURL *string, err error) {
+	if mc.vaultURI != "" {
+		return parseVaultURI(mc.vaultURI)
+	}
+	return getVaultURLFromName(mc.keyvaultName, mc.vaultType, mc.azureCloudEnvironment)
+}
+
+// getObjectVaultType returns the type of the vault the object is fetched from
+func (mc *mountConfig) getObjectVaultType(kv types.KeyVaultObject) string {
+	var vaultType string
+	switch {
+	case kv.VaultType != "":
+		vaultType = kv.VaultType
+	case kv.VaultURI != "":
+		// the type of a vault set by URI is detected from the DNS suffix unless it's set on the object
+		vaultType = mc.detectVaultType(kv.VaultURI)
+	case kv.KeyVaultName == "" && mc.vaultURI != "" && mc.vaultType == "":
+		vaultType = mc.detectVaultType(mc.vaultURI)
+	default:
+		vaultType = mc.vaultType
+	}
+	if strings.EqualFold(vaultType, types.VaultTypeManagedHSM) {
+		return types.VaultTypeManagedHSM
+	}
+	return types.VaultTypeKeyVault
+}
+
+// detectVaultType returns managedHSM if the vault URI is in the Managed HSM domain
+// of the cloud environment and keyvault otherwise
+func (mc *mountConfig) detectVaultType(vaultURI string) string {
+	u, err := url.Parse(vaultURI)
+	if err != nil {
+		return types.VaultTypeKeyVault
+	}
+	_, suffix := getManagedHSMEndpoints(mc.azureCloudEnvironment)
+	if isAvailable(suffix) && strings.HasSuffix(strings.ToLower(u.Hostname()), "."+strings.ToLower(suffix)) {
+		return types.VaultTypeManagedHSM
+	}
+	return types.VaultTypeKeyVault
+}
+
+// getObjectVaultURL returns the URL of the vault the object is fetched from. Objects
+// that don't set a vault are fetched from the vault in the SecretProviderClass.
+func (mc *mountConfig) getObjectVaultURL(kv types.KeyVaultObject) (vaultURL *string, err error) {
+	if kv.VaultURI != "" {
+		return parseVaultURI(kv.VaultURI)
+	}
+	vaultType := mc.getObjectVaultType(kv)
+	if kv.KeyVaultName != "" {
+		return getVaultURLFromName(kv.KeyVaultName, vaultType, mc.azureCloudEnvironment)
+	}
+	if mc.vaultURI != "" {
+		return parseVaultURI(mc.vaultURI)
+	}
+	if mc.keyvaultName == "" {
+		return nil, errors.Errorf("keyvaultName is not provided")
+	}
+	return getVaultURLFromName(mc.keyvaultName, vaultType, mc.azureCloudEnvironment)
+}
+
+func getVaultURLFromName(keyvaultName, vaultType string, env azure.Environment) (vaultURL *string, err error) {
 	// Key Vault name must be a 3-24 character string
-	if len(mc.keyvaultName) < 3 || len(mc.keyvaultName) > 24 {
-		return nil, errors.Errorf("Invalid vault name: %q, must be between 3 and 24 chars", mc.keyvaultName)
+	if len(keyvaultName) < 3 || len(keyvaultName) > 24 {
+		return nil, errors.Errorf("Invalid vault name: %q, must be between 3 and 24 chars", keyvaultName)
 	}
 	// See docs for validation spec: https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#objects-identifiers-and-versioning
 	isValid := regexp.MustCompile(`^[-A-Za-z0-9]+$`).MatchString
-	if !isValid(mc.keyvaultName) {
-		return nil, errors.Errorf("Invalid vault name: %q, must match [-a-zA-Z0-9]{3,24}", mc.keyvaultName)
+	if !isValid(keyvaultName) {
+		return nil, errors.Errorf("Invalid vault name: %q, must match [-a-zA-Z0-9]{3,24}", keyvaultName)
 	}
 
-	vaultDNSSuffixValue := mc.azureCloudEnvironment.KeyVaultDNSSuffix
-	vaultURI := "https://" + mc.keyvaultName + "." + vaultDNSSuffixValue + "/"
+	vaultDNSSuffixValue := env.KeyVaultDNSSuffix
+	if strings.EqualFold(vaultType, types.VaultTypeManagedHSM) {
+		_, suffix := getManagedHSMEndpoints(env)
+		if !isAvailable(suffix) {
+			return nil, errors.Errorf("Managed HSM is not available in cloud %s", env.Name)
+		}
+		vaultDNSSuffixValue = suffix
+	}
+	vaultURI := "https://" + keyvaultName + "." + vaultDNSSuffixValue + "/"
 	return &vaultURI, nil
 }
 
+type managedHSMEndpoint struct {
+	resource  string
+	dnsSuffix string
+}
+
+// managedHSMEndpoints holds the Managed HSM endpoints of the clouds that
+// aren't populated by the azure go sdk
+var managedHSMEndpoints = map[string]managedHSMEndpoint{
+	azure.ChinaCloud.Name:        {resource: "https://managedhsm.azure.cn/", dnsSuffix: "managedhsm.azure.cn"},
+	azure.USGovernmentCloud.Name: {resource: "https://managedhsm.usgovcloudapi.net/", dnsSuffix: "managedhsm.usgovcloudapi.net"},
+}
+
+// getManagedHSMEndpoints returns the Managed HSM resource and DNS suffix of the cloud environment
+func getManagedHSMEndpoints(env azure.Environment) (endpoint, dnsSuffix string) {
+	if isAvailable(env.ManagedHSMDNSSuffix) {
+		return env.ManagedHSMEndpoint, env.ManagedHSMDNSSuffix
+	}
+	if e, ok := managedHSMEndpoints[env.Name]; ok {
+		return e.resource, e.dnsSuffix
+	}
+	return "", ""
+}
+
+// isAvailable returns true if the endpoint is configured for the cloud environment
+func isAvailable(endpoint string) bool {
+	return endpoint != "" && endpoint != azure.NotAvailable
+}
+
+// parseVaultURI validates the vault URI and returns it in the form https://<host>/
+func parseVaultURI(vaultURI string) (vaultURL *string, err error) {
+	u, err := url.Parse(vaultURI)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid vault URI: %q", vaultURI)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, errors.Errorf("Invalid vault URI: %q, must be an absolute https URI", vaultURI)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.Errorf("Invalid vault URI: %q, must not contain a path, query or fragment", vaultURI)
+	}
+	uri := "https://" + u.Host + "/"
+	return &uri, nil
+}
+
 // authConfigInput holds the input parameters for building auth configuration
 type authConfigInput struct {
-	identityMode             auth.IdentityMode
-	userAssignedIdentityID   string
-	workloadIdentityClientID string
-	saTokens                 string
-	secrets                  map[string]string
+	identityMode                   auth.IdentityMode
+	userAssignedIdentityID         string
+	userAssignedIdentityResourceID string
+	userAssignedIdentityObjectID   string
+	workloadIdentityClientID       string
+	workloadIdentityAudience       string
+	workloadIdentityTenantID       string
+	crossTenantClientID            string
+	crossTenantAudience            string
+	saTokens                       string
+	secrets                        map[string]string
 }
 
 // buildAuthConfig creates the authentication configuration based on the input parameters.
@@ -134,6 +457,21 @@ func buildAuthConfig(input authConfigInput) (auth.Config, error) {
 		}
 	}
 
+	if err := validateUserAssignedIdentity(input); err != nil {
+		return auth.Config{}, err
+	}
+	if input.workloadIdentityAudience != "" {
+		if input.identityMode == auth.IdentityModeAzureTokenProxy {
+			return auth.Config{}, fmt.Errorf("workloadIdentityAudience is not supported with useAzureTokenProxy")
+		}
+		if input.workloadIdentityClientID == "" {
+			return auth.Config{}, fmt.Errorf("workloadIdentityAudience requires clientID")
+		}
+	}
+	if err := validateCrossTenant(input); err != nil {
+		return auth.Config{}, err
+	}
+
 	var serviceAccountToken string
 	var err error
 
@@ -142,6 +480,11 @@ func buildAuthConfig(input authConfigInput) (auth.Config, error) {
 		if serviceAccountToken, err = auth.ParseIdentityBindingToken(input.saTokens); err != nil {
 			return auth.Config{}, fmt.Errorf("failed to parse service account token for identity binding, error: %w", err)
 		}
+	} else if input.workloadIdentityAudience != "" {
+		// For workload identity with a custom audience, the token is checked to be issued for the audience
+		if serviceAccountToken, err = auth.ParseServiceAccountTokenForAudience(input.saTokens, input.workloadIdentityAudience); err != nil {
+			return auth.Config{}, fmt.Errorf("failed to parse workload identity tokens, error: %w", err)
+		}
 	} else if input.workloadIdentityClientID != "" {
 		// For workload identity, parse the token with the workload identity audience
 		if serviceAccountToken, err = auth.ParseServiceAccountToken(input.saTokens); err != nil {
@@ -149,21 +492,80 @@ func buildAuthConfig(input authConfigInput) (auth.Config, error) {
 		}
 	}
 
-	return auth.NewConfig(
+	config, err := auth.NewConfig(
 		input.identityMode,
 		input.userAssignedIdentityID,
 		input.workloadIdentityClientID,
 		serviceAccountToken,
 		input.secrets,
 	)
+	if err != nil {
+		return config, err
+	}
+	config.UserAssignedIdentityResourceID = input.userAssignedIdentityResourceID
+	config.UserAssignedIdentityObjectID = input.userAssignedIdentityObjectID
+	config.WorkloadIdentityTenantID = input.workloadIdentityTenantID
+	config.CrossTenantClientID = input.crossTenantClientID
+	config.CrossTenantAudience = input.crossTenantAudience
+	return config, nil
+}
+
+// validateCrossTenant validates the cross tenant access parameters are set together and only with workload identity
+func validateCrossTenant(input authConfigInput) error {
+	if input.workloadIdentityTenantID == "" && input.crossTenantClientID == "" && input.crossTenantAudience == "" {
+		return nil
+	}
+	if input.identityMode != auth.IdentityModeNone || input.workloadIdentityClientID == "" {
+		return fmt.Errorf("workloadIdentityTenantID, crossTenantClientID and crossTenantAudience are only supported with workload identity, clientID is required")
+	}
+	if input.workloadIdentityTenantID == "" || input.crossTenantClientID == "" {
+		return fmt.Errorf("workloadIdentityTenantID and crossTenantClientID are required for cross tenant access")
+	}
+	return nil
+}
+
+// validateUserAssignedIdentity validates the user assigned identity is selected by at most one of
+// the client ID, resource ID or object ID
+func validateUserAssignedIdentity(input authConfigInput) error {
+	ids := 0
+	for _, id := range []string{input.userAssignedIdentityID, input.userAssignedIdentityResourceID, input.userAssignedIdentityObjectID} {
+		if id != "" {
+			ids++
+		}
+	}
+	if ids > 1 {
+		return fmt.Errorf("userAssignedIdentityID, userAssignedIdentityResourceID and userAssignedIdentityObjectID are mutually exclusive")
+	}
+	if input.userAssignedIdentityResourceID == "" && input.userAssignedIdentityObjectID == "" {
+		return nil
+	}
+	if input.identityMode != auth.IdentityModeVMManagedIdentity {
+		return fmt.Errorf("userAssignedIdentityResourceID and userAssignedIdentityObjectID are only supported with useVMManagedIdentity")
+	}
+	if input.userAssignedIdentityResourceID != "" {
+		id, err := arm.ParseResourceID(input.userAssignedIdentityResourceID)
+		if err != nil {
+			return fmt.Errorf("invalid userAssignedIdentityResourceID: %s, error: %w", input.userAssignedIdentityResourceID, err)
+		}
+		if !strings.EqualFold(id.ResourceType.String(), userAssignedIdentityResourceType) {
+			return fmt.Errorf("invalid userAssignedIdentityResourceID: %s, should be the resource ID of a %s", input.userAssignedIdentityResourceID, userAssignedIdentityResourceType)
+		}
+	}
+	return nil
 }
 
 // GetSecretsStoreObjectContent gets the objects (secret, key, certificate) from keyvault and returns the content
 // to the CSI driver. The driver will write the content to the file system.
 func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, secrets map[string]string, defaultFilePermission os.FileMode) ([]types.SecretFile, error) {
 	keyvaultName := types.GetKeyVaultName(attrib)
+	vaultURI := types.GetVaultURI(attrib)
+	vaultCABundle := types.GetVaultCABundle(attrib)
+	vaultServerName := types.GetVaultServerName(attrib)
+	vaultType := types.GetVaultType(attrib)
 	cloudName := types.GetCloudName(attrib)
 	userAssignedIdentityID := types.GetUserAssignedIdentityID(attrib)
+	userAssignedIdentityResourceID := types.GetUserAssignedIdentityResourceID(attrib)
+	userAssignedIdentityObjectID := types.GetUserAssignedIdentityObjectID(attrib)
 	tenantID := types.GetTenantID(attrib)
 	cloudEnvFileName := types.GetCloudEnvFileName(attrib)
 	podName := types.GetPodName(attrib)
@@ -188,6 +590,21 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse useAzureTokenProxy flag, error: %w", err)
 	}
+	objectFetchConcurrency, err := types.GetObjectFetchConcurrency(attrib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse objectFetchConcurrency, error: %w", err)
+	}
+	if objectFetchConcurrency == 0 {
+		objectFetchConcurrency = p.objectFetchConcurrency
+	}
+	useFallbackCache, err := types.GetUseFallbackCache(attrib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse useFallbackCache flag, error: %w", err)
+	}
+	if useFallbackCache && p.fallbackStore == nil {
+		klog.InfoS("useFallbackCache is set but the fallback cache is not enabled for the provider", "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+		useFallbackCache = false
+	}
 
 	// Determine identity mode and validate mutual exclusivity
 	identityMode := auth.IdentityModeNone
@@ -207,17 +624,42 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 	if modesEnabled > 1 {
 		return nil, fmt.Errorf("only one identity mode can be enabled at a time: usePodIdentity, useVMManagedIdentity, or useAzureTokenProxy")
 	}
+	identityChain := types.GetIdentityChain(attrib)
+	if len(identityChain) > 0 && modesEnabled > 0 {
+		return nil, fmt.Errorf("identityChain is mutually exclusive with usePodIdentity, useVMManagedIdentity and useAzureTokenProxy")
+	}
+	if err = validateIdentityChain(identityChain); err != nil {
+		return nil, err
+	}
+	identityChainPinDuration, err := types.GetIdentityChainPinDuration(attrib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identityChainPinDuration, error: %w", err)
+	}
 
 	// attributes for workload identity
 	workloadIdentityClientID := types.GetClientID(attrib)
+	workloadIdentityAudience := types.GetWorkloadIdentityAudience(attrib)
+	workloadIdentityTenantID := types.GetWorkloadIdentityTenantID(attrib)
+	crossTenantClientID := types.GetCrossTenantClientID(attrib)
+	crossTenantAudience := types.GetCrossTenantAudience(attrib)
 	saTokens := types.GetServiceAccountTokens(attrib)
 
-	if keyvaultName == "" {
-		return nil, fmt.Errorf("keyvaultName is not provided")
-	}
 	if tenantID == "" {
 		return nil, fmt.Errorf("tenantId is not provided")
 	}
+	if keyvaultName != "" && vaultURI != "" {
+		return nil, fmt.Errorf("keyvaultName and vaultURI are mutually exclusive")
+	}
+	if vaultURI != "" {
+		if _, err = parseVaultURI(vaultURI); err != nil {
+			return nil, fmt.Errorf("failed to parse vaultURI, error: %w", err)
+		}
+	} else if vaultCABundle != "" || vaultServerName != "" {
+		return nil, fmt.Errorf("vaultCABundle and vaultServerName are only supported with vaultURI")
+	}
+	if vaultType != "" && !strings.EqualFold(vaultType, types.VaultTypeKeyVault) && !strings.EqualFold(vaultType, types.VaultTypeManagedHSM) {
+		return nil, fmt.Errorf("invalid vaultType: %s, should be keyvault or managedHSM", vaultType)
+	}
 
 	err = setAzureEnvironmentFilePath(cloudEnvFileName)
 	if err != nil {
@@ -228,38 +670,73 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 		return nil, fmt.Errorf("cloudName %s is not valid, error: %w", cloudName, err)
 	}
 
-	// Build auth configuration using helper function
-	authConfig, err := buildAuthConfig(authConfigInput{
-		identityMode:             identityMode,
-		userAssignedIdentityID:   userAssignedIdentityID,
-		workloadIdentityClientID: workloadIdentityClientID,
-		saTokens:                 saTokens,
-		secrets:                  secrets,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to build auth config for mode %s: %w", identityMode, err)
+	authInput := authConfigInput{
+		identityMode:                   identityMode,
+		userAssignedIdentityID:         userAssignedIdentityID,
+		userAssignedIdentityResourceID: userAssignedIdentityResourceID,
+		userAssignedIdentityObjectID:   userAssignedIdentityObjectID,
+		workloadIdentityClientID:       workloadIdentityClientID,
+		workloadIdentityAudience:       workloadIdentityAudience,
+		workloadIdentityTenantID:       workloadIdentityTenantID,
+		crossTenantClientID:            crossTenantClientID,
+		crossTenantAudience:            crossTenantAudience,
+		saTokens:                       saTokens,
+		secrets:                        secrets,
 	}
 
 	mc := &mountConfig{
 		keyvaultName:          keyvaultName,
+		vaultURI:              vaultURI,
+		vaultCABundle:         vaultCABundle,
+		vaultServerName:       vaultServerName,
+		vaultType:             vaultType,
 		azureCloudEnvironment: azureCloudEnv,
-		authConfig:            authConfig,
 		tenantID:              tenantID,
 		podName:               podName,
 		podNamespace:          podNamespace,
 	}
+	var identityChainMode string
+	if len(identityChain) > 0 {
+		if mc.authConfig, identityChainMode, err = p.resolveIdentityChain(ctx, mc, identityChain, identityChainResources(mc, attrib), authInput); err != nil {
+			return nil, err
+		}
+	} else {
+		// Build auth configuration using helper function
+		if mc.authConfig, err = buildAuthConfig(authInput); err != nil {
+			return nil, fmt.Errorf("failed to build auth config for mode %s: %w", identityMode, err)
+		}
+	}
+
+	pod := klog.ObjectRef{Namespace: podNamespace, Name: podName}
+	files, err := p.getMountObjects(ctx, mc, attrib, objectFetchConcurrency, defaultFilePermission, pod)
+	if len(identityChain) > 0 {
+		p.pinIdentityChainMode(mc, identityChain, identityChainMode, identityChainPinDuration, err)
+	}
+	if useFallbackCache {
+		return p.useFallback(ctx, fallbackCacheKey(attrib, mc.authConfig), files, err, pod)
+	}
+	return files, err
+}
+
+// getMountObjects fetches the objects of the mount with the auth config of the mount and returns
+// the files for the objects and the files rendered from them
+func (p *provider) getMountObjects(ctx context.Context, mc *mountConfig, attrib map[string]string, objectFetchConcurrency int, defaultFilePermission os.FileMode, pod klog.ObjectRef) ([]types.SecretFile, error) {
+	certKeySplitDefaults, err := p.getCertKeySplitDefaults(attrib)
+	if err != nil {
+		return nil, err
+	}
 
 	objectsStrings := types.GetObjects(attrib)
 	if objectsStrings == "" {
 		return nil, fmt.Errorf("objects is not set")
 	}
-	klog.V(2).InfoS("objects string defined in secret provider class", "objects", objectsStrings, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	klog.V(2).InfoS("objects string defined in secret provider class", "objects", objectsStrings, "pod", pod)
 
 	objects, err := types.GetObjectsArray(objectsStrings)
 	if err != nil {
 		return nil, fmt.Errorf("failed to yaml unmarshal objects, error: %w", err)
 	}
-	klog.V(2).InfoS("unmarshaled objects yaml array", "objectsArray", objects.Array, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	klog.V(2).InfoS("unmarshaled objects yaml array", "objectsArray", objects.Array, "pod", pod)
 
 	keyVaultObjects := []types.KeyVaultObject{}
 	for i, object := range objects.Array {
@@ -270,6 +747,7 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 		}
 		// remove whitespace from all fields in keyVaultObject
 		formatKeyVaultObject(&keyVaultObject)
+		applyCertKeySplitDefaults(&keyVaultObject, certKeySplitDefaults)
 
 		if err = validate(keyVaultObject); err != nil {
 			return nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
@@ -278,61 +756,399 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 		keyVaultObjects = append(keyVaultObjects, keyVaultObject)
 	}
 
-	klog.V(5).InfoS("unmarshaled key vault objects", "keyVaultObjects", keyVaultObjects, "count", len(keyVaultObjects), "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	klog.V(5).InfoS("unmarshaled key vault objects", "keyVaultObjects", keyVaultObjects, "count", len(keyVaultObjects), "pod", pod)
+
+	templates, err := parseTemplates(types.GetTemplates(attrib), defaultFilePermission)
+	if err != nil {
+		return nil, err
+	}
+	env, err := parseEnvFile(types.GetEnvFile(attrib), types.GetEnvFileFormat(attrib), keyVaultObjects, defaultFilePermission)
+	if err != nil {
+		return nil, err
+	}
+	jwks, err := parseJWKSFile(types.GetJWKSFile(attrib), keyVaultObjects, defaultFilePermission)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(keyVaultObjects) == 0 {
 		return nil, nil
 	}
 
-	vaultURL, err := mc.getVaultURL()
+	// objects can be fetched from different vaults, only create a single client for every vault
+	kvClients := make([]KeyVault, len(keyVaultObjects))
+	vaultClients := make(map[string]KeyVault)
+	for i, keyVaultObject := range keyVaultObjects {
+		vaultType := mc.getObjectVaultType(keyVaultObject)
+		if err = validateVaultType(vaultType, keyVaultObject.ObjectType); err != nil {
+			return nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		vaultURL, err := mc.getObjectVaultURL(keyVaultObject)
+		if err != nil {
+			return nil, wrapObjectTypeError(errors.Wrap(err, "failed to get vault"), keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		kvClient, ok := vaultClients[*vaultURL]
+		if !ok {
+			klog.V(2).InfoS("vault url", "vaultURL", *vaultURL, "vaultType", vaultType, "pod", pod)
+			if kvClient, err = p.initializeKvClient(ctx, mc, *vaultURL, vaultType); err != nil {
+				return nil, errors.Wrap(err, "failed to get keyvault client")
+			}
+			vaultClients[*vaultURL] = kvClient
+		}
+		kvClients[i] = kvClient
+	}
+
+	if kvClients, keyVaultObjects, err = p.expandObjects(ctx, kvClients, keyVaultObjects); err != nil {
+		return nil, err
+	}
+
+	files, err := p.fetchObjects(ctx, kvClients, keyVaultObjects, objectFetchConcurrency, defaultFilePermission, pod)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get vault")
+		return nil, err
+	}
+	return appendRenderedFiles(ctx, files, keyVaultObjects, templates, env, jwks)
+}
+
+// useFallback saves the fetched files to the fallback cache if they were fetched successfully.
+// If fetching failed with a transient error, the last saved files for the mount are returned
+// instead as long as they are not older than the max staleness of the fallback cache.
+func (p *provider) useFallback(ctx context.Context, key string, files []types.SecretFile, fetchErr error, pod klog.ObjectRef) ([]types.SecretFile, error) {
+	if fetchErr == nil {
+		if err := p.fallbackStore.Save(key, files); err != nil {
+			klog.ErrorS(err, "failed to save objects to the fallback cache", "pod", pod)
+		}
+		return files, nil
+	}
+	if !isTransientError(fetchErr) {
+		return nil, fetchErr
+	}
+
+	cached, stored, err := p.fallbackStore.Load(key)
+	if err != nil {
+		klog.V(2).InfoS("fallback cache not used", "reason", err.Error(), "pod", pod)
+		return nil, fetchErr
+	}
+	klog.InfoS("serving objects from the fallback cache as key vault is unavailable", "error", fetchErr.Error(), "storedAt", stored, "pod", pod)
+	p.reporter.ReportFallbackServed(ctx)
+	for i := range cached {
+		cached[i].Version += fallbackVersionSuffix
+	}
+	return cached, nil
+}
+
+// fallbackCacheKey returns the key for the mount in the fallback cache. The key is scoped to
+// the namespace, the SecretProviderClass parameters and the identity used for the mount so
+// only pods that would be authorized to fetch the same objects are served the cached objects.
+func fallbackCacheKey(attrib map[string]string, authConfig auth.Config) string {
+	parts := []string{
+		types.GetPodNamespace(attrib),
+		types.GetServiceAccountName(attrib),
+		authConfig.IdentityMode.String(),
+		authConfig.UserAssignedIdentityID,
+		authConfig.UserAssignedIdentityResourceID,
+		authConfig.UserAssignedIdentityObjectID,
+		authConfig.WorkloadIdentityClientID,
+		authConfig.WorkloadIdentityTenantID,
+		authConfig.CrossTenantClientID,
+		authConfig.AADClientID,
+	}
+	if authConfig.IdentityMode == auth.IdentityModePodIdentity {
+		// the identity is assigned to the pod by NMI
+		parts = append(parts, types.GetPodName(attrib))
+	}
+
+	// attributes added by the driver are specific to the pod and the service account
+	// tokens change on every refresh
+	var params []string
+	for k, v := range attrib {
+		if strings.HasPrefix(k, types.CSIAttributePrefix) {
+			continue
+		}
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	return strings.Join(append(parts, params...), "\n")
+}
+
+// isTransientError returns true if the error is caused by key vault or AAD being
+// temporarily unavailable or too slow to respond. Authorization failures are never transient.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return isTransientStatusCode(respErr.StatusCode)
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		if authErr.RawResponse == nil {
+			return false
+		}
+		return isTransientStatusCode(authErr.RawResponse.StatusCode)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func isTransientStatusCode(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// expandObjects replaces the objects that select objects by name prefix, regex or tags with the
+// enabled objects in the vault they select, sorted by name. The file name of a selected
+// object is its name, in the directory set by the object alias if one is given.
+func (p *provider) expandObjects(ctx context.Context, kvClients []KeyVault, kvObjects []types.KeyVaultObject) ([]KeyVault, []types.KeyVaultObject, error) {
+	var expandedClients []KeyVault
+	var expandedObjects []types.KeyVaultObject
+	for i, kvObject := range kvObjects {
+		if !kvObject.IsSelector() {
+			expandedClients = append(expandedClients, kvClients[i])
+			expandedObjects = append(expandedObjects, kvObject)
+			continue
+		}
+		selected, err := p.selectObjects(ctx, kvClients[i], kvObject)
+		if err != nil {
+			return nil, nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.GetSelector(), kvObject.ObjectVersion)
+		}
+		for _, selectedObject := range selected {
+			expandedClients = append(expandedClients, kvClients[i])
+			expandedObjects = append(expandedObjects, selectedObject)
+		}
 	}
-	klog.V(2).InfoS("vault url", "vaultName", mc.keyvaultName, "vaultURL", *vaultURL, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	return expandedClients, expandedObjects, nil
+}
 
-	// the keyvault name is per SPC and we don't need to recreate the client for every single keyvault object defined
-	kvClient, err := mc.initializeKvClient(*vaultURL)
+// selectObjects returns the objects selected by the object name prefix, regex or tags
+func (p *provider) selectObjects(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) ([]types.KeyVaultObject, error) {
+	tags, err := kvObject.GetObjectTags()
+	if err != nil {
+		return nil, err
+	}
+	properties, err := p.getKeyVaultObjectList(ctx, kvClient, kvObject)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get keyvault client")
+		return nil, err
+	}
+
+	var nameRegex *regexp.Regexp
+	if kvObject.ObjectNameRegex != "" {
+		// the regex must match the whole name
+		if nameRegex, err = regexp.Compile("^(?:" + kvObject.ObjectNameRegex + ")$"); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, object := range properties {
+		// disabled objects can't be fetched
+		if !object.Enabled {
+			continue
+		}
+		if kvObject.ObjectNamePrefix != "" && !strings.HasPrefix(object.Name, kvObject.ObjectNamePrefix) {
+			continue
+		}
+		if nameRegex != nil && !nameRegex.MatchString(object.Name) {
+			continue
+		}
+		if !matchTags(tags, object.Tags) {
+			continue
+		}
+		names = append(names, object.Name)
+	}
+	if len(names) > kvObject.GetMaxObjects() {
+		return nil, errors.Errorf("selected %d objects, more than the maximum of %d", len(names), kvObject.GetMaxObjects())
+	}
+	sort.Strings(names)
+
+	selected := make([]types.KeyVaultObject, 0, len(names))
+	for _, name := range names {
+		selectedObject := kvObject
+		selectedObject.ObjectName = name
+		selectedObject.ObjectNamePrefix = ""
+		selectedObject.ObjectNameRegex = ""
+		selectedObject.ObjectTags = ""
+		selectedObject.MaxObjects = 0
+		if kvObject.ObjectAlias != "" {
+			selectedObject.ObjectAlias = filepath.Join(kvObject.ObjectAlias, name)
+		}
+		if err = validate(selectedObject); err != nil {
+			return nil, err
+		}
+		selected = append(selected, selectedObject)
+	}
+	klog.V(5).InfoS("selected key vault objects", "selector", kvObject.GetSelector(), "objectType", kvObject.ObjectType, "count", len(selected))
+	return selected, nil
+}
+
+// getKeyVaultObjectList returns the objects of the type of the object in the vault
+func (p *provider) getKeyVaultObjectList(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (objects []types.KeyVaultObjectProperties, err error) {
+	start := time.Now()
+	defer func() {
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		p.reporter.ReportKeyvaultRequest(ctx, time.Since(start).Seconds(), kvObject.ObjectType, kvObject.GetSelector(), errMsg)
+	}()
+
+	switch kvObject.ObjectType {
+	case types.VaultObjectTypeSecret:
+		return kvClient.ListSecrets(ctx)
+	case types.VaultObjectTypeKey:
+		return kvClient.ListKeys(ctx)
+	case types.VaultObjectTypeCertificate:
+		return kvClient.ListCertificates(ctx)
+	default:
+		return nil, errors.Errorf("Invalid vaultObjectTypes. Should be secret, key, or cert")
 	}
+}
+
+// fetchObjects fetches the key vault objects using at most concurrency workers and returns
+// the files in the same order as the objects are defined in the SecretProviderClass.
+// kvClients holds the client of the vault for the object at the same index.
+// Once an object fails, no new objects are dispatched and the error for the first failed
+// object in the defined order is returned, so the result does not depend on scheduling.
+func (p *provider) fetchObjects(ctx context.Context, kvClients []KeyVault, kvObjects []types.KeyVaultObject, concurrency int, defaultFilePermission os.FileMode, pod klog.ObjectRef) ([]types.SecretFile, error) {
+	results := make([][]types.SecretFile, len(kvObjects))
+	errs := make([]error, len(kvObjects))
+
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+dispatch:
+	for i := range kvObjects {
+		select {
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			break dispatch
+		case sem <- struct{}{}:
+		}
+		if failed.Load() {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = p.getObjectFiles(ctx, kvClients[i], kvObjects[i], defaultFilePermission, pod)
+			if errs[i] != nil {
+				failed.Store(true)
+			}
+		}(i)
+	}
+	wg.Wait()
 
 	files := []types.SecretFile{}
-	for _, keyVaultObject := range keyVaultObjects {
-		klog.V(5).InfoS("fetching object from key vault", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "keyvault", mc.keyvaultName, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	for i := range kvObjects {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		files = append(files, results[i]...)
+	}
+	return files, nil
+}
 
-		resolvedKvObjects, err := p.resolveObjectVersions(ctx, kvClient, keyVaultObject)
+// appendRenderedFiles returns the fetched files with the files rendered from them
+// for the templates, the env file and the JWKS file
+func appendRenderedFiles(ctx context.Context, files []types.SecretFile, kvObjects []types.KeyVaultObject, templates []objectTemplate, env *envFile, jwks *jwksFile) ([]types.SecretFile, error) {
+	rendered, err := renderTemplates(ctx, templates, files)
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		file, err := env.render(kvObjects, files)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, *file)
+	}
+	if jwks != nil {
+		file, err := jwks.render(kvObjects, files)
 		if err != nil {
 			return nil, err
 		}
+		rendered = append(rendered, *file)
+	}
+	// the rendered files are checked against the object files when they are rendered
+	paths := make(map[string]bool, len(rendered))
+	for _, file := range rendered {
+		if paths[file.Path] {
+			return nil, fmt.Errorf("%s is written by more than one of templates, envFile and jwksFile", file.Path)
+		}
+		paths[file.Path] = true
+	}
+	return append(files, rendered...), nil
+}
 
-		for _, resolvedKvObject := range resolvedKvObjects {
-			// fetch the object from Key Vault
-			result, err := p.getKeyVaultObjectContent(ctx, kvClient, resolvedKvObject)
-			if err != nil {
-				return nil, err
+// getObjectFiles fetches all the configured versions of a single key vault object
+// and returns the files to be written for it
+func (p *provider) getObjectFiles(ctx context.Context, kvClient KeyVault, keyVaultObject types.KeyVaultObject, defaultFilePermission os.FileMode, pod klog.ObjectRef) ([]types.SecretFile, error) {
+	klog.V(5).InfoS("fetching object from key vault", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "pod", pod)
+
+	resolvedKvObjects, err := p.resolveObjectVersions(ctx, kvClient, keyVaultObject)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []types.SecretFile{}
+	for _, resolvedKvObject := range resolvedKvObjects {
+		if err := ctx.Err(); err != nil {
+			return nil, wrapObjectTypeError(err, resolvedKvObject.ObjectType, resolvedKvObject.ObjectName, resolvedKvObject.ObjectVersion)
+		}
+		// fetch the object from Key Vault
+		result, err := p.getKeyVaultObjectContent(ctx, kvClient, resolvedKvObject)
+		if err != nil {
+			return nil, err
+		}
+
+		for idx := range result {
+			r := result[idx]
+			// objectUID is a unique identifier in the format <object type>/<object name>
+			// This is the object id the user sees in the SecretProviderClassPodStatus
+			objectUID := resolvedKvObject.GetObjectUID()
+			file := types.SecretFile{
+				Path:    resolvedKvObject.GetFileName(),
+				UID:     objectUID,
+				Version: r.version,
 			}
+			// the validity of file permission is already checked in the validate function above
+			file.FileMode, _ = resolvedKvObject.GetFilePermission(defaultFilePermission)
 
-			for idx := range result {
-				r := result[idx]
+			if !r.metadataOnly {
 				objectContent, err := getContentBytes(r.content, resolvedKvObject.ObjectType, resolvedKvObject.ObjectEncoding)
 				if err != nil {
 					return nil, err
 				}
 
-				// objectUID is a unique identifier in the format <object type>/<object name>
-				// This is the object id the user sees in the SecretProviderClassPodStatus
-				objectUID := resolvedKvObject.GetObjectUID()
-				file := types.SecretFile{
-					Path:    resolvedKvObject.GetFileName() + r.fileNameSuffix,
-					Content: objectContent,
-					UID:     objectUID,
-					Version: r.version,
+				fields := []jsonField{{fileNameSuffix: r.fileNameSuffix, content: objectContent}}
+				if resolvedKvObject.IsJSONSelector() {
+					if fields, err = extractJSONFields(objectContent, resolvedKvObject); err != nil {
+						return nil, wrapObjectTypeError(err, resolvedKvObject.ObjectType, resolvedKvObject.ObjectName, resolvedKvObject.ObjectVersion)
+					}
+				}
+				for _, field := range fields {
+					file.Path = resolvedKvObject.GetFileName() + field.fileNameSuffix
+					file.Content = field.content
+					files = append(files, file)
+					klog.V(5).InfoS("added file to the gRPC response", "file", file.Path, "pod", pod)
 				}
-				// the validity of file permission is already checked in the validate function above
-				file.FileMode, _ = resolvedKvObject.GetFilePermission(defaultFilePermission)
+			}
 
-				files = append(files, file)
-				klog.V(5).InfoS("added file to the gRPC response", "file", file.Path, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+			if r.metadata != nil {
+				content, err := r.metadata.marshal()
+				if err != nil {
+					return nil, wrapObjectTypeError(err, resolvedKvObject.ObjectType, resolvedKvObject.ObjectName, resolvedKvObject.ObjectVersion)
+				}
+				metadataFile := file
+				metadataFile.Path = resolvedKvObject.GetFileName() + metadataFileNameSuffix
+				metadataFile.Content = content
+				files = append(files, metadataFile)
+				klog.V(5).InfoS("added file to the gRPC response", "file", metadataFile.Path, "pod", pod)
 			}
 		}
 	}
@@ -341,6 +1157,9 @@ func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, sec
 }
 
 func (p *provider) resolveObjectVersions(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (versions []types.KeyVaultObject, err error) {
+	if kvObject.ObjectVersionTags != "" {
+		return p.resolveTaggedObjectVersions(ctx, kvClient, kvObject)
+	}
 	if kvObject.IsSyncingSingleVersion() {
 		// version history less than or equal to 1 means only sync the latest and
 		// don't add anything to the file name
@@ -355,6 +1174,46 @@ func (p *provider) resolveObjectVersions(ctx context.Context, kvClient KeyVault,
 	return getLatestNKeyVaultObjects(kvObject, kvObjectVersions), nil
 }
 
+// resolveTaggedObjectVersions returns the newest versions of the object with the object version tags
+func (p *provider) resolveTaggedObjectVersions(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) ([]types.KeyVaultObject, error) {
+	tags, err := kvObject.GetObjectVersionTags()
+	if err != nil {
+		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+	}
+	kvObjectVersions, err := p.getKeyVaultObjectVersions(ctx, kvClient, kvObject)
+	if err != nil {
+		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+	}
+
+	var taggedVersions types.KeyVaultObjectVersionList
+	for _, version := range kvObjectVersions {
+		if matchTags(tags, version.Tags) {
+			taggedVersions = append(taggedVersions, version)
+		}
+	}
+	if len(taggedVersions) == 0 {
+		err = errors.Errorf("no enabled version with tags %s", kvObject.ObjectVersionTags)
+		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+	}
+
+	if kvObject.IsSyncingSingleVersion() {
+		sort.Sort(taggedVersions)
+		kvObject.ObjectVersion = taggedVersions[0].Version
+		return []types.KeyVaultObject{kvObject}, nil
+	}
+	return getLatestNKeyVaultObjects(kvObject, taggedVersions), nil
+}
+
+// matchTags returns true if the object has all the selector tags
+func matchTags(selector, tags map[string]string) bool {
+	for key, value := range selector {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
 /*
 Given a base key vault object and a list of object versions and their created dates, find
 the latest kvObject.ObjectVersionHistory versions and return key vault objects with the
@@ -468,6 +1327,8 @@ func (p *provider) getSecret(ctx context.Context, kvClient KeyVault, kvObject ty
 	id := *secret.ID
 	version := id.Version()
 	result := []keyvaultObject{}
+	// index of the key file in the result when the cert and key are written in separate files
+	keyFileIndex := -1
 	// if the secret is part of a certificate, then we need to convert the certificate and key to PEM format
 	if secret.Kid != nil && len(*secret.Kid) > 0 {
 		switch *secret.ContentType {
@@ -486,19 +1347,61 @@ func (p *provider) getSecret(ctx context.Context, kvClient KeyVault, kvObject ty
 			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 
-		if p.writeCertAndKeyInSeparateFiles {
-			// when writeCertAndKeyInSeparateFiles feature flag is enabled, we write the cert and key in separate files
-			// with suffixes .crt and .key respectively. These files are written in addition to the default file which
-			// contains the cert and key in a single file to maintain backward compatibility with the existing behavior.
+		if kvObject.PrivateKeyFormat != "" {
+			if content, err = formatObjectPrivateKeys(ctx, kvClient, kvObject, content, version); err != nil {
+				return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+		}
+
+		if kvObject.IsWritingCertAndKeyInSeparateFiles(p.writeCertAndKeyInSeparateFiles) {
+			// when writeCertAndKeyInSeparateFiles is enabled for the object, or by the feature flag by default, we write
+			// the cert and key in separate files with suffixes .crt and .key by default. These files are written in addition
+			// to the default file which contains the cert and key in a single file to maintain backward compatibility with
+			// the existing behavior, unless writeCombinedCertAndKey is disabled for the object.
 			cert, key := splitCertAndKey(content)
 			result = append(result,
-				keyvaultObject{version: version, content: cert, fileNameSuffix: ".crt"},
-				keyvaultObject{version: version, content: key, fileNameSuffix: ".key"},
+				keyvaultObject{version: version, content: cert, fileNameSuffix: kvObject.GetCertFileSuffix()},
+				keyvaultObject{version: version, content: key, fileNameSuffix: kvObject.GetKeyFileSuffix()},
 			)
+			keyFileIndex = len(result) - 1
+		}
+	} else if kvObject.PrivateKeyFormat != "" {
+		// a secret with a PEM private key that isn't part of a certificate
+		if content, err = formatObjectPrivateKeys(ctx, kvClient, kvObject, content, version); err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 	}
+	if kvObject.WriteCertChainFiles {
+		chainFiles, err := getCertChainFiles(kvObject, content, version)
+		if err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		result = append(result, chainFiles...)
+	}
+	if isKeystoreObject(kvObject) {
+		// the keystore is built from the PEM certificate and key of a certificate, or from a secret with PEM content
+		password, err := getObjectPassword(ctx, kvClient, kvObject)
+		if err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		keystore, err := buildKeystore(kvObject, content, version, password)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to write %s keystore", strings.ToLower(kvObject.ObjectFormat))
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		content = string(keystore)
+	}
 
-	result = append(result, keyvaultObject{content: content, version: version})
+	var metadata *objectMetadata
+	if kvObject.WriteMetadata {
+		metadata = newSecretMetadata(secret, version)
+	}
+	if keyFileIndex != -1 && !kvObject.IsWritingCombinedCertAndKey() {
+		// the metadata is written with the key file instead of the combined file
+		result[keyFileIndex].metadata = metadata
+		return result, nil
+	}
+	result = append(result, keyvaultObject{content: content, version: version, metadata: metadata})
 	return result, nil
 }
 
@@ -517,6 +1420,33 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 
 	id := *keybundle.Key.KID
 	version := id.Version()
+	var metadata *objectMetadata
+	if kvObject.WriteMetadata {
+		metadata = newKeyMetadata(keybundle, version)
+	}
+	if kvObject.ReleaseKey {
+		if keybundle.Attributes == nil || keybundle.Attributes.Exportable == nil || !*keybundle.Attributes.Exportable {
+			err := errors.Errorf("failed to release key. key is not exportable")
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		// release the version that was fetched so the private key matches the version reported for the file
+		content, err := p.releaseKey(ctx, kvClient, kvObject, id, *keybundle.Key.Kty)
+		if err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return []keyvaultObject{{content: content, version: version, metadata: metadata}}, nil
+	}
+	if strings.EqualFold(kvObject.ObjectFormat, types.ObjectFormatJWK) {
+		jwk, err := newPublicJWK(keybundle.Key)
+		if err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		content, err := jwk.marshal()
+		if err != nil {
+			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return []keyvaultObject{{content: string(content), version: version, metadata: metadata}}, nil
+	}
 	// for object type "key" the public key is written to the file in PEM format
 	switch *keybundle.Key.Kty {
 	case azkeys.JSONWebKeyTypeRSA, azkeys.JSONWebKeyTypeRSAHSM:
@@ -538,23 +1468,30 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 		}
 		var pemData []byte
 		pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-		return []keyvaultObject{{content: string(pemData), version: version}}, nil
+		return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
 	case azkeys.JSONWebKeyTypeEC, azkeys.JSONWebKeyTypeECHSM:
 		xb := keybundle.Key.X
 		yb := keybundle.Key.Y
 
-		crv, err := getCurve(*keybundle.Key.Crv)
-		if err != nil {
-			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
-		}
-		pKey := &ecdsa.PublicKey{
-			X:     new(big.Int).SetBytes(xb),
-			Y:     new(big.Int).SetBytes(yb),
-			Curve: crv,
-		}
-		derBytes, err := x509.MarshalPKIXPublicKey(pKey)
-		if err != nil {
-			return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		var derBytes []byte
+		if *keybundle.Key.Crv == azkeys.JSONWebKeyCurveNameP256K {
+			// crypto/x509 doesn't support secp256k1
+			if derBytes, err = marshalSecp256k1PublicKey(xb, yb); err != nil {
+				return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+		} else {
+			crv, err := getCurve(*keybundle.Key.Crv)
+			if err != nil {
+				return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			pKey := &ecdsa.PublicKey{
+				X:     new(big.Int).SetBytes(xb),
+				Y:     new(big.Int).SetBytes(yb),
+				Curve: crv,
+			}
+			if derBytes, err = x509.MarshalPKIXPublicKey(pKey); err != nil {
+				return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
 		}
 		pubKeyBlock := &pem.Block{
 			Type:  "PUBLIC KEY",
@@ -562,7 +1499,15 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 		}
 		var pemData []byte
 		pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-		return []keyvaultObject{{content: string(pemData), version: version}}, nil
+		return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
+	case azkeys.JSONWebKeyTypeOct, azkeys.JSONWebKeyTypeOctHSM:
+		// the vault never returns the key material of a symmetric key, an exportable key is released with releaseKey.
+		// Without releaseKey only the metadata file is written for the key if it's requested.
+		if metadata != nil {
+			return []keyvaultObject{{version: version, metadata: metadata, metadataOnly: true}}, nil
+		}
+		err := errors.Errorf("failed to get key. key material for key type '%s' is not exportable, set releaseKey to release an exportable key with a release policy or writeMetadata to only write the metadata of the key", *keybundle.Key.Kty)
+		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 	default:
 		err := errors.Errorf("failed to get key. key type '%s' currently not supported", *keybundle.Key.Kty)
 		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
@@ -592,7 +1537,11 @@ func (p *provider) getCertificate(ctx context.Context, kvClient KeyVault, kvObje
 	}
 	var pemData []byte
 	pemData = append(pemData, pem.EncodeToMemory(certBlock)...)
-	return []keyvaultObject{{content: string(pemData), version: version}}, nil
+	var metadata *objectMetadata
+	if kvObject.WriteMetadata {
+		metadata = newCertificateMetadata(certbundle, version)
+	}
+	return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
 }
 
 func wrapObjectTypeError(err error, objectType, objectName, objectVersion string) error {
@@ -668,6 +1617,43 @@ func getCurve(crv azkeys.JSONWebKeyCurveName) (elliptic.Curve, error) {
 	}
 }
 
+var (
+	oidPublicKeyECDSA      = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	// secp256k1P is the prime of the field of secp256k1
+	secp256k1P, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+)
+
+// marshalSecp256k1PublicKey returns the PKIX, ASN.1 DER form of a P-256K public key
+func marshalSecp256k1PublicKey(xb, yb []byte) ([]byte, error) {
+	x := new(big.Int).SetBytes(xb)
+	y := new(big.Int).SetBytes(yb)
+	// the point must be on the curve y² = x³ + 7
+	lhs := new(big.Int).Exp(y, big.NewInt(2), secp256k1P)
+	rhs := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+	rhs.Add(rhs, big.NewInt(7)).Mod(rhs, secp256k1P)
+	if x.Cmp(secp256k1P) >= 0 || y.Cmp(secp256k1P) >= 0 || lhs.Cmp(rhs) != 0 {
+		return nil, fmt.Errorf("invalid P-256K public key, point is not on the curve")
+	}
+
+	params, err := asn1.Marshal(oidNamedCurveSecp256k1)
+	if err != nil {
+		return nil, err
+	}
+	// the public key is the uncompressed point
+	point := make([]byte, 65)
+	point[0] = 4
+	x.FillBytes(point[1:33])
+	y.FillBytes(point[33:])
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: len(point) * 8},
+	})
+}
+
 func parsePrivateKey(block []byte) (interface{}, error) {
 	if key, err := x509.ParsePKCS1PrivateKey(block); err == nil {
 		return key, nil
@@ -728,6 +1714,51 @@ func formatKeyVaultObject(object *types.KeyVaultObject) {
 	}
 }
 
+// getCertKeySplitDefaults returns the settings of the parameters that split the certificates into cert and key
+// files. writeCertAndKeyInSeparateFiles defaults to the provider flag.
+func (p *provider) getCertKeySplitDefaults(attrib map[string]string) (types.KeyVaultObject, error) {
+	writeCertAndKeyInSeparateFiles, err := types.GetWriteCertAndKeyInSeparateFiles(attrib)
+	if err != nil {
+		return types.KeyVaultObject{}, fmt.Errorf("failed to parse writeCertAndKeyInSeparateFiles flag, error: %w", err)
+	}
+	if writeCertAndKeyInSeparateFiles == nil {
+		defaultValue := p.writeCertAndKeyInSeparateFiles
+		writeCertAndKeyInSeparateFiles = &defaultValue
+	}
+	writeCombinedCertAndKey, err := types.GetWriteCombinedCertAndKey(attrib)
+	if err != nil {
+		return types.KeyVaultObject{}, fmt.Errorf("failed to parse writeCombinedCertAndKey flag, error: %w", err)
+	}
+	return types.KeyVaultObject{
+		WriteCertAndKeyInSeparateFiles: writeCertAndKeyInSeparateFiles,
+		WriteCombinedCertAndKey:        writeCombinedCertAndKey,
+		CertFileSuffix:                 types.GetCertFileSuffix(attrib),
+		KeyFileSuffix:                  types.GetKeyFileSuffix(attrib),
+	}, nil
+}
+
+// applyCertKeySplitDefaults sets the cert and key split settings the secret doesn't set to the defaults
+func applyCertKeySplitDefaults(kv *types.KeyVaultObject, defaults types.KeyVaultObject) {
+	// only secrets return the cert and key of a certificate
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return
+	}
+	if kv.WriteCertAndKeyInSeparateFiles == nil {
+		kv.WriteCertAndKeyInSeparateFiles = defaults.WriteCertAndKeyInSeparateFiles
+	}
+	// the combined cert and key can only be skipped for objects with the cert and key in separate files
+	if kv.WriteCombinedCertAndKey == nil && kv.IsWritingCertAndKeyInSeparateFiles(false) &&
+		!strings.EqualFold(kv.ObjectFormat, types.ObjectFormatPFX) && !isKeystoreObject(*kv) {
+		kv.WriteCombinedCertAndKey = defaults.WriteCombinedCertAndKey
+	}
+	if kv.CertFileSuffix == "" {
+		kv.CertFileSuffix = defaults.CertFileSuffix
+	}
+	if kv.KeyFileSuffix == "" {
+		kv.KeyFileSuffix = defaults.KeyFileSuffix
+	}
+}
+
 type node struct {
 	cert     *x509.Certificate
 	parent   *node
@@ -736,8 +1767,27 @@ type node struct {
 
 // implementation xref: https://social.technet.microsoft.com/wiki/contents/articles/3147.pki-certificate-chaining-engine-cce.aspx#Building_the_Certificate_Chain
 func fetchCertChains(data []byte) ([]byte, error) {
-	var newCertChain []*x509.Certificate
 	var pemData []byte
+	newCertChain, certs, err := buildCertChain(data, false)
+	if err != nil {
+		return pemData, err
+	}
+
+	if len(certs) != len(newCertChain) {
+		klog.Warning("certificate chain is not complete due to missing intermediate/root certificates in the cert from key vault")
+		// if we're unable to construct the full chain, return the original order we got from the key vault
+		return data, nil
+	}
+
+	return encodeCertificates(newCertChain), nil
+}
+
+// buildCertChain returns the chain from the leaf certificate in the PEM data to the root and all the
+// certificates in the data. The chain has fewer certificates than the data if it's incomplete. The leaf
+// is the first certificate that isn't the issuer of another one, or the first one that isn't also
+// self-signed when skipSelfSignedLeaves is set, so a root left over from an incomplete chain isn't
+// taken as the leaf.
+func buildCertChain(data []byte, skipSelfSignedLeaves bool) (chain []*x509.Certificate, certs []*x509.Certificate, err error) {
 	nodes := make([]*node, 0)
 
 	currData := data
@@ -752,18 +1802,19 @@ func fetchCertChains(data []byte) ([]byte, error) {
 		}
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err != nil {
-			return pemData, err
+			return nil, nil, err
 		}
 		// this should not be the case because ParseCertificate should return a non nil
 		// certificate when there is no error.
 		if cert == nil {
-			return pemData, fmt.Errorf("certificate is nil")
+			return nil, nil, fmt.Errorf("certificate is nil")
 		}
 		nodes = append(nodes, &node{
 			cert:     cert,
 			parent:   nil,
 			isParent: false,
 		})
+		certs = append(certs, cert)
 	}
 
 	// at the end of this computation, the output will be a single linked list
@@ -797,13 +1848,18 @@ func fetchCertChains(data []byte) ([]byte, error) {
 		if !nodes[i].isParent {
 			// this is the leaf node as it's not a parent for any other node
 			// TODO (aramase) handle errors if there are more than 1 leaf nodes
-			leaf = nodes[i]
-			break
+			if leaf == nil {
+				leaf = nodes[i]
+			}
+			if !skipSelfSignedLeaves || !bytes.Equal(nodes[i].cert.RawSubject, nodes[i].cert.RawIssuer) {
+				leaf = nodes[i]
+				break
+			}
 		}
 	}
 
 	if leaf == nil {
-		return nil, fmt.Errorf("no leaf found")
+		return nil, nil, fmt.Errorf("no leaf found")
 	}
 
 	processedNodes := 0
@@ -812,26 +1868,25 @@ func fetchCertChains(data []byte) ([]byte, error) {
 		processedNodes++
 		// ensure we aren't stuck in a cyclic loop
 		if processedNodes > len(nodes) {
-			return pemData, fmt.Errorf("constructing chain resulted in cycle")
+			return nil, nil, fmt.Errorf("constructing chain resulted in cycle")
 		}
-		newCertChain = append(newCertChain, leaf.cert)
+		chain = append(chain, leaf.cert)
 		leaf = leaf.parent
 	}
+	return chain, certs, nil
+}
 
-	if len(nodes) != len(newCertChain) {
-		klog.Warning("certificate chain is not complete due to missing intermediate/root certificates in the cert from key vault")
-		// if we're unable to construct the full chain, return the original order we got from the key vault
-		return data, nil
-	}
-
-	for _, cert := range newCertChain {
+// encodeCertificates returns the certificates in PEM format
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var pemData []byte
+	for _, cert := range certs {
 		b := &pem.Block{
 			Type:  types.CertificateType,
 			Bytes: cert.Raw,
 		}
 		pemData = append(pemData, pem.EncodeToMemory(b)...)
 	}
-	return pemData, nil
+	return pemData
 }
 
 // splitCertAndKey takes the given data and splits it into cert and key
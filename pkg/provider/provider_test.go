@@ -6,10 +6,15 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -17,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
@@ -27,6 +33,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"k8s.io/klog/v2"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/fallback"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
@@ -1122,6 +1131,328 @@ fpTPteqfpl8iGQIhAOo8tpUYiREVSYZu130fN0Gvy4WmJMFAi7JrVeSnZ7uP
 	}
 }
 
+func TestGetManagedHSMVaultURL(t *testing.T) {
+	testEnvs := []string{"AZUREPUBLICCLOUD", "AZURECHINACLOUD", "AZUREGERMANCLOUD", "AZUREUSGOVERNMENTCLOUD"}
+	hsmDNSSuffix := []string{"managedhsm.azure.net", "managedhsm.azure.cn", "", "managedhsm.usgovcloudapi.net"}
+	hsmResource := []string{"https://managedhsm.azure.net", "https://managedhsm.azure.cn", "", "https://managedhsm.usgovcloudapi.net"}
+
+	for idx := range testEnvs {
+		azCloudEnv, err := azure.EnvironmentFromName(testEnvs[idx])
+		if err != nil {
+			t.Fatalf("Error parsing cloud environment %v", err)
+		}
+		mc := &mountConfig{keyvaultName: "testhsm", vaultType: types.VaultTypeManagedHSM, azureCloudEnvironment: azCloudEnv}
+		vaultURL, err := mc.getVaultURL()
+		if hsmDNSSuffix[idx] == "" {
+			if err == nil {
+				t.Fatalf("expected error for cloud %s without managed HSM", testEnvs[idx])
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("getVaultURL() = %v, want nil", err)
+		}
+		expectedURL := "https://testhsm." + hsmDNSSuffix[idx] + "/"
+		if *vaultURL != expectedURL {
+			t.Fatalf("expected vault url: %s, got: %s", expectedURL, *vaultURL)
+		}
+		if resource := mc.getResource(types.VaultTypeManagedHSM); resource != hsmResource[idx] {
+			t.Fatalf("expected resource: %s, got: %s", hsmResource[idx], resource)
+		}
+	}
+}
+
+func TestGetObjectVaultType(t *testing.T) {
+	cases := []struct {
+		desc              string
+		vaultType         string
+		object            types.KeyVaultObject
+		expectedVaultType string
+	}{
+		{
+			desc:              "default vault type",
+			object:            types.KeyVaultObject{ObjectName: "key1"},
+			expectedVaultType: types.VaultTypeKeyVault,
+		},
+		{
+			desc:              "vault type from the parameters",
+			vaultType:         "ManagedHSM",
+			object:            types.KeyVaultObject{ObjectName: "key1"},
+			expectedVaultType: types.VaultTypeManagedHSM,
+		},
+		{
+			desc:              "vault type from the object",
+			vaultType:         types.VaultTypeManagedHSM,
+			object:            types.KeyVaultObject{ObjectName: "key1", VaultType: "keyvault"},
+			expectedVaultType: types.VaultTypeKeyVault,
+		},
+		{
+			desc:              "vault type detected from the vault uri",
+			object:            types.KeyVaultObject{ObjectName: "key1", VaultURI: "https://testhsm.managedhsm.azure.net/"},
+			expectedVaultType: types.VaultTypeManagedHSM,
+		},
+		{
+			desc:              "key vault uri ignores the vault type from the parameters",
+			vaultType:         types.VaultTypeManagedHSM,
+			object:            types.KeyVaultObject{ObjectName: "key1", VaultURI: "https://testkv.vault.azure.net/"},
+			expectedVaultType: types.VaultTypeKeyVault,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			mc := &mountConfig{vaultType: tc.vaultType, azureCloudEnvironment: azure.PublicCloud}
+			if actual := mc.getObjectVaultType(tc.object); actual != tc.expectedVaultType {
+				t.Fatalf("expected vault type: %s, got: %s", tc.expectedVaultType, actual)
+			}
+		})
+	}
+}
+
+func TestGetClientOptions(t *testing.T) {
+	mc := &mountConfig{
+		vaultURI:              "https://kv.privatelink.contoso.com:8443",
+		vaultCABundle:         "ca",
+		vaultServerName:       "kv.vault.azure.net",
+		azureCloudEnvironment: azure.PublicCloud,
+	}
+
+	cases := []struct {
+		desc     string
+		vaultURI string
+		expected *ClientOptions
+	}{
+		{
+			desc:     "vault uri from the parameters",
+			vaultURI: "https://kv.privatelink.contoso.com:8443/",
+			expected: &ClientOptions{CABundle: []byte("ca"), ServerName: "kv.vault.azure.net", DisableChallengeResourceVerification: true},
+		},
+		{
+			desc:     "key vault of an object",
+			vaultURI: "https://otherkv.vault.azure.net/",
+			expected: &ClientOptions{},
+		},
+		{
+			desc:     "managed HSM of an object",
+			vaultURI: "https://hsm.managedhsm.azure.net/",
+			expected: &ClientOptions{},
+		},
+		{
+			desc:     "custom vault uri of an object",
+			vaultURI: "https://localhost:8443/",
+			expected: &ClientOptions{DisableChallengeResourceVerification: true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, mc.getClientOptions(tc.vaultURI))
+		})
+	}
+}
+
+func TestIsAllowedVaultHost(t *testing.T) {
+	allowedHosts := []string{"kv.contoso.com", "*.privatelink.contoso.com"}
+
+	cases := []struct {
+		vaultURI string
+		expected bool
+	}{
+		{vaultURI: "https://kv.contoso.com/", expected: true},
+		{vaultURI: "https://KV.contoso.com:8443/", expected: true},
+		{vaultURI: "https://kv.privatelink.contoso.com/", expected: true},
+		{vaultURI: "https://privatelink.contoso.com/", expected: false},
+		{vaultURI: "https://other.contoso.com/", expected: false},
+		{vaultURI: "https://kv.contoso.com.evil.com/", expected: false},
+		{vaultURI: "https://evilprivatelink.contoso.com/", expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.vaultURI, func(t *testing.T) {
+			if actual := isAllowedVaultHost(allowedHosts, tc.vaultURI); actual != tc.expected {
+				t.Errorf("isAllowedVaultHost() = %v, want %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInitializeKvClientAllowedVaultHosts(t *testing.T) {
+	p := &provider{
+		reporter:          metrics.NewStatsReporter(),
+		credentialCache:   newLRUCache(10, time.Hour),
+		clientCache:       newLRUCache(10, time.Hour),
+		inflight:          newFlightGroup(),
+		allowedVaultHosts: []string{"kv.contoso.com"},
+	}
+	mc := &mountConfig{
+		azureCloudEnvironment: azure.PublicCloud,
+		authConfig:            auth.Config{IdentityMode: auth.IdentityModeVMManagedIdentity},
+		tenantID:              "tid",
+	}
+	ctx := testContext(t)
+
+	if _, err := p.initializeKvClient(ctx, mc, "https://kv.contoso.com/", types.VaultTypeKeyVault); err != nil {
+		t.Errorf("initializeKvClient() = %v, want nil for an allowed vault host", err)
+	}
+	if _, err := p.initializeKvClient(ctx, mc, "https://kv.vault.azure.net/", types.VaultTypeKeyVault); err != nil {
+		t.Errorf("initializeKvClient() = %v, want nil for a vault in the cloud", err)
+	}
+	_, err := p.initializeKvClient(ctx, mc, "https://attacker.example.com/", types.VaultTypeKeyVault)
+	if err == nil || !strings.Contains(err.Error(), "is not in the allowed vault hosts of the provider") {
+		t.Errorf("initializeKvClient() = %v, want error for a vault host that isn't allowed", err)
+	}
+}
+
+func TestGetKeyOctHSM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kty := azkeys.JSONWebKeyTypeOctHSM
+	kid := azkeys.ID("https://testhsm.managedhsm.azure.net/keys/key1/v1")
+	kvObject := types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey}
+
+	// the vault doesn't return the key material of a symmetric key
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{Key: &azkeys.JSONWebKey{KID: &kid, Kty: &kty}}, nil)
+	_, err := p.getKey(testContext(t), kvClient, kvObject)
+	if err == nil || !strings.Contains(err.Error(), "key material for key type 'oct-HSM' is not exportable") {
+		t.Fatalf("getKey() = %v, want not exportable error", err)
+	}
+
+	// only the metadata is written if it's requested
+	octKty := azkeys.JSONWebKeyTypeOct
+	kvObject.WriteMetadata = true
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{Key: &azkeys.JSONWebKey{KID: &kid, Kty: &octKty}}, nil)
+	result, err := p.getKey(testContext(t), kvClient, kvObject)
+	if err != nil {
+		t.Fatalf("getKey() = %v, want nil", err)
+	}
+	assert.Equal(t, []keyvaultObject{{version: "v1", metadata: &objectMetadata{Version: "v1", KeyType: "oct"}, metadataOnly: true}}, result)
+}
+
+func TestGetKeyP256K(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kty := azkeys.JSONWebKeyTypeECHSM
+	crv := azkeys.JSONWebKeyCurveNameP256K
+	kid := azkeys.ID("https://test.vault.azure.net/keys/key1/v1")
+	kvObject := types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey}
+	// the generator point of secp256k1
+	x, _ := hex.DecodeString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	y, _ := hex.DecodeString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{Key: &azkeys.JSONWebKey{KID: &kid, Kty: &kty, Crv: &crv, X: x, Y: y}}, nil)
+	result, err := p.getKey(testContext(t), kvClient, kvObject)
+	if err != nil {
+		t.Fatalf("getKey() = %v, want nil", err)
+	}
+	block, _ := pem.Decode([]byte(result[0].content))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("getKey() content = %q, want PUBLIC KEY PEM", result[0].content)
+	}
+	var spki struct {
+		Algorithm struct {
+			Algorithm asn1.ObjectIdentifier
+			Curve     asn1.ObjectIdentifier
+		}
+		PublicKey asn1.BitString
+	}
+	if _, err = asn1.Unmarshal(block.Bytes, &spki); err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	assert.Equal(t, "1.2.840.10045.2.1", spki.Algorithm.Algorithm.String())
+	assert.Equal(t, "1.3.132.0.10", spki.Algorithm.Curve.String())
+	assert.Equal(t, append(append([]byte{4}, x...), y...), spki.PublicKey.Bytes)
+
+	// the point must be on the curve
+	kvClient.EXPECT().GetKey(gomock.Any(), "key1", "").Return(&azkeys.KeyBundle{Key: &azkeys.JSONWebKey{KID: &kid, Kty: &kty, Crv: &crv, X: x, Y: x}}, nil)
+	_, err = p.getKey(testContext(t), kvClient, kvObject)
+	if err == nil || !strings.Contains(err.Error(), "invalid P-256K public key, point is not on the curve") {
+		t.Fatalf("getKey() = %v, want point is not on the curve error", err)
+	}
+}
+
+func TestGetObjectVaultURL(t *testing.T) {
+	mc := &mountConfig{keyvaultName: "testKV", azureCloudEnvironment: azure.PublicCloud}
+
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		keyvault    string
+		vaultURI    string
+		expectedURL string
+		expectedErr bool
+	}{
+		{
+			desc:        "object without vault uses the vault uri from the parameters",
+			object:      types.KeyVaultObject{ObjectName: "secret1"},
+			vaultURI:    "https://kv.privatelink.contoso.com:8443",
+			expectedURL: "https://kv.privatelink.contoso.com:8443/",
+		},
+		{
+			desc:        "object without vault uses the vault from the parameters",
+			object:      types.KeyVaultObject{ObjectName: "secret1"},
+			keyvault:    "testKV",
+			expectedURL: "https://testKV.vault.azure.net/",
+		},
+		{
+			desc:        "object without vault and no vault in the parameters",
+			object:      types.KeyVaultObject{ObjectName: "secret1"},
+			expectedErr: true,
+		},
+		{
+			desc:        "object with keyvault name",
+			object:      types.KeyVaultObject{ObjectName: "secret1", KeyVaultName: "otherKV"},
+			keyvault:    "testKV",
+			expectedURL: "https://otherKV.vault.azure.net/",
+		},
+		{
+			desc:        "object with invalid keyvault name",
+			object:      types.KeyVaultObject{ObjectName: "secret1", KeyVaultName: "kv"},
+			expectedErr: true,
+		},
+		{
+			desc:        "object with vault uri",
+			object:      types.KeyVaultObject{ObjectName: "secret1", VaultURI: "https://otherKV.vault.azure.net"},
+			keyvault:    "testKV",
+			expectedURL: "https://otherKV.vault.azure.net/",
+		},
+		{
+			desc:        "object with vault uri that isn't https",
+			object:      types.KeyVaultObject{ObjectName: "secret1", VaultURI: "http://otherKV.vault.azure.net/"},
+			expectedErr: true,
+		},
+		{
+			desc:        "object with relative vault uri",
+			object:      types.KeyVaultObject{ObjectName: "secret1", VaultURI: "otherKV.vault.azure.net"},
+			expectedErr: true,
+		},
+		{
+			desc:        "object with vault uri with path",
+			object:      types.KeyVaultObject{ObjectName: "secret1", VaultURI: "https://otherKV.vault.azure.net/secrets/secret1"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			mc.keyvaultName = tc.keyvault
+			mc.vaultURI = tc.vaultURI
+			vaultURL, err := mc.getObjectVaultURL(tc.object)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && tc.expectedURL != *vaultURL {
+				t.Fatalf("expected vault url: %s, got: %s", tc.expectedURL, *vaultURL)
+			}
+		})
+	}
+}
+
 func TestGetSecretsStoreObjectContent(t *testing.T) {
 	cases := []struct {
 		desc        string
@@ -1144,11 +1475,134 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 		{
 			desc: "keyvault name not provided",
 			parameters: map[string]string{
+				"tenantId":                         "tid",
+				"useVMManagedIdentity":             "true",
 				"csi.storage.k8s.io/pod.name":      "pod1",
 				"csi.storage.k8s.io/pod.namespace": "ns1",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
 			},
 			expectedErr: "keyvaultName is not provided",
 		},
+		{
+			desc: "keyvault name and vault uri provided for object",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"tenantId":                         "tid",
+				"useVMManagedIdentity":             "true",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          keyvaultName: otherKV
+          vaultURI: https://otherKV.vault.azure.net/`,
+			},
+			expectedErr: "keyvaultName and vaultURI are mutually exclusive",
+		},
+		{
+			desc: "invalid vault uri provided for object",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"tenantId":                         "tid",
+				"useVMManagedIdentity":             "true",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          vaultURI: http://otherKV.vault.azure.net/`,
+			},
+			expectedErr: `failed to get objectType:secret, objectName:secret1, objectVersion:: failed to get vault: Invalid vault URI: "http://otherKV.vault.azure.net/", must be an absolute https URI`,
+		},
+		{
+			desc: "keyvault name and vault uri provided",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"vaultURI":                         "https://testKV.vault.azure.net/",
+				"tenantId":                         "tid",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+			},
+			expectedErr: "keyvaultName and vaultURI are mutually exclusive",
+		},
+		{
+			desc: "invalid vault uri",
+			parameters: map[string]string{
+				"vaultURI":                         "testKV.vault.azure.net",
+				"tenantId":                         "tid",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+			},
+			expectedErr: `failed to parse vaultURI, error: Invalid vault URI: "testKV.vault.azure.net", must be an absolute https URI`,
+		},
+		{
+			desc: "vault server name without vault uri",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"vaultServerName":                  "testKV.vault.azure.net",
+				"tenantId":                         "tid",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+			},
+			expectedErr: "vaultCABundle and vaultServerName are only supported with vaultURI",
+		},
+		{
+			desc: "invalid template",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"tenantId":                         "tid",
+				"useVMManagedIdentity":             "true",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+				"templates": `
+      array:
+        - |
+          path: app.conf
+          template: '{{ .Objects.secret1 '`,
+			},
+			expectedErr: "failed to parse template app.conf",
+		},
+		{
+			desc: "invalid vault type",
+			parameters: map[string]string{
+				"keyvaultName":                     "testKV",
+				"tenantId":                         "tid",
+				"vaultType":                        "hsm",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+			},
+			expectedErr: "invalid vaultType: hsm, should be keyvault or managedHSM",
+		},
+		{
+			desc: "managed HSM only supports keys",
+			parameters: map[string]string{
+				"keyvaultName":                     "testHSM",
+				"tenantId":                         "tid",
+				"vaultType":                        "managedHSM",
+				"useVMManagedIdentity":             "true",
+				"csi.storage.k8s.io/pod.name":      "pod1",
+				"csi.storage.k8s.io/pod.namespace": "ns1",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+			},
+			expectedErr: "vaultType managedHSM only supported for objectType: key",
+		},
 		{
 			desc: "tenantID not provided",
 			parameters: map[string]string{
@@ -1260,7 +1714,7 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
           objectFormat: pkcs
           objectVersion: ""`,
 			},
-			expectedErr: `failed to get objectType:secret, objectName:secret1, objectVersion:: invalid objectFormat: pkcs, should be PEM or PFX`,
+			expectedErr: `failed to get objectType:secret, objectName:secret1, objectVersion:: invalid objectFormat: pkcs, should be PEM, PFX, JWK, PKCS12 or JKS`,
 		},
 		{
 			desc: "invalid object encoding",
@@ -1304,7 +1758,7 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			p := NewProvider(false, false, azure.PublicCloud)
+			p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
 
 			_, err := p.GetSecretsStoreObjectContent(testContext(t), tc.parameters, tc.secrets, 0420)
 			if len(tc.expectedErr) > 0 {
@@ -1735,34 +2189,175 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 				},
 			},
 		},
-	}
-
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	for _, tc := range cases {
-		t.Run(tc.desc, func(t *testing.T) {
-			ctx := testContext(t)
-
-			p := &provider{writeCertAndKeyInSeparateFiles: tc.writeCertAndKeyInSeparateFiles}
-			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
-			kvClient.EXPECT().GetSecret(ctx, "secret1", "").Return(
-				tc.initKeyVaultSecret, nil,
-			)
-
-			objs, err := p.getSecret(ctx, kvClient, tc.inputKeyVaultObject)
-			if err != nil {
-				t.Fatalf("getSecret() = %v, want nil", err)
-			}
-			if !reflect.DeepEqual(objs, tc.expectedKeyVaultObject) {
-				t.Errorf("getSecret() = \n%v, want \n%v", objs, tc.expectedKeyVaultObject)
-			}
-		})
-	}
-}
-
-func TestGetSecretError(t *testing.T) {
-	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+		{
+			desc: "write cert and key in separate files for the object with suffixes and without the combined file",
+			initKeyVaultSecret: &azsecrets.SecretBundle{
+				ID:          &id,
+				Value:       to.StringPtr(testPFX),
+				Kid:         to.StringPtr("https://testvault.vault.azure.net/keys/secrets/secret1/v1"),
+				ContentType: to.StringPtr("application/x-pkcs12"),
+			},
+			inputKeyVaultObject: types.KeyVaultObject{
+				ObjectName:                     "secret1",
+				WriteCertAndKeyInSeparateFiles: to.BoolPtr(true),
+				WriteCombinedCertAndKey:        to.BoolPtr(false),
+				CertFileSuffix:                 ".pem",
+				KeyFileSuffix:                  "-key.pem",
+			},
+			expectedKeyVaultObject: []keyvaultObject{
+				{
+					content:        testCert,
+					version:        "v1",
+					fileNameSuffix: ".pem",
+				},
+				{
+					content:        testPrivateKey,
+					version:        "v1",
+					fileNameSuffix: "-key.pem",
+				},
+			},
+		},
+		{
+			desc: "object disables writing cert and key in separate files",
+			initKeyVaultSecret: &azsecrets.SecretBundle{
+				ID:          &id,
+				Value:       to.StringPtr(testPFX),
+				Kid:         to.StringPtr("https://testvault.vault.azure.net/keys/secrets/secret1/v1"),
+				ContentType: to.StringPtr("application/x-pkcs12"),
+			},
+			inputKeyVaultObject: types.KeyVaultObject{
+				ObjectName:                     "secret1",
+				WriteCertAndKeyInSeparateFiles: to.BoolPtr(false),
+			},
+			writeCertAndKeyInSeparateFiles: true,
+			expectedKeyVaultObject: []keyvaultObject{
+				{
+					content: testPrivateKey + testCert,
+					version: "v1",
+				},
+			},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := testContext(t)
+
+			p := &provider{writeCertAndKeyInSeparateFiles: tc.writeCertAndKeyInSeparateFiles}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetSecret(ctx, "secret1", "").Return(
+				tc.initKeyVaultSecret, nil,
+			)
+
+			objs, err := p.getSecret(ctx, kvClient, tc.inputKeyVaultObject)
+			if err != nil {
+				t.Fatalf("getSecret() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(objs, tc.expectedKeyVaultObject) {
+				t.Errorf("getSecret() = \n%v, want \n%v", objs, tc.expectedKeyVaultObject)
+			}
+		})
+	}
+}
+
+func TestApplyCertKeySplitDefaults(t *testing.T) {
+	p := &provider{writeCertAndKeyInSeparateFiles: true}
+
+	defaults, err := p.getCertKeySplitDefaults(map[string]string{"writeCombinedCertAndKey": "false", "keyFileSuffix": ".pem"})
+	if err != nil {
+		t.Fatalf("getCertKeySplitDefaults() = %v, want nil", err)
+	}
+	secret := types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, CertFileSuffix: ".cer"}
+	applyCertKeySplitDefaults(&secret, defaults)
+	assert.Equal(t, types.KeyVaultObject{
+		ObjectName:                     "secret1",
+		ObjectType:                     types.VaultObjectTypeSecret,
+		WriteCertAndKeyInSeparateFiles: to.BoolPtr(true),
+		WriteCombinedCertAndKey:        to.BoolPtr(false),
+		CertFileSuffix:                 ".cer",
+		KeyFileSuffix:                  ".pem",
+	}, secret)
+
+	// the parameter overrides the provider flag
+	defaults, err = p.getCertKeySplitDefaults(map[string]string{"writeCertAndKeyInSeparateFiles": "false"})
+	if err != nil {
+		t.Fatalf("getCertKeySplitDefaults() = %v, want nil", err)
+	}
+	secret = types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret}
+	applyCertKeySplitDefaults(&secret, defaults)
+	assert.False(t, secret.IsWritingCertAndKeyInSeparateFiles(true))
+
+	// only secrets are split
+	key := types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey}
+	applyCertKeySplitDefaults(&key, defaults)
+	assert.Equal(t, types.KeyVaultObject{ObjectName: "key1", ObjectType: types.VaultObjectTypeKey}, key)
+
+	if _, err = p.getCertKeySplitDefaults(map[string]string{"writeCombinedCertAndKey": "no"}); err == nil {
+		t.Fatalf("getCertKeySplitDefaults() = nil, want error")
+	}
+
+	// the combined cert and key is only skipped by default for the objects it's valid for
+	defaults, err = p.getCertKeySplitDefaults(map[string]string{"writeCombinedCertAndKey": "false"})
+	if err != nil {
+		t.Fatalf("getCertKeySplitDefaults() = %v, want nil", err)
+	}
+	for _, object := range []types.KeyVaultObject{
+		{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectFormat: types.ObjectFormatPFX},
+		{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectFormat: types.ObjectFormatPKCS12, ObjectPasswordSecret: "password1"},
+		{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, WriteCertAndKeyInSeparateFiles: to.BoolPtr(false)},
+	} {
+		applyCertKeySplitDefaults(&object, defaults)
+		assert.True(t, object.IsWritingCombinedCertAndKey())
+		assert.NoError(t, validate(object))
+	}
+}
+
+func TestGetSecretMetadataWithKeyFile(t *testing.T) {
+	_, _, content := newTestCertChain(t)
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/cert1/v1")
+	secret := &azsecrets.SecretBundle{
+		ID:          &id,
+		Value:       to.StringPtr(content),
+		Kid:         to.StringPtr("https://test.vault.azure.net/keys/cert1/v1"),
+		ContentType: to.StringPtr(types.CertTypePem),
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := &provider{}
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "cert1", "").Return(secret, nil)
+
+	objs, err := p.getSecret(testContext(t), kvClient, types.KeyVaultObject{
+		ObjectName:                     "cert1",
+		ObjectType:                     types.VaultObjectTypeSecret,
+		WriteCertAndKeyInSeparateFiles: to.BoolPtr(true),
+		WriteCombinedCertAndKey:        to.BoolPtr(false),
+		WriteCertChainFiles:            true,
+		WriteMetadata:                  true,
+	})
+	if err != nil {
+		t.Fatalf("getSecret() = %v, want nil", err)
+	}
+	// the metadata is written with the key file, not with the last chain file
+	var suffixes []string
+	for _, obj := range objs {
+		suffixes = append(suffixes, obj.fileNameSuffix)
+		if obj.fileNameSuffix == types.DefaultKeyFileSuffix {
+			assert.Equal(t, &objectMetadata{Version: "v1", ContentType: types.CertTypePem}, obj.metadata)
+			continue
+		}
+		assert.Nil(t, obj.metadata)
+	}
+	assert.Equal(t, []string{".crt", ".key", ".leaf.pem", ".chain.pem", ".root.pem", ".fullchain.pem"}, suffixes)
+}
+
+func TestGetSecretError(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
 
 	cases := []struct {
 		desc                   string
@@ -1910,7 +2505,7 @@ func testContext(t *testing.T) context.Context {
 }
 
 func TestGetSecretsStoreObjectContent_IdentityBinding_MissingClientID(t *testing.T) {
-	p := NewProvider(false, false, azure.PublicCloud)
+	p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
 
 	attrib := map[string]string{
 		types.UseAzureTokenProxyParameter:      "true",
@@ -1934,7 +2529,7 @@ func TestGetSecretsStoreObjectContent_IdentityBinding_MissingClientID(t *testing
 }
 
 func TestGetSecretsStoreObjectContent_IdentityBinding_InvalidParameter(t *testing.T) {
-	p := NewProvider(false, false, azure.PublicCloud)
+	p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
 
 	attrib := map[string]string{
 		types.UseAzureTokenProxyParameter: "invalid-value",
@@ -1958,7 +2553,7 @@ func TestGetSecretsStoreObjectContent_IdentityBinding_InvalidParameter(t *testin
 }
 
 func TestGetSecretsStoreObjectContent_IdentityBinding_MissingServiceAccountToken(t *testing.T) {
-	p := NewProvider(false, false, azure.PublicCloud)
+	p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
 
 	attrib := map[string]string{
 		types.UseAzureTokenProxyParameter: "true",
@@ -1983,7 +2578,7 @@ func TestGetSecretsStoreObjectContent_IdentityBinding_MissingServiceAccountToken
 }
 
 func TestGetSecretsStoreObjectContent_MutualExclusivity(t *testing.T) {
-	p := NewProvider(false, false, azure.PublicCloud)
+	p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
 
 	attrib := map[string]string{
 		types.UsePodIdentityParameter:     "true",
@@ -2006,3 +2601,577 @@ func TestGetSecretsStoreObjectContent_MutualExclusivity(t *testing.T) {
 		t.Errorf("expected 'only one identity mode' error, got: %v", err)
 	}
 }
+
+func TestBuildAuthConfigUserAssignedIdentity(t *testing.T) {
+	resourceID := "/subscriptions/sid/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id"
+	cases := []struct {
+		desc        string
+		input       authConfigInput
+		expectedErr string
+	}{
+		{
+			desc:  "resource ID",
+			input: authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityResourceID: resourceID},
+		},
+		{
+			desc:  "object ID",
+			input: authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityObjectID: "oid"},
+		},
+		{
+			desc:        "client ID and resource ID",
+			input:       authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityID: "cid", userAssignedIdentityResourceID: resourceID},
+			expectedErr: "userAssignedIdentityID, userAssignedIdentityResourceID and userAssignedIdentityObjectID are mutually exclusive",
+		},
+		{
+			desc:        "resource ID and object ID",
+			input:       authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityResourceID: resourceID, userAssignedIdentityObjectID: "oid"},
+			expectedErr: "userAssignedIdentityID, userAssignedIdentityResourceID and userAssignedIdentityObjectID are mutually exclusive",
+		},
+		{
+			desc:        "object ID without useVMManagedIdentity",
+			input:       authConfigInput{identityMode: auth.IdentityModePodIdentity, userAssignedIdentityObjectID: "oid"},
+			expectedErr: "userAssignedIdentityResourceID and userAssignedIdentityObjectID are only supported with useVMManagedIdentity",
+		},
+		{
+			desc:        "invalid resource ID",
+			input:       authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityResourceID: "id"},
+			expectedErr: "invalid userAssignedIdentityResourceID: id, error: invalid resource ID: resource id 'id' must start with '/'",
+		},
+		{
+			desc:        "resource ID of another resource type",
+			input:       authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, userAssignedIdentityResourceID: "/subscriptions/sid/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv"},
+			expectedErr: "invalid userAssignedIdentityResourceID: /subscriptions/sid/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv, should be the resource ID of a Microsoft.ManagedIdentity/userAssignedIdentities",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, err := buildAuthConfig(tc.input)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("buildAuthConfig() = %v, want %s", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildAuthConfig() = %v, want nil", err)
+			}
+			assert.Equal(t, tc.input.userAssignedIdentityResourceID, config.UserAssignedIdentityResourceID)
+			assert.Equal(t, tc.input.userAssignedIdentityObjectID, config.UserAssignedIdentityObjectID)
+		})
+	}
+}
+
+func TestBuildAuthConfigWorkloadIdentityAudience(t *testing.T) {
+	claims := fmt.Sprintf(`{"aud":["api://AzureADTokenExchangeChina"],"exp":%d}`, time.Now().Add(time.Hour).Unix())
+	token := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+	saTokens := fmt.Sprintf(`{"api://AzureADTokenExchangeChina":{"token":%q,"expirationTimestamp":"2099-01-01T00:00:00Z"}}`, token)
+
+	config, err := buildAuthConfig(authConfigInput{
+		workloadIdentityClientID: "clientid",
+		workloadIdentityAudience: "api://AzureADTokenExchangeChina",
+		saTokens:                 saTokens,
+	})
+	if err != nil {
+		t.Fatalf("buildAuthConfig() = %v, want nil", err)
+	}
+	assert.Equal(t, token, config.ServiceAccountToken)
+
+	// the default audience isn't in the tokens
+	_, err = buildAuthConfig(authConfigInput{workloadIdentityClientID: "clientid", saTokens: saTokens})
+	assert.EqualError(t, err, "failed to parse workload identity tokens, error: token for audience api://AzureADTokenExchange not found, the tokenRequests of the secrets-store.csi.k8s.io CSIDriver have the audiences [api://AzureADTokenExchangeChina]")
+
+	// the token for the default audience is passed as is without checking its claims
+	defaultTokens := `{"api://AzureADTokenExchange":{"token":"opaque-token","expirationTimestamp":"2099-01-01T00:00:00Z"}}`
+	config, err = buildAuthConfig(authConfigInput{workloadIdentityClientID: "clientid", saTokens: defaultTokens})
+	if err != nil {
+		t.Fatalf("buildAuthConfig() = %v, want nil", err)
+	}
+	assert.Equal(t, "opaque-token", config.ServiceAccountToken)
+
+	// the token for the custom audience is checked
+	_, err = buildAuthConfig(authConfigInput{
+		workloadIdentityClientID: "clientid",
+		workloadIdentityAudience: "api://AzureADTokenExchange",
+		saTokens:                 defaultTokens,
+	})
+	assert.EqualError(t, err, "failed to parse workload identity tokens, error: service account token for audience api://AzureADTokenExchange is not a JWT")
+
+	_, err = buildAuthConfig(authConfigInput{workloadIdentityAudience: "api://AzureADTokenExchangeChina", saTokens: saTokens})
+	assert.EqualError(t, err, "workloadIdentityAudience requires clientID")
+
+	_, err = buildAuthConfig(authConfigInput{identityMode: auth.IdentityModeAzureTokenProxy, workloadIdentityClientID: "clientid", workloadIdentityAudience: "api://AzureADTokenExchangeChina", saTokens: saTokens})
+	assert.EqualError(t, err, "workloadIdentityAudience is not supported with useAzureTokenProxy")
+}
+
+func TestValidateCrossTenant(t *testing.T) {
+	cases := []struct {
+		desc        string
+		input       authConfigInput
+		expectedErr string
+	}{
+		{
+			desc:  "not set",
+			input: authConfigInput{identityMode: auth.IdentityModePodIdentity},
+		},
+		{
+			desc:  "cross tenant access",
+			input: authConfigInput{workloadIdentityClientID: "clientid", workloadIdentityTenantID: "htid", crossTenantClientID: "clientid2", crossTenantAudience: "api://AzureADTokenExchange"},
+		},
+		{
+			desc:        "without workload identity",
+			input:       authConfigInput{identityMode: auth.IdentityModeVMManagedIdentity, workloadIdentityClientID: "clientid", workloadIdentityTenantID: "htid", crossTenantClientID: "clientid2"},
+			expectedErr: "workloadIdentityTenantID, crossTenantClientID and crossTenantAudience are only supported with workload identity, clientID is required",
+		},
+		{
+			desc:        "without clientID",
+			input:       authConfigInput{workloadIdentityTenantID: "htid", crossTenantClientID: "clientid2"},
+			expectedErr: "workloadIdentityTenantID, crossTenantClientID and crossTenantAudience are only supported with workload identity, clientID is required",
+		},
+		{
+			desc:        "without crossTenantClientID",
+			input:       authConfigInput{workloadIdentityClientID: "clientid", workloadIdentityTenantID: "htid"},
+			expectedErr: "workloadIdentityTenantID and crossTenantClientID are required for cross tenant access",
+		},
+		{
+			desc:        "without workloadIdentityTenantID",
+			input:       authConfigInput{workloadIdentityClientID: "clientid", crossTenantClientID: "clientid2"},
+			expectedErr: "workloadIdentityTenantID and crossTenantClientID are required for cross tenant access",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateCrossTenant(tc.input)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContent_IdentityChainMutualExclusivity(t *testing.T) {
+	p := NewProvider(false, false, 1, 0, 0, nil, nil, KeyReleaseConfig{}, azure.PublicCloud)
+
+	attrib := map[string]string{
+		types.UseVMManagedIdentityParameter: "true",
+		types.IdentityChainParameter:        "workloadIdentity,vmManagedIdentity",
+		"tenantId":                          "test-tenant",
+		"keyvaultName":                      "test-vault",
+		"objects":                           "array:\n  - |\n    objectName: secret1\n    objectType: secret",
+		types.CSIAttributePodName:           "test-pod",
+		types.CSIAttributePodNamespace:      "default",
+	}
+
+	_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+	if err == nil || err.Error() != "identityChain is mutually exclusive with usePodIdentity, useVMManagedIdentity and useAzureTokenProxy" {
+		t.Errorf("expected identityChain mutually exclusive error, got: %v", err)
+	}
+}
+
+// kvClients returns the client for n objects fetched from the same vault
+func kvClients(kvClient KeyVault, n int) []KeyVault {
+	clients := make([]KeyVault, n)
+	for i := range clients {
+		clients[i] = kvClient
+	}
+	return clients
+}
+
+func TestFetchObjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	p := &provider{reporter: metrics.NewStatsReporter()}
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	var objects []types.KeyVaultObject
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("secret%d", i)
+		id := azsecrets.ID(fmt.Sprintf("https://test.vault.azure.net/secrets/%s/v%d", name, i))
+		// finish the objects in reverse order to ensure the result is still ordered
+		delay := time.Duration(10-i) * time.Millisecond
+		kvClient.EXPECT().GetSecret(gomock.Any(), name, "").DoAndReturn(
+			func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+				time.Sleep(delay)
+				return &azsecrets.SecretBundle{ID: &id, Value: to.StringPtr(name)}, nil
+			},
+		)
+		objects = append(objects, types.KeyVaultObject{ObjectName: name, ObjectType: types.VaultObjectTypeSecret})
+	}
+
+	files, err := p.fetchObjects(ctx, kvClients(kvClient, len(objects)), objects, 4, 0644, klog.ObjectRef{})
+	if err != nil {
+		t.Fatalf("fetchObjects() = %v, want nil", err)
+	}
+	if len(files) != len(objects) {
+		t.Fatalf("fetchObjects() returned %d files, want %d", len(files), len(objects))
+	}
+	for i, file := range files {
+		name := fmt.Sprintf("secret%d", i)
+		if file.Path != name || string(file.Content) != name || file.Version != fmt.Sprintf("v%d", i) {
+			t.Errorf("fetchObjects() file %d = %+v, want path and content %s", i, file, name)
+		}
+	}
+}
+
+func TestFetchObjectsMultipleVaults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	p := &provider{reporter: metrics.NewStatsReporter()}
+
+	kvClient1 := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient2 := mock_keyvault.NewMockKeyVault(ctrl)
+	id1 := azsecrets.ID("https://kv1.vault.azure.net/secrets/secret/v1")
+	id2 := azsecrets.ID("https://kv2.vault.azure.net/secrets/secret/v2")
+	kvClient1.EXPECT().GetSecret(gomock.Any(), "secret", "").Return(&azsecrets.SecretBundle{ID: &id1, Value: to.StringPtr("kv1")}, nil)
+	kvClient2.EXPECT().GetSecret(gomock.Any(), "secret", "").Return(&azsecrets.SecretBundle{ID: &id2, Value: to.StringPtr("kv2")}, nil)
+
+	objects := []types.KeyVaultObject{
+		{ObjectName: "secret", ObjectAlias: "secret1", ObjectType: types.VaultObjectTypeSecret},
+		{ObjectName: "secret", ObjectAlias: "secret2", ObjectType: types.VaultObjectTypeSecret, KeyVaultName: "kv2"},
+	}
+	files, err := p.fetchObjects(ctx, []KeyVault{kvClient1, kvClient2}, objects, 2, 0644, klog.ObjectRef{})
+	if err != nil {
+		t.Fatalf("fetchObjects() = %v, want nil", err)
+	}
+	expected := []types.SecretFile{
+		{Path: "secret1", Content: []byte("kv1"), FileMode: 0644, UID: "secret/secret", Version: "v1"},
+		{Path: "secret2", Content: []byte("kv2"), FileMode: 0644, UID: "kv2/secret/secret", Version: "v2"},
+	}
+	assert.Equal(t, expected, files)
+}
+
+func TestExpandObjects(t *testing.T) {
+	secrets := []types.KeyVaultObjectProperties{
+		{Name: "payments-b", Enabled: true, Tags: map[string]string{"env": "prod", "team": "payments"}},
+		{Name: "payments-a", Enabled: true, Tags: map[string]string{"env": "dev", "team": "payments"}},
+		{Name: "payments-disabled", Enabled: false, Tags: map[string]string{"env": "prod", "team": "payments"}},
+		{Name: "orders-a", Enabled: true, Tags: map[string]string{"env": "prod", "team": "orders"}},
+	}
+
+	cases := []struct {
+		desc            string
+		object          types.KeyVaultObject
+		expectedObjects []types.KeyVaultObject
+		expectedErr     string
+	}{
+		{
+			desc:   "object name prefix",
+			object: types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectType: types.VaultObjectTypeSecret},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "payments-a", ObjectType: types.VaultObjectTypeSecret},
+				{ObjectName: "payments-b", ObjectType: types.VaultObjectTypeSecret},
+			},
+		},
+		{
+			desc:   "object name regex with alias",
+			object: types.KeyVaultObject{ObjectNameRegex: "[a-z]+-a", ObjectAlias: "app", ObjectType: types.VaultObjectTypeSecret},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "orders-a", ObjectAlias: filepath.Join("app", "orders-a"), ObjectType: types.VaultObjectTypeSecret},
+				{ObjectName: "payments-a", ObjectAlias: filepath.Join("app", "payments-a"), ObjectType: types.VaultObjectTypeSecret},
+			},
+		},
+		{
+			desc:   "object tags",
+			object: types.KeyVaultObject{ObjectTags: "env=prod", ObjectType: types.VaultObjectTypeSecret},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "orders-a", ObjectType: types.VaultObjectTypeSecret},
+				{ObjectName: "payments-b", ObjectType: types.VaultObjectTypeSecret},
+			},
+		},
+		{
+			desc:   "object name prefix and tags",
+			object: types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectTags: "env=prod,team=payments", ObjectType: types.VaultObjectTypeSecret},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "payments-b", ObjectType: types.VaultObjectTypeSecret},
+			},
+		},
+		{
+			desc:   "object name regex must match the whole name",
+			object: types.KeyVaultObject{ObjectNameRegex: "payments", ObjectType: types.VaultObjectTypeSecret},
+		},
+		{
+			desc:        "more objects than the maximum",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectType: types.VaultObjectTypeSecret, MaxObjects: 1},
+			expectedErr: "failed to get objectType:secret, objectName:payments-*, objectVersion:: selected 2 objects, more than the maximum of 1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			p := &provider{reporter: metrics.NewStatsReporter()}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().ListSecrets(gomock.Any()).Return(secrets, nil)
+
+			// objects that don't select other objects are kept in order
+			object := types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret}
+			clients, objects, err := p.expandObjects(testContext(t), kvClients(kvClient, 2), []types.KeyVaultObject{object, tc.object})
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expandObjects() = %v, want %s", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandObjects() = %v, want nil", err)
+			}
+			assert.Equal(t, append([]types.KeyVaultObject{object}, tc.expectedObjects...), objects)
+			assert.Len(t, clients, len(objects))
+		})
+	}
+}
+
+func TestResolveTaggedObjectVersions(t *testing.T) {
+	now := time.Now()
+	versions := []types.KeyVaultObjectVersion{
+		{Version: "v1", Created: now.Add(-3 * time.Hour), Tags: map[string]string{"stage": "approved"}},
+		{Version: "v2", Created: now.Add(-2 * time.Hour), Tags: map[string]string{"stage": "approved"}},
+		{Version: "v3", Created: now.Add(-1 * time.Hour), Tags: map[string]string{"stage": "pending"}},
+		{Version: "v4", Created: now},
+	}
+
+	cases := []struct {
+		desc            string
+		object          types.KeyVaultObject
+		expectedObjects []types.KeyVaultObject
+		expectedErr     string
+	}{
+		{
+			desc:   "newest version with the tags",
+			object: types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=approved"},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=approved", ObjectVersion: "v2"},
+			},
+		},
+		{
+			desc:   "version history of the versions with the tags",
+			object: types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=approved", ObjectVersionHistory: 2},
+			expectedObjects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectAlias: filepath.Join("secret1", "0"), ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=approved", ObjectVersion: "v2", ObjectVersionHistory: 2},
+				{ObjectName: "secret1", ObjectAlias: filepath.Join("secret1", "1"), ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=approved", ObjectVersion: "v1", ObjectVersionHistory: 2},
+			},
+		},
+		{
+			desc:        "no version with the tags",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, ObjectVersionTags: "stage=released"},
+			expectedErr: "failed to get objectType:secret, objectName:secret1, objectVersion:: no enabled version with tags stage=released",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			p := &provider{reporter: metrics.NewStatsReporter()}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetSecretVersions(gomock.Any(), "secret1").Return(versions, nil)
+
+			objects, err := p.resolveObjectVersions(testContext(t), kvClient, tc.object)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("resolveObjectVersions() = %v, want %s", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveObjectVersions() = %v, want nil", err)
+			}
+			assert.Equal(t, tc.expectedObjects, objects)
+		})
+	}
+}
+
+func TestFetchObjectsError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	p := &provider{reporter: metrics.NewStatsReporter()}
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	// secret1 fails after secret2 so the error for secret1 is only deterministic
+	// if the errors are reported in the order the objects are defined
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret0/v1")
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret0", "").Return(&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("a")}, nil)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").DoAndReturn(
+		func(context.Context, string, string) (*azsecrets.SecretBundle, error) {
+			time.Sleep(20 * time.Millisecond)
+			return nil, errors.New("secret1 error")
+		},
+	)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret2", "").Return(nil, errors.New("secret2 error"))
+	// secret3 is only dispatched if a worker frees up before the first error is observed
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret3", "").Return(&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("b")}, nil).AnyTimes()
+
+	objects := []types.KeyVaultObject{
+		{ObjectName: "secret0", ObjectType: types.VaultObjectTypeSecret},
+		{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret},
+		{ObjectName: "secret2", ObjectType: types.VaultObjectTypeSecret},
+		{ObjectName: "secret3", ObjectType: types.VaultObjectTypeSecret},
+	}
+
+	_, err := p.fetchObjects(ctx, kvClients(kvClient, len(objects)), objects, 3, 0644, klog.ObjectRef{})
+	if err == nil || !strings.Contains(err.Error(), "secret1 error") {
+		t.Fatalf("fetchObjects() = %v, want secret1 error", err)
+	}
+}
+
+func TestFetchObjectsContextCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &provider{reporter: metrics.NewStatsReporter()}
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret0", "").DoAndReturn(
+		func(ctx context.Context, _, _ string) (*azsecrets.SecretBundle, error) {
+			cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	)
+
+	objects := []types.KeyVaultObject{
+		{ObjectName: "secret0", ObjectType: types.VaultObjectTypeSecret},
+		{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret},
+	}
+
+	_, err := p.fetchObjects(ctx, kvClients(kvClient, len(objects)), objects, 1, 0644, klog.ObjectRef{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("fetchObjects() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestUseFallback(t *testing.T) {
+	dir := t.TempDir()
+	store, err := fallback.New(filepath.Join(dir, "cache"), filepath.Join(dir, "key"), time.Hour)
+	if err != nil {
+		t.Fatalf("fallback.New() = %v, want nil", err)
+	}
+	p := &provider{reporter: metrics.NewStatsReporter(), fallbackStore: store}
+	ctx := testContext(t)
+
+	files := []types.SecretFile{{Path: "secret1", Content: []byte("value"), UID: "secret/secret1", Version: "v1"}}
+	if _, err = p.useFallback(ctx, "key1", files, nil, klog.ObjectRef{}); err != nil {
+		t.Fatalf("useFallback() = %v, want nil", err)
+	}
+
+	cases := []struct {
+		desc        string
+		key         string
+		fetchErr    error
+		expectedErr bool
+	}{
+		{
+			desc:     "key vault unavailable",
+			key:      "key1",
+			fetchErr: &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+		},
+		{
+			desc:     "key vault throttled",
+			key:      "key1",
+			fetchErr: wrapObjectTypeError(&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, "secret", "secret1", ""),
+		},
+		{
+			desc:     "network error",
+			key:      "key1",
+			fetchErr: &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+		},
+		{
+			desc:     "key vault too slow to respond",
+			key:      "key1",
+			fetchErr: fmt.Errorf("failed to list secrets, error: %w", context.DeadlineExceeded),
+		},
+		{
+			desc:        "forbidden",
+			key:         "key1",
+			fetchErr:    &azcore.ResponseError{StatusCode: http.StatusForbidden},
+			expectedErr: true,
+		},
+		{
+			desc:        "not found",
+			key:         "key1",
+			fetchErr:    &azcore.ResponseError{StatusCode: http.StatusNotFound},
+			expectedErr: true,
+		},
+		{
+			desc:        "no entry for the mount",
+			key:         "key2",
+			fetchErr:    &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := p.useFallback(ctx, tc.key, nil, tc.fetchErr, klog.ObjectRef{})
+			if tc.expectedErr {
+				if !errors.Is(err, tc.fetchErr) {
+					t.Fatalf("useFallback() = %v, want %v", err, tc.fetchErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("useFallback() = %v, want nil", err)
+			}
+			if len(got) != 1 || string(got[0].Content) != "value" || got[0].Version != "v1-fallback" {
+				t.Errorf("useFallback() = %+v, want fallback files", got)
+			}
+		})
+	}
+}
+
+func TestFallbackCacheKey(t *testing.T) {
+	attrib := map[string]string{
+		"keyvaultName":                         "kv",
+		"objects":                              "array: []",
+		types.CSIAttributePodName:              "pod1",
+		types.CSIAttributePodNamespace:         "ns1",
+		types.CSIAttributeServiceAccountName:   "sa1",
+		types.CSIAttributeServiceAccountTokens: "token1",
+	}
+	authConfig := auth.Config{WorkloadIdentityClientID: "clientid", ServiceAccountToken: "token1"}
+	key := fallbackCacheKey(attrib, authConfig)
+
+	samePod := func(k, v string) map[string]string {
+		m := map[string]string{}
+		for key, value := range attrib {
+			m[key] = value
+		}
+		m[k] = v
+		return m
+	}
+	if key != fallbackCacheKey(samePod(types.CSIAttributeServiceAccountTokens, "token2"), authConfig) {
+		t.Errorf("expected key to not depend on the service account token")
+	}
+	if key != fallbackCacheKey(samePod(types.CSIAttributePodName, "pod2"), authConfig) {
+		t.Errorf("expected key to not depend on the pod name for workload identity")
+	}
+	for _, m := range []map[string]string{
+		samePod(types.CSIAttributePodNamespace, "ns2"),
+		samePod(types.CSIAttributeServiceAccountName, "sa2"),
+		samePod("keyvaultName", "kv2"),
+		samePod("objects", "array: [secret1]"),
+	} {
+		if key == fallbackCacheKey(m, authConfig) {
+			t.Errorf("expected key for %v to differ", m)
+		}
+	}
+	if key == fallbackCacheKey(attrib, auth.Config{WorkloadIdentityClientID: "clientid2"}) {
+		t.Errorf("expected key to depend on the identity")
+	}
+	if key == fallbackCacheKey(attrib, auth.Config{WorkloadIdentityClientID: "clientid", WorkloadIdentityTenantID: "tenant2", ServiceAccountToken: "token1"}) {
+		t.Errorf("expected key to depend on the workload identity tenant")
+	}
+}
@@ -0,0 +1,279 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// templateUIDPrefix is the prefix of the UID of the files rendered from templates
+	templateUIDPrefix = "template/"
+	// maxTemplateOutputSize is the maximum size of a file rendered from a template
+	maxTemplateOutputSize = 1 << 20
+	// maxTemplateSteps is the maximum number of range iterations and template calls of a template
+	maxTemplateSteps = 1 << 17
+	// templateStepFunc is the function called on every range iteration and template call
+	templateStepFunc = "templateStep"
+)
+
+var (
+	// errTemplateOutputTooLarge is returned when the output of a template exceeds maxTemplateOutputSize
+	errTemplateOutputTooLarge = fmt.Errorf("output exceeds %d bytes", maxTemplateOutputSize)
+	// errTemplateTooManySteps is returned when a template exceeds maxTemplateSteps
+	errTemplateTooManySteps = fmt.Errorf("exceeds %d range iterations and template calls", maxTemplateSteps)
+)
+
+// limitedWriter writes to the buffer until the limit is reached
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, errTemplateOutputTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// objectTemplate is a parsed template of the templates parameter
+type objectTemplate struct {
+	path     string
+	fileMode int32
+	tmpl     *template.Template
+}
+
+// templateData is the data the templates are executed with
+type templateData struct {
+	// Objects maps the file name of every fetched object to its content
+	Objects map[string]string
+}
+
+// templateFuncs are the only functions available to templates in addition to the
+// text/template builtins. None of them have access to the file system or the environment.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"trim":     strings.TrimSpace,
+	"pemCerts": pemCerts,
+	"pemKey":   pemKey,
+	// object is replaced with a function that looks up the fetched objects when the template is rendered
+	"object": func(string) (string, error) {
+		return "", fmt.Errorf("object is not available")
+	},
+	// templateStep is replaced with a function that counts the steps of the template when it's rendered
+	templateStepFunc: func() (string, error) {
+		return "", nil
+	},
+}
+
+// addTemplateSteps calls templateStepFunc at the start of every range iteration and every
+// template so a template that loops or recurses can be stopped while it's executed
+func addTemplateSteps(tmpl *template.Template) error {
+	step, err := template.New("step").Funcs(templateFuncs).Parse("{{" + templateStepFunc + "}}")
+	if err != nil {
+		return err
+	}
+	stepNode := step.Tree.Root.Nodes[0]
+	var walk func(list *parse.ListNode)
+	walk = func(list *parse.ListNode) {
+		if list == nil {
+			return
+		}
+		for _, node := range list.Nodes {
+			switch n := node.(type) {
+			case *parse.IfNode:
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.WithNode:
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.RangeNode:
+				walk(n.List)
+				walk(n.ElseList)
+				n.List.Nodes = append([]parse.Node{stepNode}, n.List.Nodes...)
+			}
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		walk(t.Tree.Root)
+		t.Tree.Root.Nodes = append([]parse.Node{stepNode}, t.Tree.Root.Nodes...)
+	}
+	return nil
+}
+
+// parseTemplates parses the templates parameter
+func parseTemplates(templates string, defaultFilePermission os.FileMode) ([]objectTemplate, error) {
+	if templates == "" {
+		return nil, nil
+	}
+	array, err := types.GetObjectsArray(templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to yaml unmarshal templates, error: %w", err)
+	}
+
+	var result []objectTemplate
+	paths := make(map[string]bool)
+	for i, item := range array.Array {
+		var secretTemplate types.SecretTemplate
+		if err = yaml.Unmarshal([]byte(item), &secretTemplate); err != nil {
+			return nil, fmt.Errorf("unmarshal failed for templates at index %d, error: %w", i, err)
+		}
+		path := strings.TrimSpace(secretTemplate.Path)
+		if err = validateFileName(path); err != nil {
+			return nil, fmt.Errorf("invalid path for template at index %d, error: %w", i, err)
+		}
+		if paths[path] {
+			return nil, fmt.Errorf("duplicate path %s for template at index %d", path, i)
+		}
+		paths[path] = true
+		fileMode, err := secretTemplate.GetFilePermission(defaultFilePermission)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filePermission for template %s, error: %w", path, err)
+		}
+		if strings.TrimSpace(secretTemplate.Template) == "" {
+			return nil, fmt.Errorf("template %s is empty", path)
+		}
+		tmpl, err := template.New(path).Option("missingkey=error").Funcs(templateFuncs).Parse(secretTemplate.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s, error: %w", path, err)
+		}
+		if err = addTemplateSteps(tmpl); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s, error: %w", path, err)
+		}
+		result = append(result, objectTemplate{path: path, fileMode: fileMode, tmpl: tmpl})
+	}
+	return result, nil
+}
+
+// renderTemplates renders the templates from the fetched files and returns the rendered files.
+// The version of a rendered file combines the versions of all fetched files, so it changes
+// whenever any of the objects the template may reference is rotated. The output of a template
+// is limited to maxTemplateOutputSize and its range iterations and template calls to maxTemplateSteps.
+// A template stops once the context is done.
+func renderTemplates(ctx context.Context, templates []objectTemplate, files []types.SecretFile) ([]types.SecretFile, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	objects := make(map[string]string, len(files))
+	for _, file := range files {
+		objects[file.Path] = string(file.Content)
+	}
+	version := combineVersions(files)
+
+	lookup := func(name string) (string, error) {
+		content, ok := objects[name]
+		if !ok {
+			return "", fmt.Errorf("object %s not found", name)
+		}
+		return content, nil
+	}
+
+	var result []types.SecretFile
+	for _, t := range templates {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to render template %s, error: %w", t.path, err)
+		}
+		if _, ok := objects[t.path]; ok {
+			return nil, fmt.Errorf("template path %s conflicts with an object file", t.path)
+		}
+		tmpl, err := t.tmpl.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render template %s, error: %w", t.path, err)
+		}
+		steps := 0
+		step := func() (string, error) {
+			steps++
+			if steps > maxTemplateSteps {
+				return "", errTemplateTooManySteps
+			}
+			return "", ctx.Err()
+		}
+		w := &limitedWriter{limit: maxTemplateOutputSize}
+		if err = tmpl.Funcs(template.FuncMap{"object": lookup, templateStepFunc: step}).Execute(w, templateData{Objects: objects}); err != nil {
+			return nil, fmt.Errorf("failed to render template %s, error: %w", t.path, err)
+		}
+		result = append(result, types.SecretFile{
+			Path:     t.path,
+			Content:  w.buf.Bytes(),
+			FileMode: t.fileMode,
+			UID:      templateUIDPrefix + t.path,
+			Version:  version,
+		})
+	}
+	return result, nil
+}
+
+// combineVersions returns a version for a file rendered from the files that
+// changes when the version of any of the files changes
+func combineVersions(files []types.SecretFile) string {
+	versions := make([]string, 0, len(files))
+	for _, file := range files {
+		versions = append(versions, file.Path+"="+file.Version)
+	}
+	sort.Strings(versions)
+	hash := sha256.Sum256([]byte(strings.Join(versions, "\n")))
+	return hex.EncodeToString(hash[:16])
+}
+
+// pemCerts returns the CERTIFICATE blocks in the PEM content
+func pemCerts(content string) (string, error) {
+	var certs []byte
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == types.CertificateType {
+			certs = append(certs, pem.EncodeToMemory(block)...)
+		}
+	}
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no CERTIFICATE block found")
+	}
+	return string(certs), nil
+}
+
+// pemKey returns the first private key block in the PEM content
+func pemKey(content string) (string, error) {
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return "", fmt.Errorf("no PRIVATE KEY block found")
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return string(pem.EncodeToMemory(block)), nil
+		}
+	}
+}
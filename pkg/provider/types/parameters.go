@@ -2,10 +2,12 @@ package types
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
@@ -44,6 +46,16 @@ func GetUserAssignedIdentityID(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[UserAssignedIdentityIDParameter])
 }
 
+// GetUserAssignedIdentityResourceID returns the resource ID of the user assigned identity
+func GetUserAssignedIdentityResourceID(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[UserAssignedIdentityResourceIDParameter])
+}
+
+// GetUserAssignedIdentityObjectID returns the object ID of the user assigned identity
+func GetUserAssignedIdentityObjectID(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[UserAssignedIdentityObjectIDParameter])
+}
+
 // GetTenantID returns the tenant ID
 func GetTenantID(parameters map[string]string) string {
 	// ref: https://github.com/Azure/secrets-store-csi-driver-provider-azure/issues/857
@@ -75,6 +87,26 @@ func GetClientID(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[ClientIDParameter])
 }
 
+// GetWorkloadIdentityAudience returns the audience of the service account token for workload identity
+func GetWorkloadIdentityAudience(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[WorkloadIdentityAudienceParameter])
+}
+
+// GetWorkloadIdentityTenantID returns the home tenant of the workload identity app for cross tenant access
+func GetWorkloadIdentityTenantID(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[WorkloadIdentityTenantIDParameter])
+}
+
+// GetCrossTenantClientID returns the client ID of the app in the tenant of the vault for cross tenant access
+func GetCrossTenantClientID(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CrossTenantClientIDParameter])
+}
+
+// GetCrossTenantAudience returns the audience of the token of the workload identity app for cross tenant access
+func GetCrossTenantAudience(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CrossTenantAudienceParameter])
+}
+
 // GetUseAzureTokenProxy returns if azure token proxy is enabled
 func GetUseAzureTokenProxy(parameters map[string]string) (bool, error) {
 	str := strings.TrimSpace(parameters[UseAzureTokenProxyParameter])
@@ -84,6 +116,40 @@ func GetUseAzureTokenProxy(parameters map[string]string) (bool, error) {
 	return strconv.ParseBool(str)
 }
 
+// GetUseFallbackCache returns if the fallback cache is enabled
+func GetUseFallbackCache(parameters map[string]string) (bool, error) {
+	str := strings.TrimSpace(parameters[UseFallbackCacheParameter])
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+// GetVaultURI returns the vault URI
+func GetVaultURI(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[VaultURIParameter])
+}
+
+// GetVaultCABundle returns the PEM CA bundle for the vault URI
+func GetVaultCABundle(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[VaultCABundleParameter])
+}
+
+// GetVaultServerName returns the server name for the vault URI
+func GetVaultServerName(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[VaultServerNameParameter])
+}
+
+// GetVaultType returns the vault type
+func GetVaultType(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[VaultTypeParameter])
+}
+
+// GetServiceAccountName returns the service account name
+func GetServiceAccountName(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CSIAttributeServiceAccountName])
+}
+
 // GetServiceAccountTokens returns the service account tokens
 func GetServiceAccountTokens(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[CSIAttributeServiceAccountTokens])
@@ -94,6 +160,106 @@ func GetObjects(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[ObjectsParameter])
 }
 
+// GetTemplates returns the templates rendered from the key vault objects
+func GetTemplates(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[TemplatesParameter])
+}
+
+// GetEnvFile returns the path of the env file
+func GetEnvFile(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[EnvFileParameter])
+}
+
+// GetEnvFileFormat returns the format of the env file
+func GetEnvFileFormat(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[EnvFileFormatParameter])
+}
+
+// GetJWKSFile returns the path of the JWKS file
+func GetJWKSFile(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[JWKSFileParameter])
+}
+
+// GetWriteCertAndKeyInSeparateFiles returns if the cert and key of the objects are written in separate files.
+// nil is returned if the parameter is not set.
+func GetWriteCertAndKeyInSeparateFiles(parameters map[string]string) (*bool, error) {
+	return parseOptionalBool(parameters[WriteCertAndKeyInSeparateFilesParameter])
+}
+
+// GetWriteCombinedCertAndKey returns if the combined cert and key of the objects are written
+// with the separate files. nil is returned if the parameter is not set.
+func GetWriteCombinedCertAndKey(parameters map[string]string) (*bool, error) {
+	return parseOptionalBool(parameters[WriteCombinedCertAndKeyParameter])
+}
+
+// GetCertFileSuffix returns the suffix of the cert files
+func GetCertFileSuffix(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CertFileSuffixParameter])
+}
+
+// GetKeyFileSuffix returns the suffix of the key files
+func GetKeyFileSuffix(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[KeyFileSuffixParameter])
+}
+
+// parseOptionalBool parses the bool, nil is returned for an empty string
+func parseOptionalBool(str string) (*bool, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetIdentityChain returns the identity modes of the identity chain
+func GetIdentityChain(parameters map[string]string) []string {
+	var chain []string
+	for _, mode := range strings.Split(parameters[IdentityChainParameter], ",") {
+		if mode = strings.TrimSpace(mode); mode != "" {
+			chain = append(chain, mode)
+		}
+	}
+	return chain
+}
+
+// GetIdentityChainPinDuration returns how long the identity mode of the identity chain is pinned.
+// DefaultIdentityChainPinDuration is returned if the parameter is not set.
+func GetIdentityChainPinDuration(parameters map[string]string) (time.Duration, error) {
+	str := strings.TrimSpace(parameters[IdentityChainPinDurationParameter])
+	if str == "" {
+		return DefaultIdentityChainPinDuration, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %s", IdentityChainPinDurationParameter, str)
+	}
+	return d, nil
+}
+
+// GetObjectFetchConcurrency returns the number of objects to fetch in parallel.
+// 0 is returned if the parameter is not set.
+func GetObjectFetchConcurrency(parameters map[string]string) (int, error) {
+	str := strings.TrimSpace(parameters[ObjectFetchConcurrencyParameter])
+	if str == "" {
+		return 0, nil
+	}
+	concurrency, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, err
+	}
+	if concurrency < 1 {
+		return 0, fmt.Errorf("%s must be greater than 0, got %d", ObjectFetchConcurrencyParameter, concurrency)
+	}
+	return concurrency, nil
+}
+
 // GetObjectsArray returns the key vault objects array
 func GetObjectsArray(objects string) (StringArray, error) {
 	var a StringArray
@@ -110,14 +276,135 @@ func (kv KeyVaultObject) IsSyncingSingleVersion() bool {
 // GetObjectUID returns UID for the object with the format:
 // <object type>/<object name> if syncing a single version
 // <object type/<object name>/<version index> if syncing multiple versions
+// The UID is prefixed with <vault>/ if the object sets its own vault.
 func (kv KeyVaultObject) GetObjectUID() string {
-	if kv.IsSyncingSingleVersion() {
-		return fmt.Sprintf("%s/%s", kv.ObjectType, kv.ObjectName)
+	uid := fmt.Sprintf("%s/%s", kv.ObjectType, kv.ObjectName)
+	if !kv.IsSyncingSingleVersion() {
+		parts := strings.Split(kv.ObjectAlias, string(filepath.Separator))
+		versionIndex := parts[len(parts)-1]
+		uid = fmt.Sprintf("%s/%s", uid, versionIndex)
 	}
+	if vault := kv.getVault(); vault != "" {
+		return fmt.Sprintf("%s/%s", vault, uid)
+	}
+	return uid
+}
 
-	parts := strings.Split(kv.ObjectAlias, string(filepath.Separator))
-	versionIndex := parts[len(parts)-1]
-	return fmt.Sprintf("%s/%s/%s", kv.ObjectType, kv.ObjectName, versionIndex)
+// getVault returns the vault name or host set for the object
+func (kv KeyVaultObject) getVault() string {
+	if kv.KeyVaultName != "" {
+		return kv.KeyVaultName
+	}
+	if kv.VaultURI != "" {
+		if u, err := url.Parse(kv.VaultURI); err == nil && u.Host != "" {
+			return u.Host
+		}
+		return kv.VaultURI
+	}
+	return ""
+}
+
+// IsSelector returns true if the object selects the objects to fetch by
+// name prefix, regex or tags instead of naming a single object
+func (kv KeyVaultObject) IsSelector() bool {
+	return kv.ObjectNamePrefix != "" || kv.ObjectNameRegex != "" || kv.ObjectTags != ""
+}
+
+// GetSelector returns a description of the objects selected by the object
+func (kv KeyVaultObject) GetSelector() string {
+	var parts []string
+	if kv.ObjectNamePrefix != "" {
+		parts = append(parts, kv.ObjectNamePrefix+"*")
+	}
+	if kv.ObjectNameRegex != "" {
+		parts = append(parts, kv.ObjectNameRegex)
+	}
+	if kv.ObjectTags != "" {
+		parts = append(parts, "["+kv.ObjectTags+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// GetObjectTags returns the tags the objects to fetch must have
+func (kv KeyVaultObject) GetObjectTags() (map[string]string, error) {
+	return ParseTags(kv.ObjectTags)
+}
+
+// GetObjectVersionTags returns the tags the version to fetch must have
+func (kv KeyVaultObject) GetObjectVersionTags() (map[string]string, error) {
+	return ParseTags(kv.ObjectVersionTags)
+}
+
+// IsJSONSelector returns true if the object writes values selected from a JSON secret
+// instead of the whole secret
+func (kv KeyVaultObject) IsJSONSelector() bool {
+	return kv.ObjectJSONPath != "" || kv.JSONKeys != ""
+}
+
+// GetJSONPath returns the keys and array indexes of the objectJSONPath
+func (kv KeyVaultObject) GetJSONPath() ([]string, error) {
+	if kv.ObjectJSONPath == "" {
+		return nil, nil
+	}
+	segments := strings.Split(kv.ObjectJSONPath, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path %q, must not contain empty segments", kv.ObjectJSONPath)
+		}
+	}
+	return segments, nil
+}
+
+// GetJSONKeys returns the keys of the JSON secret to write to separate files
+func (kv KeyVaultObject) GetJSONKeys() ([]string, error) {
+	if kv.JSONKeys == "" {
+		return nil, nil
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(kv.JSONKeys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("key must not be empty")
+		}
+		if strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+			return nil, fmt.Errorf("invalid key %q, must be a valid file name", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseTags parses tags in the format key1=value1,key2=value2
+func ParseTags(tags string) (map[string]string, error) {
+	if strings.TrimSpace(tags) == "" {
+		return nil, nil
+	}
+	result := make(map[string]string)
+	for _, tag := range strings.Split(tags, ",") {
+		key, value, found := strings.Cut(tag, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, should be in the format key=value", strings.TrimSpace(tag))
+		}
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("duplicate tag %q", key)
+		}
+		result[key] = strings.TrimSpace(value)
+	}
+	return result, nil
+}
+
+// GetMaxObjects returns the maximum number of objects the selector may select
+func (kv KeyVaultObject) GetMaxObjects() int {
+	if kv.MaxObjects > 0 {
+		return int(kv.MaxObjects)
+	}
+	return DefaultMaxObjects
 }
 
 // GetFileName returns the file name for the secret
@@ -132,13 +419,53 @@ func (kv KeyVaultObject) GetFileName() string {
 
 // GetFilePermission returns the file permission and error if any
 func (kv KeyVaultObject) GetFilePermission(defaultFilePermission os.FileMode) (int32, error) {
-	if kv.FilePermission == "" {
+	return parseFilePermission(kv.FilePermission, defaultFilePermission)
+}
+
+// GetFilePermission returns the file permission of the rendered file and error if any
+func (t SecretTemplate) GetFilePermission(defaultFilePermission os.FileMode) (int32, error) {
+	return parseFilePermission(t.FilePermission, defaultFilePermission)
+}
+
+func parseFilePermission(filePermission string, defaultFilePermission os.FileMode) (int32, error) {
+	if filePermission == "" {
 		//nolint:gosec // Safe to cast, file permissions fit within int32 range
 		return int32(defaultFilePermission), nil
 	}
-	permission, err := strconv.ParseInt(kv.FilePermission, 8, 32)
+	permission, err := strconv.ParseInt(filePermission, 8, 32)
 	if err != nil {
 		return 0, fmt.Errorf("file permission must be a valid octal number: %w", err)
 	}
 	return int32(permission), nil
 }
+
+// IsWritingCertAndKeyInSeparateFiles returns true if the cert and key of the object are written
+// in separate files, defaulting to the given value
+func (kv KeyVaultObject) IsWritingCertAndKeyInSeparateFiles(defaultValue bool) bool {
+	if kv.WriteCertAndKeyInSeparateFiles == nil {
+		return defaultValue
+	}
+	return *kv.WriteCertAndKeyInSeparateFiles
+}
+
+// IsWritingCombinedCertAndKey returns true if the combined cert and key of the object are written
+// with the separate files
+func (kv KeyVaultObject) IsWritingCombinedCertAndKey() bool {
+	return kv.WriteCombinedCertAndKey == nil || *kv.WriteCombinedCertAndKey
+}
+
+// GetCertFileSuffix returns the suffix of the cert file of the object
+func (kv KeyVaultObject) GetCertFileSuffix() string {
+	if kv.CertFileSuffix == "" {
+		return DefaultCertFileSuffix
+	}
+	return kv.CertFileSuffix
+}
+
+// GetKeyFileSuffix returns the suffix of the key file of the object
+func (kv KeyVaultObject) GetKeyFileSuffix() string {
+	if kv.KeyFileSuffix == "" {
+		return DefaultKeyFileSuffix
+	}
+	return kv.KeyFileSuffix
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetKeyVaultName(t *testing.T) {
@@ -268,6 +269,56 @@ func TestGetUserAssignedIdentityID(t *testing.T) {
 	}
 }
 
+func TestGetUserAssignedIdentityResourceIDAndObjectID(t *testing.T) {
+	parameters := map[string]string{
+		UserAssignedIdentityResourceIDParameter: " /subscriptions/sid/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id ",
+		UserAssignedIdentityObjectIDParameter:   " oid ",
+	}
+	if actual := GetUserAssignedIdentityResourceID(parameters); actual != "/subscriptions/sid/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id" {
+		t.Errorf("GetUserAssignedIdentityResourceID() = %v, expected the trimmed resource ID", actual)
+	}
+	if actual := GetUserAssignedIdentityObjectID(parameters); actual != "oid" {
+		t.Errorf("GetUserAssignedIdentityObjectID() = %v, expected oid", actual)
+	}
+}
+
+func TestGetIdentityChain(t *testing.T) {
+	actual := GetIdentityChain(map[string]string{IdentityChainParameter: " workloadIdentity, ,vmManagedIdentity "})
+	if !reflect.DeepEqual(actual, []string{"workloadIdentity", "vmManagedIdentity"}) {
+		t.Errorf("GetIdentityChain() = %v, expected [workloadIdentity vmManagedIdentity]", actual)
+	}
+	if actual = GetIdentityChain(map[string]string{}); actual != nil {
+		t.Errorf("GetIdentityChain() = %v, expected nil", actual)
+	}
+}
+
+func TestGetIdentityChainPinDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{name: "default", value: "", expected: DefaultIdentityChainPinDuration},
+		{name: "duration", value: "30m", expected: 30 * time.Minute},
+		{name: "disabled", value: "0", expected: 0},
+		{name: "invalid", value: "10", expectedErr: true},
+		{name: "negative", value: "-1m", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := GetIdentityChainPinDuration(map[string]string{IdentityChainPinDurationParameter: test.value})
+			if test.expectedErr != (err != nil) {
+				t.Fatalf("GetIdentityChainPinDuration() error = %v, expected error %v", err, test.expectedErr)
+			}
+			if actual != test.expected {
+				t.Errorf("GetIdentityChainPinDuration() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
 func TestGetTenantID(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -700,6 +751,26 @@ func TestGetObjectUID(t *testing.T) {
 			},
 			expected: "secret/multiple-versions/8",
 		},
+		{
+			name: "object with keyvault name",
+			object: KeyVaultObject{
+				ObjectType:   "secret",
+				ObjectName:   "single-version",
+				KeyVaultName: "kv",
+			},
+			expected: "kv/secret/single-version",
+		},
+		{
+			name: "object with vault uri syncing multiple versions",
+			object: KeyVaultObject{
+				ObjectType:           "secret",
+				ObjectName:           "multiple-versions",
+				ObjectAlias:          filepath.Join("multiple-versions", "0"),
+				ObjectVersionHistory: 10,
+				VaultURI:             "https://kv.vault.azure.net/",
+			},
+			expected: "kv.vault.azure.net/secret/multiple-versions/0",
+		},
 	}
 
 	for _, test := range tests {
@@ -899,3 +970,244 @@ func TestGetUseAzureTokenProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestGetObjectFetchConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    int
+		expectError bool
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: 0,
+		},
+		{
+			name:     "valid",
+			value:    "8",
+			expected: 8,
+		},
+		{
+			name:     "trim spaces",
+			value:    " 2 ",
+			expected: 2,
+		},
+		{
+			name:        "zero",
+			value:       "0",
+			expectError: true,
+		},
+		{
+			name:        "negative",
+			value:       "-1",
+			expectError: true,
+		},
+		{
+			name:        "invalid",
+			value:       "two",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{
+				ObjectFetchConcurrencyParameter: tt.value,
+			}
+
+			result, err := GetObjectFetchConcurrency(params)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("GetObjectFetchConcurrency() error = nil, expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetObjectFetchConcurrency() unexpected error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetObjectFetchConcurrency() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetUseFallbackCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    bool
+		expectError bool
+	}{
+		{name: "empty", value: "", expected: false},
+		{name: "true", value: "true", expected: true},
+		{name: "false", value: "false", expected: false},
+		{name: "invalid", value: "tru", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GetUseFallbackCache(map[string]string{UseFallbackCacheParameter: tt.value})
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("GetUseFallbackCache() error = nil, expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetUseFallbackCache() unexpected error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetUseFallbackCache() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetWriteCertAndKeyInSeparateFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    *bool
+		expectError bool
+	}{
+		{name: "empty", value: "", expected: nil},
+		{name: "true", value: " true ", expected: boolPtr(true)},
+		{name: "false", value: "false", expected: boolPtr(false)},
+		{name: "invalid", value: "tru", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GetWriteCertAndKeyInSeparateFiles(map[string]string{WriteCertAndKeyInSeparateFilesParameter: tt.value})
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("GetWriteCertAndKeyInSeparateFiles() error = nil, expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetWriteCertAndKeyInSeparateFiles() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("GetWriteCertAndKeyInSeparateFiles() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCertKeySplit(t *testing.T) {
+	kv := KeyVaultObject{}
+	if !kv.IsWritingCertAndKeyInSeparateFiles(true) || kv.IsWritingCertAndKeyInSeparateFiles(false) {
+		t.Errorf("IsWritingCertAndKeyInSeparateFiles() should return the default value when not set")
+	}
+	if !kv.IsWritingCombinedCertAndKey() {
+		t.Errorf("IsWritingCombinedCertAndKey() = false, expected true when not set")
+	}
+	if kv.GetCertFileSuffix() != ".crt" || kv.GetKeyFileSuffix() != ".key" {
+		t.Errorf("GetCertFileSuffix(), GetKeyFileSuffix() = %s, %s, expected .crt, .key", kv.GetCertFileSuffix(), kv.GetKeyFileSuffix())
+	}
+
+	kv = KeyVaultObject{
+		WriteCertAndKeyInSeparateFiles: boolPtr(false),
+		WriteCombinedCertAndKey:        boolPtr(false),
+		CertFileSuffix:                 ".pem",
+		KeyFileSuffix:                  "-key.pem",
+	}
+	if kv.IsWritingCertAndKeyInSeparateFiles(true) {
+		t.Errorf("IsWritingCertAndKeyInSeparateFiles() = true, expected false")
+	}
+	if kv.IsWritingCombinedCertAndKey() {
+		t.Errorf("IsWritingCombinedCertAndKey() = true, expected false")
+	}
+	if kv.GetCertFileSuffix() != ".pem" || kv.GetKeyFileSuffix() != "-key.pem" {
+		t.Errorf("GetCertFileSuffix(), GetKeyFileSuffix() = %s, %s, expected .pem, -key.pem", kv.GetCertFileSuffix(), kv.GetKeyFileSuffix())
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestGetVaultType(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name:       "empty",
+			parameters: map[string]string{},
+			expected:   "",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				VaultTypeParameter: " managedHSM ",
+			},
+			expected: "managedHSM",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetVaultType(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetVaultType() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetVaultURI(t *testing.T) {
+	parameters := map[string]string{
+		VaultURIParameter:        " https://kv.contoso.com:8443/ ",
+		VaultCABundleParameter:   " ca ",
+		VaultServerNameParameter: " kv.vault.azure.net ",
+	}
+	if actual := GetVaultURI(parameters); actual != "https://kv.contoso.com:8443/" {
+		t.Errorf("GetVaultURI() = %v, expected https://kv.contoso.com:8443/", actual)
+	}
+	if actual := GetVaultCABundle(parameters); actual != "ca" {
+		t.Errorf("GetVaultCABundle() = %v, expected ca", actual)
+	}
+	if actual := GetVaultServerName(parameters); actual != "kv.vault.azure.net" {
+		t.Errorf("GetVaultServerName() = %v, expected kv.vault.azure.net", actual)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        string
+		expected    map[string]string
+		expectError bool
+	}{
+		{name: "empty", tags: "", expected: nil},
+		{name: "single tag", tags: "env=prod", expected: map[string]string{"env": "prod"}},
+		{name: "multiple tags with spaces", tags: " env = prod , team=payments", expected: map[string]string{"env": "prod", "team": "payments"}},
+		{name: "empty value", tags: "env=", expected: map[string]string{"env": ""}},
+		{name: "missing value", tags: "env", expectError: true},
+		{name: "missing key", tags: "=prod", expectError: true},
+		{name: "duplicate key", tags: "env=prod,env=dev", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTags(tt.tags)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ParseTags() error = nil, expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ParseTags() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ParseTags() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -17,6 +17,45 @@ const (
 
 	ObjectFormatPEM = "pem"
 	ObjectFormatPFX = "pfx"
+	ObjectFormatJWK = "jwk"
+	// ObjectFormatPKCS12 and ObjectFormatJKS write a certificate as a password protected keystore
+	ObjectFormatPKCS12 = "pkcs12"
+	ObjectFormatJKS    = "jks"
+
+	// KeystoreEncryptionAES256 encrypts a PKCS#12 keystore with PBES2 and AES-256-CBC
+	KeystoreEncryptionAES256 = "aes256"
+	// KeystoreEncryptionDES3 encrypts a PKCS#12 keystore with the legacy 3DES algorithm
+	// for clients that don't support PBES2
+	KeystoreEncryptionDES3 = "des3"
+
+	// PrivateKeyFormatPKCS8 writes the private key as a PKCS#8 "PRIVATE KEY"
+	PrivateKeyFormatPKCS8 = "pkcs8"
+	// PrivateKeyFormatPKCS1 writes the RSA private key as a PKCS#1 "RSA PRIVATE KEY"
+	PrivateKeyFormatPKCS1 = "pkcs1"
+	// PrivateKeyFormatSEC1 writes the EC private key as a SEC 1 "EC PRIVATE KEY"
+	PrivateKeyFormatSEC1 = "sec1"
+	// PrivateKeyFormatEncryptedPKCS8 writes the private key as a PKCS#8 "ENCRYPTED PRIVATE KEY"
+	// encrypted with the password in the objectPasswordSecret
+	PrivateKeyFormatEncryptedPKCS8 = "encrypted-pkcs8"
+
+	// IncompleteCertChainFail fails the mount when the certificate chain is incomplete
+	IncompleteCertChainFail = "fail"
+	// IncompleteCertChainWarn logs a warning and writes the chain found when the certificate chain is incomplete
+	IncompleteCertChainWarn = "warn"
+
+	// DefaultMaxObjects is the default maximum number of objects a selector may select
+	DefaultMaxObjects = 100
+
+	// VaultTypeKeyVault is the vault type of an Azure Key Vault
+	VaultTypeKeyVault = "keyvault"
+	// VaultTypeManagedHSM is the vault type of an Azure Key Vault Managed HSM
+	VaultTypeManagedHSM = "managedHSM"
+
+	// EnvFileFormatDotenv is the env file format with KEY="value" lines
+	EnvFileFormatDotenv = "dotenv"
+	// EnvFileFormatExport is the env file format with export KEY='value' lines
+	// that can be sourced by a shell
+	EnvFileFormatExport = "export"
 
 	ObjectEncodingHex    = "hex"
 	ObjectEncodingBase64 = "base64"
@@ -28,6 +67,9 @@ const (
 	CSIAttributePodName              = "csi.storage.k8s.io/pod.name"
 	CSIAttributePodNamespace         = "csi.storage.k8s.io/pod.namespace"
 	CSIAttributeServiceAccountTokens = "csi.storage.k8s.io/serviceAccount.tokens" // nolint
+	CSIAttributeServiceAccountName   = "csi.storage.k8s.io/serviceAccount.name"
+	// CSIAttributePrefix is the prefix of the attributes added by the driver for the mount
+	CSIAttributePrefix = "csi.storage.k8s.io/"
 
 	// KeyVaultNameParameter is the name of the key vault name parameter
 	KeyVaultNameParameter = "keyvaultName"
@@ -39,6 +81,12 @@ const (
 	UseVMManagedIdentityParameter = "useVMManagedIdentity"
 	// UserAssignedIdentityIDParameter is the name of the user assigned identity ID parameter
 	UserAssignedIdentityIDParameter = "userAssignedIdentityID"
+	// UserAssignedIdentityResourceIDParameter is the name of the parameter that selects the
+	// user assigned identity by its ARM resource ID
+	UserAssignedIdentityResourceIDParameter = "userAssignedIdentityResourceID"
+	// UserAssignedIdentityObjectIDParameter is the name of the parameter that selects the
+	// user assigned identity by its object (principal) ID
+	UserAssignedIdentityObjectIDParameter = "userAssignedIdentityObjectID"
 	// TenantIDParameter is the name of the tenant ID parameter
 	// TODO(aramase): change this from tenantId to tenantID after v1.2 release
 	// ref: https://github.com/Azure/secrets-store-csi-driver-provider-azure/issues/857
@@ -48,11 +96,87 @@ const (
 	// ClientIDParameter is the name of the client ID parameter
 	// This clientID is used for workload identity
 	ClientIDParameter = "clientID"
+	// WorkloadIdentityAudienceParameter is the name of the parameter that sets the audience of the
+	// service account token exchanged for workload identity
+	WorkloadIdentityAudienceParameter = "workloadIdentityAudience"
+	// WorkloadIdentityTenantIDParameter is the name of the parameter that sets the home tenant of the
+	// workload identity app for cross tenant access
+	WorkloadIdentityTenantIDParameter = "workloadIdentityTenantID"
+	// CrossTenantClientIDParameter is the name of the parameter that sets the client ID of the app in
+	// the tenant of the vault that trusts the tokens of the workload identity app
+	CrossTenantClientIDParameter = "crossTenantClientID"
+	// CrossTenantAudienceParameter is the name of the parameter that sets the audience of the token of
+	// the workload identity app exchanged for a token of the cross tenant app
+	CrossTenantAudienceParameter = "crossTenantAudience"
 	// UseAzureTokenProxyParameter is the name of the use azure token proxy parameter
 	// When set to true, enables identity binding via Azure Token Proxy
 	UseAzureTokenProxyParameter = "useAzureTokenProxy"
+	// IdentityChainParameter is the name of the parameter that sets the identity modes tried in order,
+	// the first mode that gets a token is pinned for the pod
+	IdentityChainParameter = "identityChain"
+	// IdentityChainPinDurationParameter is the name of the parameter that sets how long the identity mode
+	// of the identity chain is pinned for the pod
+	IdentityChainPinDurationParameter = "identityChainPinDuration"
 	// ObjectsParameter is the name of the objects parameter
 	ObjectsParameter = "objects"
+	// ObjectFetchConcurrencyParameter is the name of the parameter that sets the
+	// number of objects fetched from Key Vault in parallel for a single mount
+	ObjectFetchConcurrencyParameter = "objectFetchConcurrency"
+	// UseFallbackCacheParameter is the name of the parameter that opts the SecretProviderClass
+	// in to serving the last fetched objects when Key Vault is unavailable
+	UseFallbackCacheParameter = "useFallbackCache"
+	// VaultURIParameter is the name of the parameter that sets the URI of the vault,
+	// used instead of keyvaultName for private endpoints with custom DNS names
+	VaultURIParameter = "vaultURI"
+	// VaultCABundleParameter is the name of the parameter that sets the PEM CA certificates
+	// trusted for the vault URI in addition to the system roots
+	VaultCABundleParameter = "vaultCABundle"
+	// VaultServerNameParameter is the name of the parameter that overrides the name used to
+	// verify the certificate of the vault URI and sent as SNI
+	VaultServerNameParameter = "vaultServerName"
+	// VaultTypeParameter is the name of the parameter that sets the type of the vault,
+	// keyvault or managedHSM
+	VaultTypeParameter = "vaultType"
+	// TemplatesParameter is the name of the parameter that sets the Go templates
+	// rendered from the fetched objects into additional files
+	TemplatesParameter = "templates"
+	// EnvFileParameter is the name of the parameter that sets the path of the env file
+	// written with the objects that set an envName
+	EnvFileParameter = "envFile"
+	// EnvFileFormatParameter is the name of the parameter that sets the format of the
+	// env file, dotenv or export
+	EnvFileFormatParameter = "envFileFormat"
+	// JWKSFileParameter is the name of the parameter that sets the path of the JWKS file
+	// written with the keys with objectFormat jwk
+	JWKSFileParameter = "jwksFile"
+	// WriteCertAndKeyInSeparateFilesParameter is the name of the parameter that sets if the cert and key
+	// of the objects are written in separate files. Defaults to the provider flag.
+	WriteCertAndKeyInSeparateFilesParameter = "writeCertAndKeyInSeparateFiles"
+	// WriteCombinedCertAndKeyParameter is the name of the parameter that sets if the combined cert and key
+	// of the objects are still written when they are written in separate files
+	WriteCombinedCertAndKeyParameter = "writeCombinedCertAndKey"
+	// CertFileSuffixParameter is the name of the parameter that sets the suffix of the cert files
+	CertFileSuffixParameter = "certFileSuffix"
+	// KeyFileSuffixParameter is the name of the parameter that sets the suffix of the key files
+	KeyFileSuffixParameter = "keyFileSuffix"
+
+	// IdentityChainWorkloadIdentity is the identity chain mode for workload identity
+	IdentityChainWorkloadIdentity = "workloadIdentity"
+	// IdentityChainPodIdentity is the identity chain mode for aad-pod-identity
+	IdentityChainPodIdentity = "podIdentity"
+	// IdentityChainVMManagedIdentity is the identity chain mode for the VM managed identity
+	IdentityChainVMManagedIdentity = "vmManagedIdentity"
+	// IdentityChainAzureTokenProxy is the identity chain mode for identity binding
+	IdentityChainAzureTokenProxy = "azureTokenProxy"
+	// IdentityChainServicePrincipal is the identity chain mode for the service principal in nodePublishSecretRef
+	IdentityChainServicePrincipal = "servicePrincipal"
+	// DefaultIdentityChainPinDuration is how long the identity mode of the identity chain is pinned by default
+	DefaultIdentityChainPinDuration = 10 * time.Minute
+
+	// DefaultCertFileSuffix is the default suffix of the cert file written for a certificate
+	DefaultCertFileSuffix = ".crt"
+	// DefaultKeyFileSuffix is the default suffix of the key file written for a certificate
+	DefaultKeyFileSuffix = ".key"
 )
 
 // KeyVaultObject holds keyvault object related config
@@ -68,13 +192,88 @@ type KeyVaultObject struct {
 	// the type of the Azure Key Vault objects
 	ObjectType string `json:"objectType" yaml:"objectType"`
 	// the format of the Azure Key Vault objects
-	// supported formats are PEM, PFX
+	// supported formats are PEM, PFX, JWK, PKCS12, JKS
 	ObjectFormat string `json:"objectFormat" yaml:"objectFormat"`
 	// The encoding of the object in KeyVault
 	// Supported encodings are Base64, Hex, Utf-8
 	ObjectEncoding string `json:"objectEncoding" yaml:"objectEncoding"`
 	// FilePermission is the file permissions
 	FilePermission string `json:"filePermission" yaml:"filePermission"`
+	// the name of the Azure Key Vault instance the object is fetched from.
+	// Defaults to the keyvaultName in the SecretProviderClass parameters.
+	KeyVaultName string `json:"keyvaultName" yaml:"keyvaultName"`
+	// the URI of the Azure Key Vault instance the object is fetched from.
+	// Mutually exclusive with KeyVaultName.
+	VaultURI string `json:"vaultURI" yaml:"vaultURI"`
+	// the type of the vault the object is fetched from, keyvault or managedHSM.
+	// Defaults to the vaultType in the SecretProviderClass parameters.
+	VaultType string `json:"vaultType" yaml:"vaultType"`
+	// the prefix of the names of the objects to fetch. All enabled objects of the type in the
+	// vault with a name that starts with the prefix are fetched. Mutually exclusive with ObjectName.
+	ObjectNamePrefix string `json:"objectNamePrefix" yaml:"objectNamePrefix"`
+	// the regular expression the whole name of the objects to fetch must match. All enabled objects
+	// of the type in the vault with a matching name are fetched. Mutually exclusive with ObjectName.
+	ObjectNameRegex string `json:"objectNameRegex" yaml:"objectNameRegex"`
+	// the tags the objects to fetch must have, in the format key1=value1,key2=value2. All enabled
+	// objects of the type in the vault with the tags are fetched. Mutually exclusive with ObjectName.
+	ObjectTags string `json:"objectTags" yaml:"objectTags"`
+	// the maximum number of objects ObjectNamePrefix, ObjectNameRegex or ObjectTags may select
+	MaxObjects int32 `json:"maxObjects" yaml:"maxObjects"`
+	// the tags the version to fetch must have, in the format key1=value1,key2=value2.
+	// The newest enabled version with the tags is fetched. Mutually exclusive with ObjectVersion.
+	ObjectVersionTags string `json:"objectVersionTags" yaml:"objectVersionTags"`
+	// WriteMetadata writes the version, dates, tags and content type of the object
+	// to <file name>.meta.json in addition to the object
+	WriteMetadata bool `json:"writeMetadata" yaml:"writeMetadata"`
+	// the path of the value to write from a JSON secret, with the keys and array indexes
+	// separated by dots, for example database.hosts.0
+	ObjectJSONPath string `json:"objectJSONPath" yaml:"objectJSONPath"`
+	// the comma separated keys of a JSON secret to write to separate files, in the directory
+	// set by the file name of the object. Selected from ObjectJSONPath if it is set.
+	JSONKeys string `json:"jsonKeys" yaml:"jsonKeys"`
+	// the name of the variable the object is written to in the env file
+	EnvName string `json:"envName" yaml:"envName"`
+	// ReleaseKey writes the private key of an exportable key as PKCS#8 PEM. The key is
+	// released with Secure Key Release to the target in the attestation token of the provider.
+	ReleaseKey bool `json:"releaseKey" yaml:"releaseKey"`
+	// the algorithm the released key is wrapped with: CKM_RSA_AES_KEY_WRAP,
+	// RSA_AES_KEY_WRAP_256 or RSA_AES_KEY_WRAP_384. Defaults to RSA_AES_KEY_WRAP_256.
+	ReleaseAlgorithm string `json:"releaseAlgorithm" yaml:"releaseAlgorithm"`
+	// the name of the secret in the same Key Vault with the password of the keystore
+	// written for objectFormat pkcs12 or jks, or of the encrypted private key
+	ObjectPasswordSecret string `json:"objectPasswordSecret" yaml:"objectPasswordSecret"`
+	// the alias of the key entry in the keystore. Defaults to the object name.
+	KeystoreAlias string `json:"keystoreAlias" yaml:"keystoreAlias"`
+	// the encryption of the PKCS#12 keystore: aes256 or des3. Defaults to aes256.
+	KeystoreEncryption string `json:"keystoreEncryption" yaml:"keystoreEncryption"`
+	// WriteCertChainFiles writes the leaf certificate, the intermediates, the root and the full
+	// chain to <file name>.leaf.pem, .chain.pem, .root.pem and .fullchain.pem in addition to the object
+	WriteCertChainFiles bool `json:"writeCertChainFiles" yaml:"writeCertChainFiles"`
+	// what to do when the certificate chain is incomplete: fail or warn. Defaults to fail.
+	IncompleteCertChain string `json:"incompleteCertChain" yaml:"incompleteCertChain"`
+	// WriteCertAndKeyInSeparateFiles writes the cert and key of a certificate to <file name><cert file suffix>
+	// and <file name><key file suffix>. Defaults to the parameter of the same name and then the provider flag.
+	WriteCertAndKeyInSeparateFiles *bool `json:"writeCertAndKeyInSeparateFiles" yaml:"writeCertAndKeyInSeparateFiles"`
+	// WriteCombinedCertAndKey writes the combined cert and key to the file of the object when the
+	// cert and key are written in separate files. Defaults to true.
+	WriteCombinedCertAndKey *bool `json:"writeCombinedCertAndKey" yaml:"writeCombinedCertAndKey"`
+	// the suffix of the cert file. Defaults to .crt.
+	CertFileSuffix string `json:"certFileSuffix" yaml:"certFileSuffix"`
+	// the suffix of the key file. Defaults to .key.
+	KeyFileSuffix string `json:"keyFileSuffix" yaml:"keyFileSuffix"`
+	// the format of the private key of a certificate: pkcs8, pkcs1, sec1 or encrypted-pkcs8.
+	// Defaults to pkcs8 for certificates in PFX format, the key of certificates in PEM format is written as is.
+	PrivateKeyFormat string `json:"privateKeyFormat" yaml:"privateKeyFormat"`
+}
+
+// SecretTemplate holds the config of a file rendered from the fetched objects
+type SecretTemplate struct {
+	// the path of the rendered file, relative to the mount
+	Path string `json:"path" yaml:"path"`
+	// the Go text/template the file is rendered from
+	Template string `json:"template" yaml:"template"`
+	// FilePermission is the file permissions
+	FilePermission string `json:"filePermission" yaml:"filePermission"`
 }
 
 // SecretFile holds content and metadata of a secret file that is sent
@@ -97,6 +296,15 @@ type StringArray struct {
 type KeyVaultObjectVersion struct {
 	Version string
 	Created time.Time
+	Tags    map[string]string
+}
+
+// KeyVaultObjectProperties holds the properties of an object returned when
+// listing the objects of a type in the vault
+type KeyVaultObjectProperties struct {
+	Name    string
+	Enabled bool
+	Tags    map[string]string
 }
 
 // KeyVaultObjectVersionList holds a list of KeyVaultObjectVersion
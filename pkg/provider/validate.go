@@ -3,11 +3,15 @@ package provider
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
 
+// envNameRegex matches the names of variables in the env file
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // validate is a helper function to validate the given object
 func validate(kv types.KeyVaultObject) error {
 	if err := validateObjectFormat(kv.ObjectFormat, kv.ObjectType); err != nil {
@@ -16,6 +20,48 @@ func validate(kv types.KeyVaultObject) error {
 	if err := validateObjectEncoding(kv.ObjectEncoding, kv.ObjectType); err != nil {
 		return err
 	}
+	if err := validateObjectVault(kv.KeyVaultName, kv.VaultURI); err != nil {
+		return err
+	}
+	if err := validateVaultType(kv.VaultType, kv.ObjectType); err != nil {
+		return err
+	}
+	if _, err := kv.GetObjectVersionTags(); err != nil {
+		return fmt.Errorf("invalid objectVersionTags: %w", err)
+	}
+	if kv.ObjectVersionTags != "" && kv.ObjectVersion != "" {
+		return fmt.Errorf("objectVersion and objectVersionTags are mutually exclusive")
+	}
+	if err := validateObjectJSON(kv); err != nil {
+		return err
+	}
+	if err := validateEnvName(kv); err != nil {
+		return err
+	}
+	if err := validateKeyRelease(kv); err != nil {
+		return err
+	}
+	if err := validateKeystore(kv); err != nil {
+		return err
+	}
+	if err := validateCertChainFiles(kv); err != nil {
+		return err
+	}
+	if err := validateCertKeySplit(kv); err != nil {
+		return err
+	}
+	if err := validatePrivateKeyFormat(kv); err != nil {
+		return err
+	}
+	if kv.IsSelector() {
+		if err := validateObjectSelector(kv); err != nil {
+			return err
+		}
+		// the file name of the selected objects defaults to the object name
+		if kv.ObjectAlias == "" {
+			return nil
+		}
+	}
 	return validateFileName(kv.GetFileName())
 }
 
@@ -25,14 +71,19 @@ func validateObjectFormat(objectFormat, objectType string) error {
 	if len(objectFormat) == 0 {
 		return nil
 	}
-	if !strings.EqualFold(objectFormat, types.ObjectFormatPEM) && !strings.EqualFold(objectFormat, types.ObjectFormatPFX) {
-		return fmt.Errorf("invalid objectFormat: %v, should be PEM or PFX", objectFormat)
+	if !strings.EqualFold(objectFormat, types.ObjectFormatPEM) && !strings.EqualFold(objectFormat, types.ObjectFormatPFX) && !strings.EqualFold(objectFormat, types.ObjectFormatJWK) &&
+		!strings.EqualFold(objectFormat, types.ObjectFormatPKCS12) && !strings.EqualFold(objectFormat, types.ObjectFormatJKS) {
+		return fmt.Errorf("invalid objectFormat: %v, should be PEM, PFX, JWK, PKCS12 or JKS", objectFormat)
 	}
 	// Azure Key Vault returns the base64 encoded binary content only for type secret
 	// for types cert/key, the content is always in pem format
 	if objectFormat == types.ObjectFormatPFX && objectType != types.VaultObjectTypeSecret {
 		return fmt.Errorf("PFX format only supported for objectType: secret")
 	}
+	// the public key of a key is written as a JWK
+	if strings.EqualFold(objectFormat, types.ObjectFormatJWK) && objectType != types.VaultObjectTypeKey {
+		return fmt.Errorf("JWK format only supported for objectType: key")
+	}
 	return nil
 }
 
@@ -55,6 +106,119 @@ func validateObjectEncoding(objectEncoding, objectType string) error {
 	return nil
 }
 
+// validateObjectVault checks that at most one of keyvaultName and vaultURI
+// is set for the object
+func validateObjectVault(keyvaultName, vaultURI string) error {
+	if len(keyvaultName) > 0 && len(vaultURI) > 0 {
+		return fmt.Errorf("keyvaultName and vaultURI are mutually exclusive")
+	}
+	return nil
+}
+
+// validateVaultType checks if the vault type is valid and is supported
+// for the given object type
+func validateVaultType(vaultType, objectType string) error {
+	if len(vaultType) == 0 || strings.EqualFold(vaultType, types.VaultTypeKeyVault) {
+		return nil
+	}
+	if !strings.EqualFold(vaultType, types.VaultTypeManagedHSM) {
+		return fmt.Errorf("invalid vaultType: %v, should be keyvault or managedHSM", vaultType)
+	}
+	// Managed HSM only stores keys
+	if objectType != types.VaultObjectTypeKey {
+		return fmt.Errorf("vaultType managedHSM only supported for objectType: key")
+	}
+	return nil
+}
+
+// validateObjectSelector checks if the object name prefix, regex or tags can be used
+// to select the objects to fetch
+func validateObjectSelector(kv types.KeyVaultObject) error {
+	if kv.ObjectName != "" {
+		return fmt.Errorf("objectName is mutually exclusive with objectNamePrefix, objectNameRegex and objectTags")
+	}
+	if kv.ObjectNamePrefix != "" && kv.ObjectNameRegex != "" {
+		return fmt.Errorf("objectNamePrefix and objectNameRegex are mutually exclusive")
+	}
+	if kv.ObjectVersion != "" {
+		return fmt.Errorf("objectVersion is not supported with objectNamePrefix, objectNameRegex or objectTags")
+	}
+	if _, err := kv.GetObjectTags(); err != nil {
+		return fmt.Errorf("invalid objectTags: %w", err)
+	}
+	if kv.MaxObjects < 0 {
+		return fmt.Errorf("maxObjects must be greater than 0")
+	}
+	if kv.ObjectNameRegex != "" {
+		if _, err := regexp.Compile(kv.ObjectNameRegex); err != nil {
+			return fmt.Errorf("invalid objectNameRegex: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateObjectJSON checks if values can be selected from the object with
+// the object JSON path and JSON keys
+func validateObjectJSON(kv types.KeyVaultObject) error {
+	if !kv.IsJSONSelector() {
+		return nil
+	}
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return fmt.Errorf("objectJSONPath and jsonKeys only supported for objectType: secret")
+	}
+	if strings.EqualFold(kv.ObjectFormat, types.ObjectFormatPFX) || isKeystoreObject(kv) {
+		return fmt.Errorf("objectJSONPath and jsonKeys are not supported with objectFormat: %s", strings.ToLower(kv.ObjectFormat))
+	}
+	if _, err := kv.GetJSONPath(); err != nil {
+		return fmt.Errorf("invalid objectJSONPath: %w", err)
+	}
+	if _, err := kv.GetJSONKeys(); err != nil {
+		return fmt.Errorf("invalid jsonKeys: %w", err)
+	}
+	return nil
+}
+
+// validateEnvName checks if the object can be written to the env file with the env name
+func validateEnvName(kv types.KeyVaultObject) error {
+	if kv.EnvName == "" {
+		return nil
+	}
+	if !envNameRegex.MatchString(kv.EnvName) {
+		return fmt.Errorf("invalid envName: %s, must start with a letter or underscore and only contain letters, digits and underscores", kv.EnvName)
+	}
+	// the env file has a single value for the object
+	if kv.IsSelector() {
+		return fmt.Errorf("envName is not supported with objectNamePrefix, objectNameRegex or objectTags")
+	}
+	if !kv.IsSyncingSingleVersion() {
+		return fmt.Errorf("envName is not supported with objectVersionHistory")
+	}
+	if kv.JSONKeys != "" {
+		return fmt.Errorf("envName is not supported with jsonKeys")
+	}
+	return nil
+}
+
+// validateKeyRelease checks if the private key of the object can be released
+func validateKeyRelease(kv types.KeyVaultObject) error {
+	if !kv.ReleaseKey {
+		if kv.ReleaseAlgorithm != "" {
+			return fmt.Errorf("releaseAlgorithm is only supported with releaseKey")
+		}
+		return nil
+	}
+	if kv.ObjectType != types.VaultObjectTypeKey {
+		return fmt.Errorf("releaseKey only supported for objectType: key")
+	}
+	if strings.EqualFold(kv.ObjectFormat, types.ObjectFormatJWK) {
+		return fmt.Errorf("releaseKey is not supported with objectFormat: jwk")
+	}
+	if _, err := getReleaseAlgorithm(kv.ReleaseAlgorithm); err != nil {
+		return err
+	}
+	return nil
+}
+
 // This validate will make sure fileName:
 // 1. is not abs path
 // 2. does not contain any '..' elements
@@ -83,3 +247,127 @@ func validateFileName(fileName string) error {
 	}
 	return nil
 }
+
+// validateKeystore checks if the certificate of the object can be written as a keystore
+func validateKeystore(kv types.KeyVaultObject) error {
+	if !isKeystoreObject(kv) {
+		if kv.KeystoreAlias != "" || kv.KeystoreEncryption != "" {
+			return fmt.Errorf("keystoreAlias and keystoreEncryption are only supported with objectFormat: pkcs12 or jks")
+		}
+		// the password of an encrypted private key is checked with the private key format
+		if kv.ObjectPasswordSecret != "" && !strings.EqualFold(kv.PrivateKeyFormat, types.PrivateKeyFormatEncryptedPKCS8) {
+			return fmt.Errorf("objectPasswordSecret is only supported with objectFormat: pkcs12 or jks, or privateKeyFormat: encrypted-pkcs8")
+		}
+		return nil
+	}
+	objectFormat := strings.ToLower(kv.ObjectFormat)
+	// the keystore is built from the certificate and key returned as a secret
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return fmt.Errorf("objectFormat: %s only supported for objectType: secret", objectFormat)
+	}
+	if kv.ObjectPasswordSecret == "" {
+		return fmt.Errorf("objectFormat: %s requires objectPasswordSecret", objectFormat)
+	}
+	if kv.ObjectEncoding != "" {
+		return fmt.Errorf("objectEncoding is not supported with objectFormat: %s", objectFormat)
+	}
+	if kv.KeystoreEncryption == "" {
+		return nil
+	}
+	// the encryption of a JKS keystore is defined by the format
+	if objectFormat == types.ObjectFormatJKS {
+		return fmt.Errorf("keystoreEncryption is only supported with objectFormat: pkcs12")
+	}
+	if !strings.EqualFold(kv.KeystoreEncryption, types.KeystoreEncryptionAES256) && !strings.EqualFold(kv.KeystoreEncryption, types.KeystoreEncryptionDES3) {
+		return fmt.Errorf("invalid keystoreEncryption: %s, should be aes256 or des3", kv.KeystoreEncryption)
+	}
+	return nil
+}
+
+// validateCertChainFiles checks if the certificate chain files can be written for the object
+func validateCertChainFiles(kv types.KeyVaultObject) error {
+	if !kv.WriteCertChainFiles {
+		if kv.IncompleteCertChain != "" {
+			return fmt.Errorf("incompleteCertChain is only supported with writeCertChainFiles")
+		}
+		return nil
+	}
+	// the chain is built from the certificates returned as a secret
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return fmt.Errorf("writeCertChainFiles only supported for objectType: secret")
+	}
+	if kv.ObjectFormat != "" && !strings.EqualFold(kv.ObjectFormat, types.ObjectFormatPEM) {
+		return fmt.Errorf("writeCertChainFiles is not supported with objectFormat: %s", strings.ToLower(kv.ObjectFormat))
+	}
+	if kv.ObjectEncoding != "" {
+		return fmt.Errorf("writeCertChainFiles is not supported with objectEncoding")
+	}
+	if kv.IsJSONSelector() {
+		return fmt.Errorf("writeCertChainFiles is not supported with objectJSONPath and jsonKeys")
+	}
+	if kv.IncompleteCertChain != "" && !strings.EqualFold(kv.IncompleteCertChain, types.IncompleteCertChainFail) && !strings.EqualFold(kv.IncompleteCertChain, types.IncompleteCertChainWarn) {
+		return fmt.Errorf("invalid incompleteCertChain: %s, should be fail or warn", kv.IncompleteCertChain)
+	}
+	return nil
+}
+
+// validateCertKeySplit checks if the settings that split the cert and key of the object in separate files are valid
+func validateCertKeySplit(kv types.KeyVaultObject) error {
+	if kv.WriteCertAndKeyInSeparateFiles == nil && kv.WriteCombinedCertAndKey == nil && kv.CertFileSuffix == "" && kv.KeyFileSuffix == "" {
+		return nil
+	}
+	// the cert and key of a certificate are only returned as a secret
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return fmt.Errorf("writeCertAndKeyInSeparateFiles, writeCombinedCertAndKey, certFileSuffix and keyFileSuffix only supported for objectType: secret")
+	}
+	if strings.ContainsAny(kv.CertFileSuffix, `/\`) {
+		return fmt.Errorf("invalid certFileSuffix: %s, must not contain a path separator", kv.CertFileSuffix)
+	}
+	if strings.ContainsAny(kv.KeyFileSuffix, `/\`) {
+		return fmt.Errorf("invalid keyFileSuffix: %s, must not contain a path separator", kv.KeyFileSuffix)
+	}
+	if kv.GetCertFileSuffix() == kv.GetKeyFileSuffix() {
+		return fmt.Errorf("certFileSuffix and keyFileSuffix must be different")
+	}
+	if kv.IsWritingCombinedCertAndKey() {
+		return nil
+	}
+	if !kv.IsWritingCertAndKeyInSeparateFiles(false) {
+		return fmt.Errorf("writeCombinedCertAndKey: false requires writeCertAndKeyInSeparateFiles")
+	}
+	// the file of the object is the only file written for these formats
+	if strings.EqualFold(kv.ObjectFormat, types.ObjectFormatPFX) || isKeystoreObject(kv) {
+		return fmt.Errorf("writeCombinedCertAndKey: false is not supported with objectFormat: %s", strings.ToLower(kv.ObjectFormat))
+	}
+	return nil
+}
+
+// validatePrivateKeyFormat checks if the private key of the object can be written in the private key format
+func validatePrivateKeyFormat(kv types.KeyVaultObject) error {
+	if kv.PrivateKeyFormat == "" {
+		return nil
+	}
+	// the private key of a certificate is only returned as a secret
+	if kv.ObjectType != types.VaultObjectTypeSecret {
+		return fmt.Errorf("privateKeyFormat only supported for objectType: secret")
+	}
+	privateKeyFormat := strings.ToLower(kv.PrivateKeyFormat)
+	switch privateKeyFormat {
+	case types.PrivateKeyFormatPKCS8, types.PrivateKeyFormatPKCS1, types.PrivateKeyFormatSEC1, types.PrivateKeyFormatEncryptedPKCS8:
+	default:
+		return fmt.Errorf("invalid privateKeyFormat: %s, should be pkcs8, pkcs1, sec1 or encrypted-pkcs8", kv.PrivateKeyFormat)
+	}
+	if kv.ObjectFormat != "" && !strings.EqualFold(kv.ObjectFormat, types.ObjectFormatPEM) {
+		return fmt.Errorf("privateKeyFormat is not supported with objectFormat: %s", strings.ToLower(kv.ObjectFormat))
+	}
+	if kv.ObjectEncoding != "" {
+		return fmt.Errorf("privateKeyFormat is not supported with objectEncoding")
+	}
+	if kv.IsJSONSelector() {
+		return fmt.Errorf("privateKeyFormat is not supported with objectJSONPath and jsonKeys")
+	}
+	if privateKeyFormat == types.PrivateKeyFormatEncryptedPKCS8 && kv.ObjectPasswordSecret == "" {
+		return fmt.Errorf("privateKeyFormat: encrypted-pkcs8 requires objectPasswordSecret")
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package provider
 import (
 	"fmt"
 	"testing"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
 
 func TestValidateObjectFormat(t *testing.T) {
@@ -22,7 +24,7 @@ func TestValidateObjectFormat(t *testing.T) {
 			desc:         "object format not valid",
 			objectFormat: "pkcs",
 			objectType:   "secret",
-			expectedErr:  fmt.Errorf("invalid objectFormat: pkcs, should be PEM or PFX"),
+			expectedErr:  fmt.Errorf("invalid objectFormat: pkcs, should be PEM, PFX, JWK, PKCS12 or JKS"),
 		},
 		{
 			desc:         "object format PFX, but object type not secret",
@@ -42,6 +44,18 @@ func TestValidateObjectFormat(t *testing.T) {
 			objectType:   "secret",
 			expectedErr:  nil,
 		},
+		{
+			desc:         "object format JWK for key",
+			objectFormat: "JWK",
+			objectType:   "key",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format JWK, but object type not key",
+			objectFormat: "jwk",
+			objectType:   "cert",
+			expectedErr:  fmt.Errorf("JWK format only supported for objectType: key"),
+		},
 	}
 
 	for _, tc := range cases {
@@ -103,6 +117,564 @@ func TestValidateObjectEncoding(t *testing.T) {
 	}
 }
 
+func TestValidateObjectVault(t *testing.T) {
+	cases := []struct {
+		desc         string
+		keyvaultName string
+		vaultURI     string
+		expectedErr  error
+	}{
+		{
+			desc:        "no vault specified",
+			expectedErr: nil,
+		},
+		{
+			desc:         "keyvault name specified",
+			keyvaultName: "kv",
+			expectedErr:  nil,
+		},
+		{
+			desc:        "vault uri specified",
+			vaultURI:    "https://kv.vault.azure.net/",
+			expectedErr: nil,
+		},
+		{
+			desc:         "keyvault name and vault uri specified",
+			keyvaultName: "kv",
+			vaultURI:     "https://kv.vault.azure.net/",
+			expectedErr:  fmt.Errorf("keyvaultName and vaultURI are mutually exclusive"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateObjectVault(tc.keyvaultName, tc.vaultURI)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateVaultType(t *testing.T) {
+	cases := []struct {
+		desc        string
+		vaultType   string
+		objectType  string
+		expectedErr error
+	}{
+		{
+			desc:        "no vault type specified",
+			vaultType:   "",
+			objectType:  "secret",
+			expectedErr: nil,
+		},
+		{
+			desc:        "vault type keyvault",
+			vaultType:   "keyvault",
+			objectType:  "cert",
+			expectedErr: nil,
+		},
+		{
+			desc:        "vault type not valid",
+			vaultType:   "hsm",
+			objectType:  "key",
+			expectedErr: fmt.Errorf("invalid vaultType: hsm, should be keyvault or managedHSM"),
+		},
+		{
+			desc:        "vault type managedHSM, but object type not key",
+			vaultType:   "managedHSM",
+			objectType:  "secret",
+			expectedErr: fmt.Errorf("vaultType managedHSM only supported for objectType: key"),
+		},
+		{
+			desc:        "vault type managedHSM case insensitive check",
+			vaultType:   "MANAGEDHSM",
+			objectType:  "key",
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateVaultType(tc.vaultType, tc.objectType)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateObjectSelector(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "object name prefix",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object name regex",
+			object:      types.KeyVaultObject{ObjectNameRegex: "payments-.*", ObjectType: "secret", MaxObjects: 10},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object name and prefix",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectNamePrefix: "payments-", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("objectName is mutually exclusive with objectNamePrefix, objectNameRegex and objectTags"),
+		},
+		{
+			desc:        "object name prefix and regex",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectNameRegex: "payments-.*", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("objectNamePrefix and objectNameRegex are mutually exclusive"),
+		},
+		{
+			desc:        "object version",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectVersion: "v1", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("objectVersion is not supported with objectNamePrefix, objectNameRegex or objectTags"),
+		},
+		{
+			desc:        "object tags",
+			object:      types.KeyVaultObject{ObjectTags: "env=prod,team=payments", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object name and tags",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectTags: "env=prod", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("objectName is mutually exclusive with objectNamePrefix, objectNameRegex and objectTags"),
+		},
+		{
+			desc:        "invalid object tags",
+			object:      types.KeyVaultObject{ObjectTags: "env", ObjectType: "secret"},
+			expectedErr: fmt.Errorf(`invalid objectTags: invalid tag "env", should be in the format key=value`),
+		},
+		{
+			desc:        "object version tags",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectVersionTags: "stage=approved", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object version and version tags",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectVersion: "v1", ObjectVersionTags: "stage=approved", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("objectVersion and objectVersionTags are mutually exclusive"),
+		},
+		{
+			desc:        "invalid object version tags",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectVersionTags: "=approved", ObjectType: "secret"},
+			expectedErr: fmt.Errorf(`invalid objectVersionTags: invalid tag "=approved", should be in the format key=value`),
+		},
+		{
+			desc:        "negative max objects",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "payments-", ObjectType: "secret", MaxObjects: -1},
+			expectedErr: fmt.Errorf("maxObjects must be greater than 0"),
+		},
+		{
+			desc:        "invalid regex",
+			object:      types.KeyVaultObject{ObjectNameRegex: "payments-(", ObjectType: "secret"},
+			expectedErr: fmt.Errorf("invalid objectNameRegex: error parsing regexp: missing closing ): `payments-(`"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateObjectJSON(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "object json path",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectJSONPath: "database.hosts.0"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "json keys",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectEncoding: "base64", JSONKeys: "username, password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type key",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", JSONKeys: "username"},
+			expectedErr: fmt.Errorf("objectJSONPath and jsonKeys only supported for objectType: secret"),
+		},
+		{
+			desc:        "object format pfx",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectFormat: "pfx", ObjectJSONPath: "password"},
+			expectedErr: fmt.Errorf("objectJSONPath and jsonKeys are not supported with objectFormat: pfx"),
+		},
+		{
+			desc:        "empty path segment",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectJSONPath: "database..password"},
+			expectedErr: fmt.Errorf(`invalid objectJSONPath: invalid path "database..password", must not contain empty segments`),
+		},
+		{
+			desc:        "empty json key",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", JSONKeys: "username,"},
+			expectedErr: fmt.Errorf("invalid jsonKeys: key must not be empty"),
+		},
+		{
+			desc:        "json key with path separator",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", JSONKeys: "../password"},
+			expectedErr: fmt.Errorf(`invalid jsonKeys: invalid key "../password", must be a valid file name`),
+		},
+		{
+			desc:        "duplicate json key",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", JSONKeys: "username,username"},
+			expectedErr: fmt.Errorf(`invalid jsonKeys: duplicate key "username"`),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateEnvName(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "valid env name",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", EnvName: "_DB_PASSWORD1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "env name starts with a digit",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", EnvName: "1PASSWORD"},
+			expectedErr: fmt.Errorf("invalid envName: 1PASSWORD, must start with a letter or underscore and only contain letters, digits and underscores"),
+		},
+		{
+			desc:        "env name with dash",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", EnvName: "DB-PASSWORD"},
+			expectedErr: fmt.Errorf("invalid envName: DB-PASSWORD, must start with a letter or underscore and only contain letters, digits and underscores"),
+		},
+		{
+			desc:        "env name with selector",
+			object:      types.KeyVaultObject{ObjectNamePrefix: "db-", ObjectType: "secret", EnvName: "DB"},
+			expectedErr: fmt.Errorf("envName is not supported with objectNamePrefix, objectNameRegex or objectTags"),
+		},
+		{
+			desc:        "env name with version history",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectVersionHistory: 2, EnvName: "DB"},
+			expectedErr: fmt.Errorf("envName is not supported with objectVersionHistory"),
+		},
+		{
+			desc:        "env name with json keys",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", JSONKeys: "username", EnvName: "DB"},
+			expectedErr: fmt.Errorf("envName is not supported with jsonKeys"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeyRelease(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "release key",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", ReleaseKey: true},
+			expectedErr: nil,
+		},
+		{
+			desc:        "release algorithm",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", ReleaseKey: true, ReleaseAlgorithm: "ckm_rsa_aes_key_wrap"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "release algorithm without release key",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", ReleaseAlgorithm: "RSA_AES_KEY_WRAP_256"},
+			expectedErr: fmt.Errorf("releaseAlgorithm is only supported with releaseKey"),
+		},
+		{
+			desc:        "object type secret",
+			object:      types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ReleaseKey: true},
+			expectedErr: fmt.Errorf("releaseKey only supported for objectType: key"),
+		},
+		{
+			desc:        "object format jwk",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", ObjectFormat: "jwk", ReleaseKey: true},
+			expectedErr: fmt.Errorf("releaseKey is not supported with objectFormat: jwk"),
+		},
+		{
+			desc:        "invalid algorithm",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", ReleaseKey: true, ReleaseAlgorithm: "RSA-OAEP"},
+			expectedErr: fmt.Errorf("invalid releaseAlgorithm: RSA-OAEP, should be CKM_RSA_AES_KEY_WRAP, RSA_AES_KEY_WRAP_256 or RSA_AES_KEY_WRAP_384"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeystore(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "pkcs12",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "PKCS12", ObjectPasswordSecret: "password1", KeystoreAlias: "server", KeystoreEncryption: "DES3"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "jks",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "jks", ObjectPasswordSecret: "password1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "keystore settings without keystore format",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pfx", ObjectPasswordSecret: "password1"},
+			expectedErr: fmt.Errorf("objectPasswordSecret is only supported with objectFormat: pkcs12 or jks, or privateKeyFormat: encrypted-pkcs8"),
+		},
+		{
+			desc:        "keystore alias without keystore format",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", KeystoreAlias: "server"},
+			expectedErr: fmt.Errorf("keystoreAlias and keystoreEncryption are only supported with objectFormat: pkcs12 or jks"),
+		},
+		{
+			desc:        "object type cert",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "cert", ObjectFormat: "jks", ObjectPasswordSecret: "password1"},
+			expectedErr: fmt.Errorf("objectFormat: jks only supported for objectType: secret"),
+		},
+		{
+			desc:        "no password secret",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pkcs12"},
+			expectedErr: fmt.Errorf("objectFormat: pkcs12 requires objectPasswordSecret"),
+		},
+		{
+			desc:        "object encoding",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pkcs12", ObjectPasswordSecret: "password1", ObjectEncoding: "base64"},
+			expectedErr: fmt.Errorf("objectEncoding is not supported with objectFormat: pkcs12"),
+		},
+		{
+			desc:        "keystore encryption with jks",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "jks", ObjectPasswordSecret: "password1", KeystoreEncryption: "aes256"},
+			expectedErr: fmt.Errorf("keystoreEncryption is only supported with objectFormat: pkcs12"),
+		},
+		{
+			desc:        "invalid keystore encryption",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pkcs12", ObjectPasswordSecret: "password1", KeystoreEncryption: "rc2"},
+			expectedErr: fmt.Errorf("invalid keystoreEncryption: rc2, should be aes256 or des3"),
+		},
+		{
+			desc:        "json keys",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "jks", ObjectPasswordSecret: "password1", JSONKeys: "key"},
+			expectedErr: fmt.Errorf("objectJSONPath and jsonKeys are not supported with objectFormat: jks"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCertChainFiles(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "cert chain files",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "PEM", WriteCertChainFiles: true, IncompleteCertChain: "Warn"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "incomplete cert chain without cert chain files",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", IncompleteCertChain: "warn"},
+			expectedErr: fmt.Errorf("incompleteCertChain is only supported with writeCertChainFiles"),
+		},
+		{
+			desc:        "object type cert",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "cert", WriteCertChainFiles: true},
+			expectedErr: fmt.Errorf("writeCertChainFiles only supported for objectType: secret"),
+		},
+		{
+			desc:        "object format pfx",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pfx", WriteCertChainFiles: true},
+			expectedErr: fmt.Errorf("writeCertChainFiles is not supported with objectFormat: pfx"),
+		},
+		{
+			desc:        "object encoding",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectEncoding: "base64", WriteCertChainFiles: true},
+			expectedErr: fmt.Errorf("writeCertChainFiles is not supported with objectEncoding"),
+		},
+		{
+			desc:        "json keys",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", JSONKeys: "key", WriteCertChainFiles: true},
+			expectedErr: fmt.Errorf("writeCertChainFiles is not supported with objectJSONPath and jsonKeys"),
+		},
+		{
+			desc:        "invalid incomplete cert chain",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", WriteCertChainFiles: true, IncompleteCertChain: "ignore"},
+			expectedErr: fmt.Errorf("invalid incompleteCertChain: ignore, should be fail or warn"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCertKeySplit(t *testing.T) {
+	enabled, disabled := true, false
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "separate files without the combined file",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", WriteCertAndKeyInSeparateFiles: &enabled, WriteCombinedCertAndKey: &disabled, CertFileSuffix: ".pem", KeyFileSuffix: "-key.pem"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type cert",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "cert", WriteCertAndKeyInSeparateFiles: &enabled},
+			expectedErr: fmt.Errorf("writeCertAndKeyInSeparateFiles, writeCombinedCertAndKey, certFileSuffix and keyFileSuffix only supported for objectType: secret"),
+		},
+		{
+			desc:        "cert file suffix with path separator",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", CertFileSuffix: "/tls.crt"},
+			expectedErr: fmt.Errorf("invalid certFileSuffix: /tls.crt, must not contain a path separator"),
+		},
+		{
+			desc:        "key file suffix with path separator",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", KeyFileSuffix: `\tls.key`},
+			expectedErr: fmt.Errorf(`invalid keyFileSuffix: \tls.key, must not contain a path separator`),
+		},
+		{
+			desc:        "same cert and key file suffix",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", KeyFileSuffix: ".crt"},
+			expectedErr: fmt.Errorf("certFileSuffix and keyFileSuffix must be different"),
+		},
+		{
+			desc:        "no combined file without separate files",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", WriteCertAndKeyInSeparateFiles: &disabled, WriteCombinedCertAndKey: &disabled},
+			expectedErr: fmt.Errorf("writeCombinedCertAndKey: false requires writeCertAndKeyInSeparateFiles"),
+		},
+		{
+			desc:        "no combined file with object format pfx",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "PFX", WriteCertAndKeyInSeparateFiles: &enabled, WriteCombinedCertAndKey: &disabled},
+			expectedErr: fmt.Errorf("writeCombinedCertAndKey: false is not supported with objectFormat: pfx"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePrivateKeyFormat(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      types.KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "pkcs1",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", PrivateKeyFormat: "PKCS1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "encrypted pkcs8",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", PrivateKeyFormat: "encrypted-pkcs8", ObjectPasswordSecret: "password1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type key",
+			object:      types.KeyVaultObject{ObjectName: "key1", ObjectType: "key", PrivateKeyFormat: "sec1"},
+			expectedErr: fmt.Errorf("privateKeyFormat only supported for objectType: secret"),
+		},
+		{
+			desc:        "invalid private key format",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", PrivateKeyFormat: "der"},
+			expectedErr: fmt.Errorf("invalid privateKeyFormat: der, should be pkcs8, pkcs1, sec1 or encrypted-pkcs8"),
+		},
+		{
+			desc:        "object format pfx",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectFormat: "pfx", PrivateKeyFormat: "pkcs1"},
+			expectedErr: fmt.Errorf("privateKeyFormat is not supported with objectFormat: pfx"),
+		},
+		{
+			desc:        "object encoding",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectEncoding: "hex", PrivateKeyFormat: "pkcs1"},
+			expectedErr: fmt.Errorf("privateKeyFormat is not supported with objectEncoding"),
+		},
+		{
+			desc:        "json path",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", ObjectJSONPath: "key", PrivateKeyFormat: "pkcs1"},
+			expectedErr: fmt.Errorf("privateKeyFormat is not supported with objectJSONPath and jsonKeys"),
+		},
+		{
+			desc:        "encrypted pkcs8 without password",
+			object:      types.KeyVaultObject{ObjectName: "cert1", ObjectType: "secret", PrivateKeyFormat: "encrypted-pkcs8"},
+			expectedErr: fmt.Errorf("privateKeyFormat: encrypted-pkcs8 requires objectPasswordSecret"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validate(tc.object)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
 func TestValidateFilePath(t *testing.T) {
 	cases := []struct {
 		desc        string
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/azure"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/fallback"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
 
@@ -26,9 +28,9 @@ type CSIDriverProviderServer struct {
 }
 
 // New returns an instance of CSIDriverProviderServer
-func New(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment azure.Environment) *CSIDriverProviderServer {
+func New(constructPEMChain, writeCertAndKeyInSeparateFiles bool, objectFetchConcurrency int, clientCacheTTL time.Duration, clientCacheSize int, fallbackStore *fallback.Store, allowedVaultHosts []string, keyRelease provider.KeyReleaseConfig, defaultCloudEnvironment azure.Environment) *CSIDriverProviderServer {
 	return &CSIDriverProviderServer{
-		provider: provider.NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles, defaultCloudEnvironment),
+		provider: provider.NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles, objectFetchConcurrency, clientCacheTTL, clientCacheSize, fallbackStore, allowedVaultHosts, keyRelease, defaultCloudEnvironment),
 	}
 }
 